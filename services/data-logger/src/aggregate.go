@@ -0,0 +1,228 @@
+/*
+aggregate.go
+Implements aggregations, which compute statistics of a numeric payload field
+per time bucket and optionally per value of another payload field. Data items
+are aggregated while they are read.
+
+###################################################################################
+
+MIT License
+
+Copyright (c) 2020 Bruno Hautzenberger
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+)
+
+//MinBucketSize is the smallest duration of time buckets
+const MinBucketSize = time.Second
+
+//ErrInvalidAggregation is returned for invalid aggregations
+var ErrInvalidAggregation = errors.New("Invalid aggregation")
+
+//Aggregation defines how data items of a time range are aggregated.
+//BucketSize 0 aggregates the whole time range in a single bucket. Field is
+//the path of the numeric payload field, only data items are counted, if it
+//is empty. GroupBy is the path of the payload field grouping data items
+//within a bucket, if it is not empty.
+type Aggregation struct {
+	BucketSize  time.Duration
+	Field       []string
+	GroupBy     []string
+	Percentiles []float64
+	Filters     []Filter
+}
+
+//bucketKey identifies a bucket by its start and the json encoded value of
+//its group
+type bucketKey struct {
+	Start int64
+	Group string
+}
+
+//bucket accumulates the values of a single bucket
+type bucket struct {
+	Start  time.Time
+	Group  interface{}
+	Count  int
+	Sum    float64
+	Min    float64
+	Max    float64
+	Values []float64
+}
+
+//add adds a value to the bucket.
+func (b *bucket) add(value float64, keepValues bool) {
+	if b.Count == 0 || value < b.Min {
+		b.Min = value
+	}
+	if b.Count == 0 || value > b.Max {
+		b.Max = value
+	}
+
+	b.Count++
+	b.Sum += value
+	if keepValues {
+		b.Values = append(b.Values, value)
+	}
+}
+
+//bucketEpoch is the time all buckets are aligned to.
+var bucketEpoch = time.Unix(0, 0).UTC()
+
+//bucketStart returns the start of the bucket of given time. Buckets are
+//aligned to the Unix epoch instead of the zero time used by Truncate, so
+//daily buckets start at midnight UTC and weekly buckets on Thursdays.
+func (a Aggregation) bucketStart(t time.Time, timeRange TimeRange) time.Time {
+	if a.BucketSize == 0 {
+		return timeRange.Start.UTC()
+	}
+
+	offset := t.Sub(bucketEpoch) % a.BucketSize
+	if offset < 0 {
+		offset += a.BucketSize
+	}
+
+	return t.UTC().Add(-offset)
+}
+
+//RunAggregation reads the data items of a collection in a time range and
+//returns the statistics of all buckets containing data items, sorted by their
+//start and group.
+func RunAggregation(storage StorageInterface, collectionName string, timeRange TimeRange, aggregation Aggregation) ([]BucketMessageType, error) {
+	buckets := make(map[bucketKey]*bucket)
+	query := Query{Filters: aggregation.Filters}
+	keepValues := len(aggregation.Percentiles) > 0
+
+	err := storage.EachData(collectionName, timeRange, func(data *Data) error {
+		if !query.Matches(data) {
+			return nil
+		}
+
+		value := 0.0
+		if len(aggregation.Field) > 0 {
+			field, ok := lookupPath(data.Payload, aggregation.Field)
+			number, isNumber := field.(float64)
+			if !ok || !isNumber {
+				return nil
+			}
+			value = number
+		}
+
+		var group interface{}
+		if len(aggregation.GroupBy) > 0 {
+			group, _ = lookupPath(data.Payload, aggregation.GroupBy)
+		}
+
+		groupKey, err := json.Marshal(group)
+		if err != nil {
+			return err
+		}
+
+		start := aggregation.bucketStart(data.CreatedAt, timeRange)
+		key := bucketKey{Start: start.UnixNano(), Group: string(groupKey)}
+		b, ok := buckets[key]
+		if !ok {
+			b = &bucket{Start: start, Group: group}
+			buckets[key] = b
+		}
+		b.add(value, keepValues)
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]bucketKey, 0, len(buckets))
+	for key := range buckets {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].Start != keys[j].Start {
+			return keys[i].Start < keys[j].Start
+		}
+		return keys[i].Group < keys[j].Group
+	})
+
+	result := make([]BucketMessageType, 0, len(keys))
+	for _, key := range keys {
+		result = append(result, aggregation.toBucketMessageType(buckets[key], timeRange))
+	}
+
+	return result, nil
+}
+
+//toBucketMessageType transforms a bucket to a BucketMessageType. The
+//statistics of the field are only set, if a field is aggregated.
+func (a Aggregation) toBucketMessageType(b *bucket, timeRange TimeRange) BucketMessageType {
+	msg := BucketMessageType{
+		Start: b.Start,
+		End:   b.Start.Add(a.BucketSize),
+		Group: b.Group,
+		Count: b.Count,
+	}
+
+	if a.BucketSize == 0 {
+		msg.End = timeRange.End.UTC()
+	}
+
+	if len(a.Field) == 0 {
+		return msg
+	}
+
+	avg := b.Sum / float64(b.Count)
+	msg.Sum = &b.Sum
+	msg.Avg = &avg
+	msg.Min = &b.Min
+	msg.Max = &b.Max
+
+	if len(a.Percentiles) > 0 {
+		sort.Float64s(b.Values)
+		msg.Percentiles = make(map[string]float64, len(a.Percentiles))
+		for _, p := range a.Percentiles {
+			msg.Percentiles["p"+strconv.FormatFloat(p, 'f', -1, 64)] = percentile(b.Values, p)
+		}
+	}
+
+	return msg
+}
+
+//percentile returns the p-th percentile of sorted values, interpolating
+//linearly between the closest ranks.
+func percentile(values []float64, p float64) float64 {
+	if len(values) == 1 {
+		return values[0]
+	}
+
+	rank := p / 100 * float64(len(values)-1)
+	lower := int(math.Floor(rank))
+	upper := int(math.Ceil(rank))
+
+	return values[lower] + (values[upper]-values[lower])*(rank-float64(lower))
+}
@@ -0,0 +1,69 @@
+/*
+aggregate_test.go
+Tests of the alignment of aggregation buckets.
+
+###################################################################################
+
+MIT License
+
+Copyright (c) 2020 Bruno Hautzenberger
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBucketStart(t *testing.T) {
+	tests := []struct {
+		size     time.Duration
+		time     string
+		expected string
+	}{
+		{time.Minute, "2020-09-01T10:30:59.999Z", "2020-09-01T10:30:00Z"},
+		{5 * time.Minute, "2020-09-01T10:34:00Z", "2020-09-01T10:30:00Z"},
+		{time.Hour, "2020-09-01T10:30:00+02:00", "2020-09-01T08:00:00Z"},
+		{24 * time.Hour, "2020-09-01T23:59:59Z", "2020-09-01T00:00:00Z"},
+		{24 * time.Hour, "2020-09-02T01:00:00+02:00", "2020-09-01T00:00:00Z"},
+		{7 * 24 * time.Hour, "2020-09-01T10:00:00Z", "2020-08-27T00:00:00Z"},
+		{7 * 24 * time.Hour, "2020-09-03T00:00:00Z", "2020-09-03T00:00:00Z"},
+		{7 * time.Hour, "1970-01-01T13:59:59Z", "1970-01-01T07:00:00Z"},
+		{7 * time.Hour, "1969-12-31T20:00:00Z", "1969-12-31T17:00:00Z"},
+	}
+
+	for _, test := range tests {
+		aggregation := Aggregation{BucketSize: test.size}
+		start := aggregation.bucketStart(mustParse(t, test.time), TimeRange{})
+		if !start.Equal(mustParse(t, test.expected)) || start.Location() != time.UTC {
+			t.Errorf("bucket of %v with size %v starts at %v, expected %v", test.time, test.size, start, test.expected)
+		}
+	}
+}
+
+func TestBucketStartWithoutSize(t *testing.T) {
+	timeRange := TimeRange{Start: mustParse(t, "2020-09-01T10:17:00+02:00")}
+
+	start := Aggregation{}.bucketStart(mustParse(t, "2020-09-01T12:00:00Z"), timeRange)
+	if !start.Equal(timeRange.Start) {
+		t.Errorf("bucket starts at %v, expected the start of the time range %v", start, timeRange.Start)
+	}
+}
@@ -31,9 +31,10 @@ package main
 
 import (
 	"encoding/json"
-	"errors"
 	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gorilla/mux"
@@ -42,32 +43,192 @@ import (
 //APIInterface defines the interface of the RESTful API
 type APIInterface interface {
 	Query(w http.ResponseWriter, r *http.Request)
+	QueryBody(w http.ResponseWriter, r *http.Request)
+	Aggregate(w http.ResponseWriter, r *http.Request)
+	GetRetention(w http.ResponseWriter, r *http.Request)
+	SetRetention(w http.ResponseWriter, r *http.Request)
 	Write(w http.ResponseWriter, r *http.Request)
 	Collections(w http.ResponseWriter, r *http.Request)
-	Initialize(storage StorageInterface)
+	Initialize(storage StorageInterface, config APIConfig)
 }
 
 //API implements APIInterface
 type API struct {
 	Storage StorageInterface
+	Config  APIConfig
 }
 
-//Initialize initializes the API by setting the active storage
-func (a *API) Initialize(storage StorageInterface) {
+//Initialize initializes the API by setting the active storage and the
+//configuration
+func (a *API) Initialize(storage StorageInterface, config APIConfig) {
 	a.Storage = storage
+	a.Config = config
 }
 
-func (a *API) GetDateFilter(name string, r *http.Request) (time.Time, error) {
+//GetDateFilter reads an RFC3339 date of given query parameter. It returns
+//false, if the parameter is not set.
+func (a *API) GetDateFilter(name string, r *http.Request) (time.Time, bool, error) {
 	val := r.FormValue(name)
 	if len(val) == 0 {
-		return time.Now().UTC(), errors.New("Value missing")
+		return time.Time{}, false, nil
 	}
 
-	return time.Parse(time.RFC3339, val)
+	date, err := time.Parse(time.RFC3339, val)
+	return date, true, err
+}
+
+//GetTimeRange reads the time range of a query. The start is either given
+//inclusive by "from" or exclusive by "after", the end is either given
+//inclusive by "to" or exclusive by "before". The end defaults to now. If the
+//start is not given, it defaults to the duration of "last" (e.g. 1h or 30m)
+//or to DefaultQueryRange before the end. Time ranges longer than the
+//configured MaxQueryRange are rejected.
+func (a *API) GetTimeRange(r *http.Request) (TimeRange, *ErrorMessage) {
+	timeRange := TimeRange{}
+
+	from, hasFrom, err := a.GetDateFilter("from", r)
+	if err != nil {
+		return timeRange, &ErrorMessage{"Invalid from-date", http.StatusBadRequest, ErrorCodeInvalidFromDate}
+	}
+
+	after, hasAfter, err := a.GetDateFilter("after", r)
+	if err != nil {
+		return timeRange, &ErrorMessage{"Invalid after-date", http.StatusBadRequest, ErrorCodeInvalidFromDate}
+	}
+
+	to, hasTo, err := a.GetDateFilter("to", r)
+	if err != nil {
+		return timeRange, &ErrorMessage{"Invalid to-date", http.StatusBadRequest, ErrorCodeInvalidToDate}
+	}
+
+	before, hasBefore, err := a.GetDateFilter("before", r)
+	if err != nil {
+		return timeRange, &ErrorMessage{"Invalid before-date", http.StatusBadRequest, ErrorCodeInvalidToDate}
+	}
+
+	last := r.FormValue("last")
+	if (hasFrom && hasAfter) || (hasTo && hasBefore) || ((hasFrom || hasAfter) && len(last) > 0) {
+		return timeRange, &ErrorMessage{"Only one of from, after and last and one of to and before can be used", http.StatusBadRequest, ErrorCodeInvalidTimeRange}
+	}
+
+	switch {
+	case hasTo:
+		timeRange.End = to
+	case hasBefore:
+		timeRange.End = before
+		timeRange.EndExclusive = true
+	default:
+		timeRange.End = time.Now().UTC()
+	}
+
+	switch {
+	case hasFrom:
+		timeRange.Start = from
+	case hasAfter:
+		timeRange.Start = after
+		timeRange.StartExclusive = true
+	case len(last) > 0:
+		duration, err := time.ParseDuration(last)
+		if err != nil || duration <= 0 {
+			return timeRange, &ErrorMessage{"Invalid duration of last", http.StatusBadRequest, ErrorCodeInvalidDuration}
+		}
+		timeRange.Start = timeRange.End.Add(-duration)
+	default:
+		timeRange.Start = timeRange.End.Add(-DefaultQueryRange)
+	}
+
+	if err := timeRange.Validate(a.Config.MaxQueryRange); err != nil {
+		return timeRange, &ErrorMessage{err.Error(), http.StatusBadRequest, ErrorCodeInvalidTimeRange}
+	}
+
+	return timeRange, nil
+}
+
+//parseFilterValue parses the value of a filter as json or returns it as
+//string, if it is no valid json.
+func parseFilterValue(value string) interface{} {
+	var parsed interface{}
+	if err := json.Unmarshal([]byte(value), &parsed); err != nil {
+		return value
+	}
+	return parsed
+}
+
+//GetQuery reads a query from the query parameters. Every "where" parameter
+//is a filter written as field:op:value, where value is parsed as json or used
+//as string otherwise. Lists for in can also be given comma separated, whose
+//elements are parsed the same way. "fields" are the comma separated payload
+//fields returned.
+func (a *API) GetQuery(r *http.Request) (QueryMessageType, error) {
+	msg := QueryMessageType{
+		Sort:  r.FormValue("sort"),
+		Order: r.FormValue("order"),
+	}
+
+	for _, where := range r.Form["where"] {
+		parts := strings.SplitN(where, ":", 3)
+		if len(parts) < 2 {
+			return msg, fmt.Errorf("%v: filter %q must be field:op:value", ErrInvalidQuery, where)
+		}
+
+		filter := FilterMessageType{Field: parts[0], Op: FilterOp(parts[1])}
+		if len(parts) == 3 {
+			filter.Value = parseFilterValue(parts[2])
+
+			if _, ok := filter.Value.([]interface{}); filter.Op == FilterOpIn && !ok {
+				values := make([]interface{}, 0)
+				for _, value := range strings.Split(parts[2], ",") {
+					values = append(values, parseFilterValue(value))
+				}
+				filter.Value = values
+			}
+		}
+
+		msg.Filter = append(msg.Filter, filter)
+	}
+
+	if fields := r.FormValue("fields"); len(fields) > 0 {
+		msg.Fields = strings.Split(fields, ",")
+	}
+
+	for name, value := range map[string]*int{"limit": &msg.Limit, "offset": &msg.Offset} {
+		if val := r.FormValue(name); len(val) > 0 {
+			number, err := strconv.Atoi(val)
+			if err != nil {
+				return msg, fmt.Errorf("%v: invalid %v", ErrInvalidQuery, name)
+			}
+			*value = number
+		}
+	}
+
+	return msg, nil
 }
 
 //API handler to get data items
 func (a *API) Query(w http.ResponseWriter, r *http.Request) {
+	msg, err := a.GetQuery(r)
+	if err != nil {
+		RaiseError(w, err.Error(), http.StatusBadRequest, ErrorCodeInvalidQuery)
+		return
+	}
+
+	a.query(w, r, msg)
+}
+
+//API handler to get data items using a query given as json body
+func (a *API) QueryBody(w http.ResponseWriter, r *http.Request) {
+	msg := QueryMessageType{}
+	if err := json.NewDecoder(r.Body).Decode(&msg); err != nil {
+		RaiseError(w, "Invalid request body", http.StatusBadRequest, ErrorCodeInvalidRequestBody)
+		return
+	}
+
+	a.query(w, r, msg)
+}
+
+//query returns the data items of a collection found by given query in the
+//time range of the request.
+func (a *API) query(w http.ResponseWriter, r *http.Request, msg QueryMessageType) {
 	// Get Request Vars
 	vars := mux.Vars(r)
 	collectionName, ok := vars["collection"]
@@ -76,34 +237,135 @@ func (a *API) Query(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	startDate, err := a.GetDateFilter("from", r)
-	if err != nil {
-		RaiseError(w, "Invalid from-date", http.StatusBadRequest, ErrorCodeInvalidFromDate)
+	timeRange, errorMessage := a.GetTimeRange(r)
+	if errorMessage != nil {
+		RaiseError(w, errorMessage.Message, errorMessage.StatusCode, errorMessage.Code)
 		return
 	}
 
-	endDate, err := a.GetDateFilter("to", r)
+	query, err := QueryFromMessageType(msg)
 	if err != nil {
-		RaiseError(w, "Invalid to-date", http.StatusBadRequest, ErrorCodeInvalidToDate)
+		RaiseError(w, err.Error(), http.StatusBadRequest, ErrorCodeInvalidQuery)
 		return
 	}
 
 	// Load value
-	result, err := a.Storage.ReadData(collectionName, startDate, endDate)
+	result, err := RunQuery(a.Storage, collectionName, timeRange, query)
 	if err != nil {
 		RaiseError(w, "Error loading data.", http.StatusNotFound, ErrorCodeInternal)
 		return
 	}
 
 	// prepare message
-	msg := DataListMessageType{
+	response := DataListMessageType{
 		Data: result,
 	}
 
 	// Write Response
 	w.Header().Add("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(msg)
+	json.NewEncoder(w).Encode(response)
+}
+
+//GetAggregation reads an aggregation from the query parameters "bucket" (a
+//duration like 5m, 1h or 1d), "field", "group-by", "percentiles" (comma
+//separated, e.g. 50,95,99) and the same "where" filters as queries.
+func (a *API) GetAggregation(r *http.Request) (Aggregation, error) {
+	aggregation := Aggregation{}
+
+	if bucket := r.FormValue("bucket"); len(bucket) > 0 {
+		size, err := parseBucketSize(bucket)
+		if err != nil || size < MinBucketSize {
+			return aggregation, fmt.Errorf("%v: bucket must be a duration of at least %v", ErrInvalidAggregation, MinBucketSize)
+		}
+		aggregation.BucketSize = size
+	}
+
+	for name, path := range map[string]*[]string{"field": &aggregation.Field, "group-by": &aggregation.GroupBy} {
+		if field := r.FormValue(name); len(field) > 0 {
+			parts, err := splitPath(field)
+			if err != nil {
+				return aggregation, fmt.Errorf("%v: invalid %v", ErrInvalidAggregation, name)
+			}
+			*path = parts
+		}
+	}
+
+	if percentiles := r.FormValue("percentiles"); len(percentiles) > 0 {
+		if len(aggregation.Field) == 0 {
+			return aggregation, fmt.Errorf("%v: percentiles need a field", ErrInvalidAggregation)
+		}
+
+		for _, value := range strings.Split(percentiles, ",") {
+			p, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+			if err != nil || p < 0 || p > 100 {
+				return aggregation, fmt.Errorf("%v: percentiles must be between 0 and 100", ErrInvalidAggregation)
+			}
+			aggregation.Percentiles = append(aggregation.Percentiles, p)
+		}
+	}
+
+	msg, err := a.GetQuery(r)
+	if err != nil {
+		return aggregation, err
+	}
+
+	query, err := QueryFromMessageType(QueryMessageType{Filter: msg.Filter})
+	if err != nil {
+		return aggregation, err
+	}
+	aggregation.Filters = query.Filters
+
+	return aggregation, nil
+}
+
+//parseBucketSize parses durations like time.ParseDuration, but also accepts
+//days, e.g. 1d.
+func parseBucketSize(value string) (time.Duration, error) {
+	if strings.HasSuffix(value, "d") {
+		days, err := strconv.Atoi(strings.TrimSuffix(value, "d"))
+		if err != nil {
+			return 0, err
+		}
+		return time.Duration(days) * 24 * time.Hour, nil
+	}
+
+	return time.ParseDuration(value)
+}
+
+//API handler to aggregate data items by time buckets
+func (a *API) Aggregate(w http.ResponseWriter, r *http.Request) {
+	// Get Request Vars
+	vars := mux.Vars(r)
+	collectionName, ok := vars["collection"]
+	if !ok {
+		RaiseError(w, "Collection is missing", http.StatusBadRequest, ErrorCodeCollectionMissing)
+		return
+	}
+
+	timeRange, errorMessage := a.GetTimeRange(r)
+	if errorMessage != nil {
+		RaiseError(w, errorMessage.Message, errorMessage.StatusCode, errorMessage.Code)
+		return
+	}
+
+	aggregation, err := a.GetAggregation(r)
+	if err != nil {
+		RaiseError(w, err.Error(), http.StatusBadRequest, ErrorCodeInvalidAggregation)
+		return
+	}
+
+	buckets, err := RunAggregation(a.Storage, collectionName, timeRange, aggregation)
+	if err != nil {
+		RaiseError(w, "Error loading data.", http.StatusNotFound, ErrorCodeInternal)
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(AggregationResultMessageType{
+		Buckets: buckets,
+	})
 }
 
 //API handler to write new data items
@@ -123,12 +385,87 @@ func (a *API) Write(w http.ResponseWriter, r *http.Request) {
 	}
 
 	data, err := a.Storage.WriteData(collectionName, payload)
+	if err != nil {
+		RaiseError(w, fmt.Sprintf("Failed to write data: %v", err), http.StatusInternalServerError, ErrorCodeInternal)
+		return
+	}
 
 	w.Header().Add("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(data)
 }
 
+//API handler to get the retention policy and size of a collection
+func (a *API) GetRetention(w http.ResponseWriter, r *http.Request) {
+	// Get Request Vars
+	vars := mux.Vars(r)
+	collectionName, ok := vars["collection"]
+	if !ok {
+		RaiseError(w, "Collection is missing", http.StatusBadRequest, ErrorCodeCollectionMissing)
+		return
+	}
+
+	a.writeRetention(w, collectionName)
+}
+
+//API handler to change the retention policy of a collection
+func (a *API) SetRetention(w http.ResponseWriter, r *http.Request) {
+	// Get Request Vars
+	vars := mux.Vars(r)
+	collectionName, ok := vars["collection"]
+	if !ok {
+		RaiseError(w, "Collection is missing", http.StatusBadRequest, ErrorCodeCollectionMissing)
+		return
+	}
+
+	retention := Retention{}
+	if err := json.NewDecoder(r.Body).Decode(&retention); err != nil {
+		RaiseError(w, "Invalid request body", http.StatusBadRequest, ErrorCodeInvalidRequestBody)
+		return
+	}
+
+	err := a.Storage.SetRetention(collectionName, retention)
+	switch err {
+	case nil:
+	case ErrInvalidRetention, ErrArchiveDisabled:
+		RaiseError(w, err.Error(), http.StatusBadRequest, ErrorCodeInvalidRetention)
+		return
+	default:
+		RaiseError(w, fmt.Sprintf("Failed to save retention policy: %v", err), http.StatusInternalServerError, ErrorCodeInternal)
+		return
+	}
+
+	a.writeRetention(w, collectionName)
+}
+
+//writeRetention writes the retention policy and size of a collection as
+//response.
+func (a *API) writeRetention(w http.ResponseWriter, collectionName string) {
+	retention, err := a.Storage.GetRetention(collectionName)
+	if err == ErrCollectionNotFound {
+		RaiseError(w, fmt.Sprintf("Collection %v not found", collectionName), http.StatusNotFound, ErrorCodeCollectionNotFound)
+		return
+	}
+	if err != nil {
+		RaiseError(w, fmt.Sprintf("Failed to load retention policy: %v", err), http.StatusInternalServerError, ErrorCodeInternal)
+		return
+	}
+
+	size, dataFiles, err := a.Storage.CollectionSize(collectionName)
+	if err != nil {
+		RaiseError(w, fmt.Sprintf("Failed to load collection size: %v", err), http.StatusInternalServerError, ErrorCodeInternal)
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(RetentionStatusMessageType{
+		Retention: retention,
+		Size:      size,
+		DataFiles: dataFiles,
+	})
+}
+
 //API handler to get collections
 func (a *API) Collections(w http.ResponseWriter, r *http.Request) {
 	collections, err := a.Storage.ListCollections()
@@ -43,6 +43,12 @@ const (
 	ErrorCodeInvalidFromDate              = 2
 	ErrorCodeInvalidToDate                = 3
 	ErrorCodeInvalidRequestBody           = 4
+	ErrorCodeInvalidTimeRange             = 5
+	ErrorCodeInvalidDuration              = 6
+	ErrorCodeInvalidQuery                 = 7
+	ErrorCodeInvalidAggregation           = 8
+	ErrorCodeInvalidRetention             = 9
+	ErrorCodeCollectionNotFound           = 10
 )
 
 // ErrorMessage holds all information of a certain error
@@ -28,6 +28,8 @@ SOFTWARE.
 */
 package main
 
+import "time"
+
 //CollectionListMessageType defines the API message for lists of collections
 type CollectionListMessageType struct {
 	Collections []string `json:"collections"`
@@ -38,6 +40,59 @@ type DataListMessageType struct {
 	Data []*Data `json:"data"`
 }
 
+//FilterMessageType defines the API message for a single filter of a query.
+//Field is the path of a payload field, nested fields are separated by dots.
+//Op is one of eq, ne, gt, gte, lt, lte, in or exists. Value has to be a list
+//for in and a bool for exists, which defaults to true.
+type FilterMessageType struct {
+	Field string      `json:"field"`
+	Op    FilterOp    `json:"op"`
+	Value interface{} `json:"value,omitempty"`
+}
+
+//QueryMessageType defines the API message for queries. All filters have to
+//match. Fields are the payload fields returned, all fields are returned if
+//it is empty. Sort is either created-at or the path of a payload field,
+//Order is either asc or desc.
+type QueryMessageType struct {
+	Filter []FilterMessageType `json:"filter,omitempty"`
+	Fields []string            `json:"fields,omitempty"`
+	Sort   string              `json:"sort,omitempty"`
+	Order  string              `json:"order,omitempty"`
+	Limit  int                 `json:"limit,omitempty"`
+	Offset int                 `json:"offset,omitempty"`
+}
+
+//BucketMessageType defines the API message for the statistics of a single
+//time bucket. Group is the value of the group-by field, if data items are
+//grouped. Sum, Avg, Min, Max and Percentiles are only set, if a field is
+//aggregated.
+type BucketMessageType struct {
+	Start       time.Time          `json:"start"`
+	End         time.Time          `json:"end"`
+	Group       interface{}        `json:"group,omitempty"`
+	Count       int                `json:"count"`
+	Sum         *float64           `json:"sum,omitempty"`
+	Avg         *float64           `json:"avg,omitempty"`
+	Min         *float64           `json:"min,omitempty"`
+	Max         *float64           `json:"max,omitempty"`
+	Percentiles map[string]float64 `json:"percentiles,omitempty"`
+}
+
+//AggregationResultMessageType defines the API message for the result of
+//aggregations
+type AggregationResultMessageType struct {
+	Buckets []BucketMessageType `json:"buckets"`
+}
+
+//RetentionStatusMessageType defines the API message for the retention policy
+//of a collection. Size is the total size of its data files in bytes.
+type RetentionStatusMessageType struct {
+	Retention
+	Size      int64 `json:"size"`
+	DataFiles int   `json:"data-files"`
+}
+
 //ErrorMessageType defines the API message for errors
 type ErrorMessageType struct {
 	Error interface{} `json:"error"`
@@ -0,0 +1,129 @@
+/*
+config.go
+Defines the configuration of this service, which is read from env vars.
+
+###################################################################################
+
+MIT License
+
+Copyright (c) 2020 Bruno Hautzenberger
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//SyncMode defines when written data items are synced to disk.
+type SyncMode string
+
+const (
+	//SyncModeAlways syncs every written data item before it is returned.
+	SyncModeAlways SyncMode = "always"
+
+	//SyncModeInterval syncs written data items periodically.
+	SyncModeInterval = "interval"
+
+	//SyncModeNone leaves syncing to the operating system.
+	SyncModeNone = "none"
+)
+
+//StorageConfig holds the configuration of the storage.
+//DataRootDirectory is the directory containing all collections. SyncMode
+//defines when written data items are synced to disk and SyncInterval how
+//often, if SyncMode is interval. ArchiveDirectory is the directory data files
+//are moved to by retention policies archiving them. JanitorInterval is how
+//often retention policies are applied, 0 disables the janitor.
+type StorageConfig struct {
+	DataRootDirectory string
+	SyncMode          SyncMode
+	SyncInterval      time.Duration
+	ArchiveDirectory  string
+	JanitorInterval   time.Duration
+}
+
+//StorageConfigFromEnv reads the storage configuration from the env vars
+//DATA_DIRECTORY, SYNC_MODE (always, interval or none, defaults to always),
+//SYNC_INTERVAL (seconds, defaults to 1), ARCHIVE_DIRECTORY and
+//JANITOR_INTERVAL (seconds, defaults to 3600).
+func StorageConfigFromEnv() (StorageConfig, error) {
+	config := StorageConfig{
+		DataRootDirectory: os.Getenv("DATA_DIRECTORY"),
+		SyncMode:          SyncModeAlways,
+		SyncInterval:      time.Second,
+		ArchiveDirectory:  os.Getenv("ARCHIVE_DIRECTORY"),
+		JanitorInterval:   time.Hour,
+	}
+
+	if value := os.Getenv("SYNC_MODE"); len(value) > 0 {
+		mode := SyncMode(strings.ToLower(value))
+		if mode != SyncModeAlways && mode != SyncModeInterval && mode != SyncModeNone {
+			return config, fmt.Errorf("Invalid SYNC_MODE: %v", value)
+		}
+		config.SyncMode = mode
+	}
+
+	if value := os.Getenv("SYNC_INTERVAL"); len(value) > 0 {
+		seconds, err := strconv.Atoi(value)
+		if err != nil || seconds <= 0 {
+			return config, fmt.Errorf("Invalid SYNC_INTERVAL: %v", value)
+		}
+		config.SyncInterval = time.Duration(seconds) * time.Second
+	}
+
+	if value := os.Getenv("JANITOR_INTERVAL"); len(value) > 0 {
+		seconds, err := strconv.Atoi(value)
+		if err != nil || seconds < 0 {
+			return config, fmt.Errorf("Invalid JANITOR_INTERVAL: %v", value)
+		}
+		config.JanitorInterval = time.Duration(seconds) * time.Second
+	}
+
+	return config, nil
+}
+
+//APIConfig holds the configuration of the api. MaxQueryRange is the longest
+//time range a query may cover, because every day of it is read from disk.
+type APIConfig struct {
+	MaxQueryRange time.Duration
+}
+
+//APIConfigFromEnv reads the api configuration from the env var MAX_QUERY_DAYS
+//(days, defaults to 366).
+func APIConfigFromEnv() (APIConfig, error) {
+	config := APIConfig{
+		MaxQueryRange: 366 * 24 * time.Hour,
+	}
+
+	if value := os.Getenv("MAX_QUERY_DAYS"); len(value) > 0 {
+		days, err := strconv.Atoi(value)
+		if err != nil || days <= 0 {
+			return config, fmt.Errorf("Invalid MAX_QUERY_DAYS: %v", value)
+		}
+		config.MaxQueryRange = time.Duration(days) * 24 * time.Hour
+	}
+
+	return config, nil
+}
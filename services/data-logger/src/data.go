@@ -44,12 +44,12 @@ type DataFileContent struct {
 	Items []*Data `json:"items"`
 }
 
-// GetItemsInRange returns all items of a data file between startDate and endDate.
-func (df DataFileContent) GetItemsInRange(startDate time.Time, endDate time.Time) []*Data {
+// GetItemsInRange returns all items of a data file within the given time range.
+func (df DataFileContent) GetItemsInRange(timeRange TimeRange) []*Data {
 	items := make([]*Data, 0)
 
 	for _, item := range df.Items {
-		if item.CreatedAt.After(startDate) || item.CreatedAt.Equal(startDate) || item.CreatedAt.Before(endDate) || item.CreatedAt.Equal(endDate) {
+		if timeRange.Contains(item.CreatedAt) {
 			items = append(items, item)
 		}
 	}
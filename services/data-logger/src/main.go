@@ -9,7 +9,7 @@ It is important to know that data can only queried by collection and timeframe.
 Also data can't be deleted, so consider this as a long term storage for immutable
 data.
 You can use this if you want a very lightweight json data storage for your services.
-It shows how you can split data into seperate data files, read query params using
+It shows how you can split data into seperate append-only data files, read query params using
 mux and also how to lock files during writes using sync.Mutex.
 
 ###################################################################################
@@ -46,6 +46,7 @@ SOFTWARE.
 package main
 
 import (
+	"flag"
 	"fmt"
 	"log"
 	"net/http"
@@ -57,18 +58,55 @@ import (
 var storage StorageInterface = &Storage{}
 var api APIInterface = &API{}
 
-//init initializes storage and api
-func init() {
-	storage.Initialize(os.Getenv("DATA_DIRECTORY"))
-	api.Initialize(storage)
+//setup initializes storage and api. It is called by main after the flags are
+//parsed instead of init, so tests of this package don't depend on the env
+//vars of the service.
+func setup() {
+	config, err := StorageConfigFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	apiConfig, err := APIConfigFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	storage.Initialize(config)
+	api.Initialize(storage, apiConfig)
 }
 
 //main is the main entrypoint of the service. It routes all API methods
-//and starts the server on PORT specified in env vars.
+//and starts the server on PORT specified in env vars. If it is started with
+//-migrate, it converts all legacy data files to segments and exits instead.
 func main() {
+	migrate := flag.Bool("migrate", false, "convert legacy data files to segments and exit")
+	flag.Parse()
+
+	setup()
+
+	// the janitor and the syncer must not touch data files while they are
+	// migrated, so they are only started in server mode
+	if *migrate {
+		migrated, err := storage.MigrateDataFiles()
+		storage.Close()
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		log.Printf("Migrated %v data files\n", migrated)
+		return
+	}
+
+	storage.Start()
+
 	r := mux.NewRouter()
 	r.HandleFunc("/info/collections", api.Collections).Methods("GET")
 	r.HandleFunc("/{collection}", api.Query).Methods("GET")
+	r.HandleFunc("/{collection}/query", api.QueryBody).Methods("POST")
+	r.HandleFunc("/{collection}/aggregate", api.Aggregate).Methods("GET")
+	r.HandleFunc("/{collection}/retention", api.GetRetention).Methods("GET")
+	r.HandleFunc("/{collection}/retention", api.SetRetention).Methods("PUT")
 	r.HandleFunc("/{collection}", api.Write).Methods("POST")
 
 	// Bind to a port and pass our router in
@@ -0,0 +1,160 @@
+/*
+migrate.go
+Implements the migration of legacy data files, which contain all data items
+of a day as a single json object, to segments.
+
+###################################################################################
+
+MIT License
+
+Copyright (c) 2020 Bruno Hautzenberger
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+package main
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// MigrateDataFiles converts all legacy data files of all collections to
+// segments and returns the number of converted data files. Data items, which
+// were already appended to the segment of the same day, are kept after the
+// items of the legacy data file. Legacy data files are only deleted, after
+// their segment was written completely, so migrating a data file again after
+// an interruption is safe.
+func (s *Storage) MigrateDataFiles() (int, error) {
+	s.MutexLock.Lock()
+	defer s.MutexLock.Unlock()
+
+	migrated := 0
+
+	collections, err := s.ListCollections()
+	if err != nil {
+		return migrated, err
+	}
+
+	for _, collectionName := range collections {
+		collectionPath := filepath.Join(s.DataRootDirectory, collectionName)
+		dirContent, err := ioutil.ReadDir(collectionPath)
+		if err != nil {
+			return migrated, err
+		}
+
+		for _, item := range dirContent {
+			_, extension, ok := parseDataFileName(item.Name())
+			if item.IsDir() || !ok || extension != legacyFileExtension {
+				continue
+			}
+
+			// the segment is rewritten, so it must not be open
+			s.closeSegment(collectionName)
+
+			dataFilePath := filepath.Join(collectionPath, item.Name())
+			if err := s.migrateDataFile(dataFilePath); err != nil {
+				return migrated, err
+			}
+
+			log.Printf("Migrated data file %v\n", dataFilePath)
+			migrated++
+		}
+	}
+
+	return migrated, nil
+}
+
+// migrateDataFile converts a single legacy data file to a segment
+func (s *Storage) migrateDataFile(dataFilePath string) error {
+	segmentPath := strings.TrimSuffix(dataFilePath, legacyFileExtension) + segmentFileExtension
+	tmpPath := segmentPath + ".tmp"
+
+	dataFile, err := s.readDataFile(dataFilePath)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpPath)
+
+	err = s.writeMigratedSegment(file, dataFile, segmentPath)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmpPath, segmentPath); err != nil {
+		return err
+	}
+
+	// the rename must be durable, before the legacy data file is removed
+	if err := syncDirectory(filepath.Dir(segmentPath)); err != nil {
+		return err
+	}
+
+	return os.Remove(dataFilePath)
+}
+
+// syncDirectory syncs a directory, so renamed and created files in it
+// survive a crash
+func syncDirectory(directoryPath string) error {
+	dir, err := os.Open(directoryPath)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+
+	return dir.Sync()
+}
+
+// writeMigratedSegment writes the data items of a legacy data file followed
+// by the data items of the existing segment and syncs the file. Segment items,
+// which are already part of the legacy data file, are skipped. They are left
+// by a migration, which was interrupted before the legacy data file was deleted.
+func (s *Storage) writeMigratedSegment(file *os.File, dataFile *DataFileContent, segmentPath string) error {
+	migrated := map[string]bool{}
+	for _, data := range dataFile.Items {
+		if err := appendSegment(file, data); err != nil {
+			return err
+		}
+		migrated[data.UUID] = true
+	}
+
+	if s.fileExists(segmentPath) {
+		err := readSegment(segmentPath, func(data *Data) error {
+			if migrated[data.UUID] {
+				return nil
+			}
+			return appendSegment(file, data)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return file.Sync()
+}
@@ -0,0 +1,497 @@
+/*
+query.go
+Implements queries, which filter data items by their payload fields, project
+their payloads to certain fields, sort and page them. Queries are evaluated
+while data items are read, so only matching data items are kept.
+
+###################################################################################
+
+MIT License
+
+Copyright (c) 2020 Bruno Hautzenberger
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+package main
+
+import (
+	"container/heap"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+//FilterOp defines the comparison of a filter
+type FilterOp string
+
+const (
+	FilterOpEqual          FilterOp = "eq"
+	FilterOpNotEqual                = "ne"
+	FilterOpGreater                 = "gt"
+	FilterOpGreaterOrEqual          = "gte"
+	FilterOpLess                    = "lt"
+	FilterOpLessOrEqual             = "lte"
+	FilterOpIn                      = "in"
+	FilterOpExists                  = "exists"
+)
+
+//SortCreatedAt sorts data items by the time they were created
+const SortCreatedAt = "created-at"
+
+//ErrInvalidQuery is returned for invalid queries
+var ErrInvalidQuery = errors.New("Invalid query")
+
+//Filter matches data items, which have a payload field matching Value.
+type Filter struct {
+	Path  []string
+	Op    FilterOp
+	Value interface{}
+}
+
+//Query defines which data items of a time range are returned and how.
+//Limit 0 means there is no limit.
+type Query struct {
+	Filters    []Filter
+	Fields     [][]string
+	Sort       []string
+	Descending bool
+	Limit      int
+	Offset     int
+}
+
+//splitPath splits the path of a payload field into its parts
+func splitPath(field string) ([]string, error) {
+	parts := strings.Split(field, ".")
+	for _, part := range parts {
+		if len(part) == 0 {
+			return nil, fmt.Errorf("%v: invalid field %q", ErrInvalidQuery, field)
+		}
+	}
+
+	return parts, nil
+}
+
+//QueryFromMessageType creates a Query from a QueryMessageType and validates
+//it.
+func QueryFromMessageType(msg QueryMessageType) (Query, error) {
+	query := Query{
+		Limit:  msg.Limit,
+		Offset: msg.Offset,
+	}
+
+	for _, f := range msg.Filter {
+		path, err := splitPath(f.Field)
+		if err != nil {
+			return query, err
+		}
+
+		filter := Filter{Path: path, Op: f.Op, Value: f.Value}
+		switch f.Op {
+		case FilterOpEqual, FilterOpNotEqual:
+		case FilterOpGreater, FilterOpGreaterOrEqual, FilterOpLess, FilterOpLessOrEqual:
+			if !isComparable(f.Value) {
+				return query, fmt.Errorf("%v: %v needs a number or a string", ErrInvalidQuery, f.Op)
+			}
+		case FilterOpIn:
+			if _, ok := f.Value.([]interface{}); !ok {
+				return query, fmt.Errorf("%v: in needs a list", ErrInvalidQuery)
+			}
+		case FilterOpExists:
+			if f.Value == nil {
+				filter.Value = true
+			} else if _, ok := f.Value.(bool); !ok {
+				return query, fmt.Errorf("%v: exists needs a bool", ErrInvalidQuery)
+			}
+		default:
+			return query, fmt.Errorf("%v: unknown op %q", ErrInvalidQuery, f.Op)
+		}
+
+		query.Filters = append(query.Filters, filter)
+	}
+
+	for _, field := range msg.Fields {
+		path, err := splitPath(field)
+		if err != nil {
+			return query, err
+		}
+		query.Fields = append(query.Fields, path)
+	}
+
+	if len(msg.Sort) > 0 && msg.Sort != SortCreatedAt {
+		path, err := splitPath(msg.Sort)
+		if err != nil {
+			return query, err
+		}
+		query.Sort = path
+	}
+
+	switch msg.Order {
+	case "", "asc":
+	case "desc":
+		query.Descending = true
+	default:
+		return query, fmt.Errorf("%v: order must be asc or desc", ErrInvalidQuery)
+	}
+
+	if msg.Limit < 0 || msg.Offset < 0 {
+		return query, fmt.Errorf("%v: limit and offset must not be negative", ErrInvalidQuery)
+	}
+
+	return query, nil
+}
+
+//Matches checks if all filters match the payload of a data item.
+func (q Query) Matches(data *Data) bool {
+	for _, filter := range q.Filters {
+		if !filter.Matches(data.Payload) {
+			return false
+		}
+	}
+
+	return true
+}
+
+//Matches checks if the payload field of the filter matches its value.
+func (f Filter) Matches(payload map[string]interface{}) bool {
+	value, ok := lookupPath(payload, f.Path)
+
+	switch f.Op {
+	case FilterOpExists:
+		return ok == f.Value.(bool)
+	case FilterOpNotEqual:
+		return !ok || !reflect.DeepEqual(value, f.Value)
+	}
+
+	if !ok {
+		return false
+	}
+
+	switch f.Op {
+	case FilterOpEqual:
+		return reflect.DeepEqual(value, f.Value)
+	case FilterOpIn:
+		for _, candidate := range f.Value.([]interface{}) {
+			if reflect.DeepEqual(value, candidate) {
+				return true
+			}
+		}
+		return false
+	}
+
+	result, ok := compareValues(value, f.Value)
+	if !ok {
+		return false
+	}
+
+	switch f.Op {
+	case FilterOpGreater:
+		return result > 0
+	case FilterOpGreaterOrEqual:
+		return result >= 0
+	case FilterOpLess:
+		return result < 0
+	case FilterOpLessOrEqual:
+		return result <= 0
+	}
+
+	return false
+}
+
+//lookupPath returns the value of a nested field of a payload. Elements of
+//lists are selected by their index.
+func lookupPath(payload map[string]interface{}, path []string) (interface{}, bool) {
+	var current interface{} = payload
+	for _, part := range path {
+		switch node := current.(type) {
+		case map[string]interface{}:
+			value, ok := node[part]
+			if !ok {
+				return nil, false
+			}
+			current = value
+		case []interface{}:
+			index, err := strconv.Atoi(part)
+			if err != nil || index < 0 || index >= len(node) {
+				return nil, false
+			}
+			current = node[index]
+		default:
+			return nil, false
+		}
+	}
+
+	return current, true
+}
+
+//isComparable checks if a value can be compared using gt, gte, lt and lte
+func isComparable(value interface{}) bool {
+	switch value.(type) {
+	case float64, string:
+		return true
+	}
+
+	return false
+}
+
+//compareValues compares two numbers or two strings. It returns false, if
+//the values can't be compared.
+func compareValues(a interface{}, b interface{}) (int, bool) {
+	switch x := a.(type) {
+	case float64:
+		if y, ok := b.(float64); ok {
+			switch {
+			case x < y:
+				return -1, true
+			case x > y:
+				return 1, true
+			}
+			return 0, true
+		}
+	case string:
+		if y, ok := b.(string); ok {
+			return strings.Compare(x, y), true
+		}
+	}
+
+	return 0, false
+}
+
+//Project returns a copy of a data item, whose payload only contains the
+//fields of the query.
+func (q Query) Project(data *Data) *Data {
+	if len(q.Fields) == 0 {
+		return data
+	}
+
+	payload := make(map[string]interface{})
+	for _, path := range q.Fields {
+		value, ok := lookupPath(data.Payload, path)
+		if !ok {
+			continue
+		}
+
+		node := payload
+		for _, part := range path[:len(path)-1] {
+			child, ok := node[part].(map[string]interface{})
+			if !ok {
+				child = make(map[string]interface{})
+				node[part] = child
+			}
+			node = child
+		}
+		node[path[len(path)-1]] = value
+	}
+
+	return &Data{
+		UUID:      data.UUID,
+		CreatedAt: data.CreatedAt,
+		Payload:   payload,
+	}
+}
+
+//RunQuery reads the data items of a collection in a time range and returns
+//the page of matching data items defined by the query. Data items are
+//already read in the order they were created, so reading stops as soon as
+//the page is complete, if they are sorted ascending by created-at. Sorting
+//descending by created-at only keeps the last matching data items of the
+//page. Sorting by a payload field with a limit only keeps the first matching
+//data items of the page in a heap.
+func RunQuery(storage StorageInterface, collectionName string, timeRange TimeRange, query Query) ([]*Data, error) {
+	result := make([]*Data, 0)
+	pageEnd := query.Offset + query.Limit
+	byCreatedAt := len(query.Sort) == 0
+
+	var kept *dataHeap
+	if !byCreatedAt && query.Limit > 0 {
+		kept = &dataHeap{Order: fieldLess(query.Sort, query.Descending)}
+	}
+
+	err := storage.EachData(collectionName, timeRange, func(data *Data) error {
+		if !query.Matches(data) {
+			return nil
+		}
+
+		if kept != nil {
+			kept.keep(data, pageEnd)
+			return nil
+		}
+
+		result = append(result, data)
+		if !byCreatedAt || query.Limit == 0 {
+			return nil
+		}
+
+		if !query.Descending && len(result) >= pageEnd {
+			return ErrStopReading
+		}
+
+		if query.Descending && len(result) > pageEnd {
+			result = result[1:]
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if byCreatedAt {
+		if query.Descending {
+			for i, j := 0, len(result)-1; i < j; i, j = i+1, j-1 {
+				result[i], result[j] = result[j], result[i]
+			}
+		}
+	} else if kept != nil {
+		result = kept.sorted()
+	} else {
+		sortByField(result, query.Sort, query.Descending)
+	}
+
+	return query.page(result), nil
+}
+
+//sortByField sorts data items by a payload field. Numbers come before
+//strings, data items missing the field or having other values come last.
+func sortByField(items []*Data, path []string, descending bool) {
+	less := fieldLess(path, descending)
+	sort.SliceStable(items, func(i, j int) bool {
+		return less(items[i], items[j])
+	})
+}
+
+//fieldLess returns the order of data items by a payload field used by
+//sortByField.
+func fieldLess(path []string, descending bool) func(a *Data, b *Data) bool {
+	rank := func(value interface{}, ok bool) int {
+		if ok {
+			switch value.(type) {
+			case float64:
+				return 0
+			case string:
+				return 1
+			}
+		}
+		return 2
+	}
+
+	return func(x *Data, y *Data) bool {
+		a, okA := lookupPath(x.Payload, path)
+		b, okB := lookupPath(y.Payload, path)
+
+		rankA, rankB := rank(a, okA), rank(b, okB)
+		if rankA != rankB || rankA == 2 {
+			return rankA < rankB
+		}
+
+		result, _ := compareValues(a, b)
+		if descending {
+			return result > 0
+		}
+		return result < 0
+	}
+}
+
+//heapEntry is a data item kept by a dataHeap with the position it was read
+//at, so data items of the same order keep the order they were read in.
+type heapEntry struct {
+	Data     *Data
+	Position int
+}
+
+//dataHeap keeps the first data items in the order given by Order. Its root is
+//the last of them, so it is replaced in O(log n), whenever a data item comes
+//before it.
+type dataHeap struct {
+	Order   func(a *Data, b *Data) bool
+	Entries []heapEntry
+	read    int
+}
+
+//before checks if entry a comes before entry b.
+func (h *dataHeap) before(a heapEntry, b heapEntry) bool {
+	if h.Order(a.Data, b.Data) {
+		return true
+	}
+	if h.Order(b.Data, a.Data) {
+		return false
+	}
+	return a.Position < b.Position
+}
+
+func (h *dataHeap) Len() int           { return len(h.Entries) }
+func (h *dataHeap) Less(i, j int) bool { return h.before(h.Entries[j], h.Entries[i]) }
+func (h *dataHeap) Swap(i, j int)      { h.Entries[i], h.Entries[j] = h.Entries[j], h.Entries[i] }
+
+func (h *dataHeap) Push(x interface{}) {
+	h.Entries = append(h.Entries, x.(heapEntry))
+}
+
+func (h *dataHeap) Pop() interface{} {
+	last := h.Entries[len(h.Entries)-1]
+	h.Entries = h.Entries[:len(h.Entries)-1]
+	return last
+}
+
+//keep adds a data item and removes the last data item, if more than n data
+//items are kept.
+func (h *dataHeap) keep(data *Data, n int) {
+	heap.Push(h, heapEntry{Data: data, Position: h.read})
+	h.read++
+
+	if h.Len() > n {
+		heap.Pop(h)
+	}
+}
+
+//sorted returns the kept data items in their order.
+func (h *dataHeap) sorted() []*Data {
+	sort.Slice(h.Entries, func(i, j int) bool {
+		return h.before(h.Entries[i], h.Entries[j])
+	})
+
+	items := make([]*Data, len(h.Entries))
+	for i, entry := range h.Entries {
+		items[i] = entry.Data
+	}
+
+	return items
+}
+
+//page returns the projected data items of the page defined by offset and
+//limit.
+func (q Query) page(items []*Data) []*Data {
+	if q.Offset >= len(items) {
+		return make([]*Data, 0)
+	}
+
+	items = items[q.Offset:]
+	if q.Limit > 0 && q.Limit < len(items) {
+		items = items[:q.Limit]
+	}
+
+	page := make([]*Data, len(items))
+	for i, item := range items {
+		page[i] = q.Project(item)
+	}
+
+	return page
+}
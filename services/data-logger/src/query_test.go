@@ -0,0 +1,146 @@
+/*
+query_test.go
+Tests of query parameters and of sorting and paging queried data items.
+
+###################################################################################
+
+MIT License
+
+Copyright (c) 2020 Bruno Hautzenberger
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+package main
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+//newNumbersStorage creates a fixture storage with the collection "numbers",
+//whose data items are created every minute of 2020-09-01 from midnight. Their
+//payload field "n" cycles through 5, 3, 9, 1, 7 and every fourth item has no
+//field "n", so sorting has ties and items missing the field.
+func newNumbersStorage(t *testing.T) (*Storage, TimeRange) {
+	t.Helper()
+
+	s := newFixtureStorage(t)
+	start := mustParse(t, "2020-09-01T00:00:00Z")
+	numbers := []float64{5, 3, 9, 1, 7}
+
+	items := make([]*Data, 0)
+	for i := 0; i < 40; i++ {
+		payload := map[string]interface{}{"n": numbers[i%len(numbers)]}
+		if i%4 == 3 {
+			payload = map[string]interface{}{}
+		}
+		items = append(items, &Data{UUID: fmt.Sprintf("item-%02d", i), CreatedAt: start.Add(time.Duration(i) * time.Minute), Payload: payload})
+	}
+
+	collectionPath, err := s.getCollectionPath("numbers")
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeSegmentFixture(t, filepath.Join(collectionPath, "2020-09-01."+segmentFileExtension), items)
+
+	return s, TimeRange{Start: start, End: start.Add(time.Hour)}
+}
+
+func TestRunQuerySortedPagesMatchFullSort(t *testing.T) {
+	s, timeRange := newNumbersStorage(t)
+
+	for _, descending := range []bool{false, true} {
+		all, err := RunQuery(s, "numbers", timeRange, Query{Sort: []string{"n"}, Descending: descending})
+		if err != nil {
+			t.Fatalf("RunQuery failed: %v", err)
+		}
+		if len(all) != 40 {
+			t.Fatalf("RunQuery returned %v items, expected 40", len(all))
+		}
+
+		for _, page := range [][2]int{{0, 1}, {0, 10}, {5, 10}, {30, 10}, {35, 10}, {40, 5}, {0, 100}} {
+			query := Query{Sort: []string{"n"}, Descending: descending, Offset: page[0], Limit: page[1]}
+			items, err := RunQuery(s, "numbers", timeRange, query)
+			if err != nil {
+				t.Fatalf("RunQuery failed: %v", err)
+			}
+
+			end := page[0] + page[1]
+			if end > len(all) {
+				end = len(all)
+			}
+			expected := []string{}
+			if page[0] < len(all) {
+				expected = uuids(all[page[0]:end])
+			}
+
+			if got := uuids(items); !reflect.DeepEqual(got, expected) {
+				t.Errorf("descending %v, offset %v, limit %v: got %v, expected %v", descending, page[0], page[1], got, expected)
+			}
+		}
+	}
+}
+
+func TestRunQuerySortKeepsReadOrderOfTies(t *testing.T) {
+	s, timeRange := newNumbersStorage(t)
+
+	items, err := RunQuery(s, "numbers", timeRange, Query{Sort: []string{"n"}, Limit: 4})
+	if err != nil {
+		t.Fatalf("RunQuery failed: %v", err)
+	}
+
+	// the items with n=1 in the order they were created
+	expected := []string{"item-08", "item-13", "item-18", "item-28"}
+	if got := uuids(items); !reflect.DeepEqual(got, expected) {
+		t.Errorf("got %v, expected %v", got, expected)
+	}
+}
+
+func TestGetQueryInValues(t *testing.T) {
+	tests := []struct {
+		where    string
+		expected []interface{}
+	}{
+		{"level:in:1,2", []interface{}{1.0, 2.0}},
+		{"level:in:[1,2]", []interface{}{1.0, 2.0}},
+		{"level:in:warn,error", []interface{}{"warn", "error"}},
+		{`level:in:true,"1",null,x`, []interface{}{true, "1", nil, "x"}},
+		{"level:in:3", []interface{}{3.0}},
+	}
+
+	a := &API{}
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", "/testCollection", nil)
+		r.Form = map[string][]string{"where": {test.where}}
+
+		msg, err := a.GetQuery(r)
+		if err != nil {
+			t.Errorf("%v: GetQuery failed: %v", test.where, err)
+			continue
+		}
+
+		if len(msg.Filter) != 1 || !reflect.DeepEqual(msg.Filter[0].Value, test.expected) {
+			t.Errorf("%v: got %#v, expected %#v", test.where, msg.Filter, test.expected)
+		}
+	}
+}
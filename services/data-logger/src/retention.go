@@ -0,0 +1,383 @@
+/*
+retention.go
+Implements retention policies, which limit the age and the total size of the
+data files of a collection. A janitor applies them periodically and deletes
+or archives the oldest data files.
+
+###################################################################################
+
+MIT License
+
+Copyright (c) 2020 Bruno Hautzenberger
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+package main
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+//RetentionAction defines what happens to data files, which exceed the
+//retention policy of their collection.
+type RetentionAction string
+
+const (
+	//RetentionActionDelete deletes data files
+	RetentionActionDelete RetentionAction = "delete"
+
+	//RetentionActionArchive moves data files gzipped to the archive directory
+	RetentionActionArchive = "archive"
+)
+
+//retentionFileName is the name of the file in a collection directory, which
+//holds the retention policy of the collection
+const retentionFileName = "retention.json"
+
+//ErrInvalidRetention is returned for invalid retention policies
+var ErrInvalidRetention = errors.New("Invalid retention policy")
+
+//ErrArchiveDisabled is returned for retention policies archiving data files,
+//if there is no archive directory
+var ErrArchiveDisabled = errors.New("Archiving data files needs ARCHIVE_DIRECTORY to be set")
+
+//Retention holds the retention policy of a collection. MaxAge is given in
+//seconds and MaxSize in bytes, 0 means there is no limit. Data files of the
+//current day are always kept.
+type Retention struct {
+	MaxAge  int64           `json:"max-age"`
+	MaxSize int64           `json:"max-size"`
+	Action  RetentionAction `json:"action"`
+}
+
+//dataFile holds the day and size of a single data file of a collection
+type dataFile struct {
+	Day  time.Time
+	Path string
+	Size int64
+}
+
+//Validate checks if the retention policy is valid. Archiving data files
+//needs an archive directory.
+func (r Retention) Validate(archiveDirectory string) error {
+	if r.MaxAge < 0 || r.MaxSize < 0 {
+		return ErrInvalidRetention
+	}
+
+	switch r.Action {
+	case RetentionActionDelete:
+	case RetentionActionArchive:
+		if len(archiveDirectory) == 0 {
+			return ErrArchiveDisabled
+		}
+	default:
+		return ErrInvalidRetention
+	}
+
+	return nil
+}
+
+//GetRetention returns the retention policy of a collection. Collections
+//without a retention policy keep all data files. It returns
+//ErrCollectionNotFound, if the collection does not exist.
+func (s *Storage) GetRetention(collectionName string) (Retention, error) {
+	retention := Retention{Action: RetentionActionDelete}
+
+	collectionPath, err := s.lookupCollectionPath(collectionName)
+	if err != nil {
+		return retention, err
+	}
+
+	content, err := ioutil.ReadFile(filepath.Join(collectionPath, retentionFileName))
+	if os.IsNotExist(err) {
+		return retention, nil
+	}
+	if err != nil {
+		return retention, err
+	}
+
+	err = json.Unmarshal(content, &retention)
+	return retention, err
+}
+
+//SetRetention validates and stores the retention policy of a collection. It
+//is applied by the next run of the janitor.
+func (s *Storage) SetRetention(collectionName string, retention Retention) error {
+	if len(retention.Action) == 0 {
+		retention.Action = RetentionActionDelete
+	}
+
+	if err := retention.Validate(s.Config.ArchiveDirectory); err != nil {
+		return err
+	}
+
+	collectionPath, err := s.getCollectionPath(collectionName)
+	if err != nil {
+		return err
+	}
+
+	content, err := json.Marshal(retention)
+	if err != nil {
+		return err
+	}
+
+	s.MutexLock.Lock()
+	defer s.MutexLock.Unlock()
+
+	retentionPath := filepath.Join(collectionPath, retentionFileName)
+	if err := ioutil.WriteFile(retentionPath+".tmp", content, 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(retentionPath+".tmp", retentionPath)
+}
+
+//CollectionSize returns the total size in bytes and the number of data
+//files of a collection. It returns ErrCollectionNotFound, if the collection
+//does not exist.
+func (s *Storage) CollectionSize(collectionName string) (int64, int, error) {
+	collectionPath, err := s.lookupCollectionPath(collectionName)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	files, err := listDataFiles(collectionPath)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	size := int64(0)
+	for _, file := range files {
+		size += file.Size
+	}
+
+	return size, len(files), nil
+}
+
+//listDataFiles returns all data files of a collection, the oldest first.
+func listDataFiles(collectionPath string) ([]dataFile, error) {
+	files := make([]dataFile, 0)
+
+	dirContent, err := ioutil.ReadDir(collectionPath)
+	if err != nil {
+		return files, err
+	}
+
+	for _, item := range dirContent {
+		day, _, ok := parseDataFileName(item.Name())
+		if item.IsDir() || !ok {
+			continue
+		}
+
+		files = append(files, dataFile{
+			Day:  day,
+			Path: filepath.Join(collectionPath, item.Name()),
+			Size: item.Size(),
+		})
+	}
+
+	// legacy data files come before the segment of the same day
+	sort.SliceStable(files, func(i, j int) bool {
+		if !files[i].Day.Equal(files[j].Day) {
+			return files[i].Day.Before(files[j].Day)
+		}
+		return filepath.Ext(files[i].Path) == "."+legacyFileExtension
+	})
+
+	return files, nil
+}
+
+//runJanitor applies the retention policies of all collections periodically,
+//until the storage is closed.
+func (s *Storage) runJanitor() {
+	ticker := time.NewTicker(s.Config.JanitorInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			if err := s.ApplyRetention(); err != nil {
+				log.Printf("Failed to apply retention policies: %v\n", err)
+			}
+		}
+	}
+}
+
+//ApplyRetention deletes or archives the oldest data files of all collections,
+//which are older than the maximum age of their collection, until the
+//collection does not exceed its maximum size anymore. Errors of single
+//collections are logged and don't stop the others.
+func (s *Storage) ApplyRetention() error {
+	collections, err := s.ListCollections()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	for _, collectionName := range collections {
+		if err := s.applyRetention(collectionName, now); err != nil {
+			log.Printf("Failed to apply retention policy of %v: %v\n", collectionName, err)
+		}
+	}
+
+	return nil
+}
+
+//applyRetention applies the retention policy of a single collection. The data
+//files are selected while the storage is locked, but deleted or archived
+//afterwards, so writes are not blocked while data files are gzipped. Only
+//data files of previous days are selected, which are never written again.
+func (s *Storage) applyRetention(collectionName string, now time.Time) error {
+	retention, err := s.GetRetention(collectionName)
+	if err != nil {
+		return err
+	}
+
+	if retention.MaxAge == 0 && retention.MaxSize == 0 {
+		return nil
+	}
+
+	// the archive directory might have been removed since the policy was set
+	if err := retention.Validate(s.Config.ArchiveDirectory); err != nil {
+		return err
+	}
+
+	expired, err := s.selectExpiredDataFiles(collectionName, retention, now)
+	if err != nil {
+		return err
+	}
+
+	for _, file := range expired {
+		if retention.Action == RetentionActionArchive {
+			err = s.archiveDataFile(collectionName, file.Path)
+		} else {
+			err = os.Remove(file.Path)
+		}
+		if err != nil {
+			return err
+		}
+
+		log.Printf("Removed data file %v by retention policy (%v)\n", file.Path, retention.Action)
+	}
+
+	return nil
+}
+
+//selectExpiredDataFiles returns the oldest data files of a collection, which
+//exceed its retention policy, and closes their segments, if they are open.
+func (s *Storage) selectExpiredDataFiles(collectionName string, retention Retention, now time.Time) ([]dataFile, error) {
+	s.MutexLock.Lock()
+	defer s.MutexLock.Unlock()
+
+	files, err := listDataFiles(filepath.Join(s.DataRootDirectory, collectionName))
+	if err != nil {
+		return nil, err
+	}
+
+	size := int64(0)
+	for _, file := range files {
+		size += file.Size
+	}
+
+	selected := make([]dataFile, 0)
+	today := utcDate(now)
+	maxAge := time.Duration(retention.MaxAge) * time.Second
+	for _, file := range files {
+		if !file.Day.Before(today) {
+			break
+		}
+
+		// a data file expires, when its last data item is older than max-age
+		expired := retention.MaxAge > 0 && now.Sub(file.Day.AddDate(0, 0, 1)) > maxAge
+		tooLarge := retention.MaxSize > 0 && size > retention.MaxSize
+		if !expired && !tooLarge {
+			break
+		}
+
+		if open, ok := s.segments[collectionName]; ok && open.Name() == file.Path {
+			s.closeSegment(collectionName)
+		}
+
+		selected = append(selected, file)
+		size -= file.Size
+	}
+
+	return selected, nil
+}
+
+//archiveDataFile moves a data file gzipped to the archive directory. The data
+//file is only deleted, after the archive was written completely.
+func (s *Storage) archiveDataFile(collectionName string, dataFilePath string) error {
+	archivePath := filepath.Join(s.Config.ArchiveDirectory, collectionName)
+	if err := os.MkdirAll(archivePath, 0755); err != nil {
+		return err
+	}
+	archivePath = filepath.Join(archivePath, filepath.Base(dataFilePath)+".gz")
+
+	source, err := os.Open(dataFilePath)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+
+	archive, err := os.Create(archivePath + ".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(archivePath + ".tmp")
+
+	err = writeArchive(archive, source)
+	if closeErr := archive.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := os.Rename(archivePath+".tmp", archivePath); err != nil {
+		return err
+	}
+
+	return os.Remove(dataFilePath)
+}
+
+//writeArchive gzips the data file to the archive and syncs it.
+func writeArchive(archive *os.File, source io.Reader) error {
+	writer := gzip.NewWriter(archive)
+	if _, err := io.Copy(writer, source); err != nil {
+		return err
+	}
+
+	if err := writer.Close(); err != nil {
+		return err
+	}
+
+	return archive.Sync()
+}
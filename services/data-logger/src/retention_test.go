@@ -0,0 +1,147 @@
+/*
+retention_test.go
+Tests of retention policies of collections.
+
+###################################################################################
+
+MIT License
+
+Copyright (c) 2020 Bruno Hautzenberger
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+package main
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+//collectionFiles returns the names of all files in a directory.
+func collectionFiles(t *testing.T, directoryPath string) []string {
+	t.Helper()
+
+	dirContent, err := ioutil.ReadDir(directoryPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	names := make([]string, 0)
+	for _, item := range dirContent {
+		names = append(names, item.Name())
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+func TestRetentionOfUnknownCollection(t *testing.T) {
+	s := newFixtureStorage(t)
+
+	if _, err := s.GetRetention("unknown"); err != ErrCollectionNotFound {
+		t.Errorf("GetRetention returned %v, expected ErrCollectionNotFound", err)
+	}
+
+	if _, _, err := s.CollectionSize("unknown"); err != ErrCollectionNotFound {
+		t.Errorf("CollectionSize returned %v, expected ErrCollectionNotFound", err)
+	}
+
+	a := &API{}
+	a.Initialize(s, APIConfig{})
+	w := httptest.NewRecorder()
+	a.GetRetention(w, mux.SetURLVars(httptest.NewRequest("GET", "/unknown/retention", nil), map[string]string{"collection": "unknown"}))
+	if w.Code != 404 {
+		t.Errorf("GET retention responded with %v, expected 404", w.Code)
+	}
+
+	if _, err := os.Stat(filepath.Join(s.DataRootDirectory, "unknown")); !os.IsNotExist(err) {
+		t.Errorf("reading the retention policy created the collection")
+	}
+}
+
+func TestApplyRetentionByAge(t *testing.T) {
+	s := newFixtureStorage(t)
+	if err := s.SetRetention("fixtures", Retention{MaxAge: 24 * 60 * 60}); err != nil {
+		t.Fatal(err)
+	}
+
+	// data files expire, when their last data item is older than max-age
+	if err := s.applyRetention("fixtures", mustParse(t, "2020-09-04T12:00:00Z")); err != nil {
+		t.Fatalf("applyRetention failed: %v", err)
+	}
+
+	expected := []string{"2020-09-03.ndjson", "2020-09-05.ndjson", retentionFileName}
+	if got := collectionFiles(t, filepath.Join(s.DataRootDirectory, "fixtures")); !reflect.DeepEqual(got, expected) {
+		t.Errorf("collection contains %v, expected %v", got, expected)
+	}
+}
+
+func TestApplyRetentionArchivesBySize(t *testing.T) {
+	s := newFixtureStorage(t)
+	s.Config.ArchiveDirectory = filepath.Join(s.DataRootDirectory, "archive")
+
+	size, dataFiles, err := s.CollectionSize("fixtures")
+	if err != nil || dataFiles != 4 {
+		t.Fatalf("CollectionSize = %v, %v, %v, expected 4 data files", size, dataFiles, err)
+	}
+
+	if err := s.SetRetention("fixtures", Retention{MaxSize: size - 1, Action: RetentionActionArchive}); err != nil {
+		t.Fatal(err)
+	}
+
+	// data files of the current day are always kept
+	if err := s.applyRetention("fixtures", mustParse(t, "2020-09-01T12:00:00Z")); err != nil {
+		t.Fatalf("applyRetention failed: %v", err)
+	}
+	if _, dataFiles, _ := s.CollectionSize("fixtures"); dataFiles != 4 {
+		t.Errorf("data files of the current day were removed")
+	}
+
+	if err := s.applyRetention("fixtures", mustParse(t, "2020-09-06T12:00:00Z")); err != nil {
+		t.Fatalf("applyRetention failed: %v", err)
+	}
+
+	expected := []string{"2020-09-02.json", "2020-09-03.ndjson", "2020-09-05.ndjson", retentionFileName}
+	if got := collectionFiles(t, filepath.Join(s.DataRootDirectory, "fixtures")); !reflect.DeepEqual(got, expected) {
+		t.Errorf("collection contains %v, expected %v", got, expected)
+	}
+
+	archive, err := os.Open(filepath.Join(s.Config.ArchiveDirectory, "fixtures", "2020-09-01.ndjson.gz"))
+	if err != nil {
+		t.Fatalf("archive is missing: %v", err)
+	}
+	defer archive.Close()
+
+	reader, err := gzip.NewReader(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := ioutil.ReadAll(reader)
+	if err != nil || len(content) == 0 {
+		t.Errorf("archive is invalid: %v", err)
+	}
+}
@@ -0,0 +1,155 @@
+/*
+segment.go
+Implements segments, which are the append-only data files of a collection.
+Every line of a segment contains a single data item as json. A segment is
+written per day, so data items never have to be rewritten.
+
+###################################################################################
+
+MIT License
+
+Copyright (c) 2020 Bruno Hautzenberger
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"log"
+	"os"
+)
+
+//segmentFileExtension is the file extension of segments
+const segmentFileExtension = "ndjson"
+
+//legacyFileExtension is the file extension of data files, which contain all
+//data items of a day as a single json object
+const legacyFileExtension = "json"
+
+//recoveryChunkSize is the number of bytes read at once, while searching the
+//end of the last complete line of a segment
+const recoveryChunkSize = 4096
+
+//readSegment calls fn for every data item of a segment. An incomplete last
+//line is ignored, because it is either still written or was left by a crash.
+func readSegment(segmentPath string, fn func(data *Data) error) error {
+	file, err := os.Open(segmentPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+
+		data := &Data{}
+		if err := json.Unmarshal(line, data); err != nil {
+			return err
+		}
+
+		if err := fn(data); err != nil {
+			return err
+		}
+	}
+}
+
+//openSegment opens a segment for appending and creates it, if it does not
+//exist yet. An incomplete last line, left by a crash during a write, is cut
+//off first, so the next data item starts on a new line.
+func openSegment(segmentPath string) (*os.File, error) {
+	file, err := os.OpenFile(segmentPath, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := recoverSegment(file); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return file, nil
+}
+
+//recoverSegment truncates a segment after its last complete line.
+func recoverSegment(file *os.File) error {
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	size := info.Size()
+	end := size
+	chunk := make([]byte, recoveryChunkSize)
+	for end > 0 {
+		start := end - recoveryChunkSize
+		if start < 0 {
+			start = 0
+		}
+
+		n, err := file.ReadAt(chunk[:end-start], start)
+		if err != nil && err != io.EOF {
+			return err
+		}
+
+		if i := bytes.LastIndexByte(chunk[:n], '\n'); i >= 0 {
+			end = start + int64(i) + 1
+			break
+		}
+
+		end = start
+	}
+
+	if end == size {
+		return nil
+	}
+
+	log.Printf("Removing incomplete last line of segment %v\n", file.Name())
+	if err := file.Truncate(end); err != nil {
+		return err
+	}
+
+	return file.Sync()
+}
+
+//appendSegment writes a data item as a new line to a segment.
+func appendSegment(file *os.File, data *Data) error {
+	line, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	_, err = file.Write(append(line, '\n'))
+	return err
+}
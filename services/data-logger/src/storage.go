@@ -1,7 +1,8 @@
 /*
 storage.go
 Defines the storage interface of this application and implements the
-actual json object storage based on data files.
+actual json object storage based on data files. Data items are appended to a
+segment per day and collection.
 
 ###################################################################################
 
@@ -31,31 +32,67 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
+	"log"
 	"os"
 	"path/filepath"
+	"strings"
 	"sync"
 	"time"
 )
 
+//ErrStopReading is returned by callbacks of EachData to stop reading early.
+var ErrStopReading = errors.New("Stop reading")
+
+//ErrCollectionNotFound is returned, if a collection does not exist.
+var ErrCollectionNotFound = errors.New("Collection not found")
+
 //StorageInterface defines the interface for the data storage.
 type StorageInterface interface {
-	Initialize(dataRootDirectory string)
-	ReadData(collectionName string, startDate time.Time, endDate time.Time) ([]*Data, error)
+	Initialize(config StorageConfig)
+	Start()
+	ReadData(collectionName string, timeRange TimeRange) ([]*Data, error)
+	EachData(collectionName string, timeRange TimeRange, fn func(data *Data) error) error
 	WriteData(collectionName string, payload map[string]interface{}) (*Data, error)
 	ListCollections() ([]string, error)
+	MigrateDataFiles() (int, error)
+	GetRetention(collectionName string) (Retention, error)
+	SetRetention(collectionName string, retention Retention) error
+	CollectionSize(collectionName string) (int64, int, error)
+	ApplyRetention() error
+	Close()
 }
 
 //Implements StorageInterface
 type Storage struct {
 	DataRootDirectory string
+	Config            StorageConfig
 	MutexLock         sync.Mutex
+	segments          map[string]*os.File
+	stop              chan struct{}
 }
 
-// Initialize sets the data root directory
-func (s *Storage) Initialize(dataRootDirectory string) {
-	s.DataRootDirectory = dataRootDirectory
+// Initialize sets the data root directory.
+func (s *Storage) Initialize(config StorageConfig) {
+	s.Config = config
+	s.DataRootDirectory = config.DataRootDirectory
+	s.segments = make(map[string]*os.File)
+	s.stop = make(chan struct{})
+}
+
+// Start starts syncing segments periodically, if the sync mode is interval,
+// and the janitor applying the retention policies. Both run until the storage
+// is closed, so they are only started by the server.
+func (s *Storage) Start() {
+	if s.Config.SyncMode == SyncModeInterval {
+		go s.syncSegments()
+	}
+
+	if s.Config.JanitorInterval > 0 {
+		go s.runJanitor()
+	}
 }
 
 // getCollectionPath get's the actual path of a collection.
@@ -72,43 +109,69 @@ func (s *Storage) getCollectionPath(collectionName string) (string, error) {
 	return path, nil
 }
 
-// getCurrentDatafilePath gets the path of the current data file
-func (s *Storage) getCurrentDatafilePath(collectionName string) (string, error) {
+// lookupCollectionPath get's the path of an existing collection without
+// creating it. It returns ErrCollectionNotFound, if the collection does not
+// exist.
+func (s *Storage) lookupCollectionPath(collectionName string) (string, error) {
+	path := filepath.Join(s.DataRootDirectory, collectionName)
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) || (err == nil && !info.IsDir()) {
+		return path, ErrCollectionNotFound
+	}
+
+	return path, err
+}
+
+// getDataFileName gets the name of the data file of a day with given file
+// extension
+func getDataFileName(day time.Time, extension string) string {
+	return fmt.Sprintf("%v.%v", day.Format("2006-01-02"), extension)
+}
+
+// parseDataFileName parses the day and the file extension of the name of a
+// data file. It returns false, if the file is not a data file.
+func parseDataFileName(name string) (time.Time, string, bool) {
+	extension := strings.TrimPrefix(filepath.Ext(name), ".")
+	if extension != segmentFileExtension && extension != legacyFileExtension {
+		return time.Time{}, extension, false
+	}
+
+	day, err := time.Parse("2006-01-02", strings.TrimSuffix(name, "."+extension))
+	if err != nil {
+		return time.Time{}, extension, false
+	}
+
+	return day, extension, true
+}
+
+// getCurrentSegmentPath gets the path of the current segment
+func (s *Storage) getCurrentSegmentPath(collectionName string) (string, error) {
 	collectionPath, err := s.getCollectionPath(collectionName)
 	if err != nil {
 		return "", err
 	}
 
-	currentDataFileName := fmt.Sprintf("%v.%v", time.Now().UTC().Format("2006-01-02"), "json")
-
-	return filepath.Join(collectionPath, currentDataFileName), nil
+	return filepath.Join(collectionPath, getDataFileName(time.Now().UTC(), segmentFileExtension)), nil
 }
 
 // getDataFilePathsInRange get's all path of the data files of a collection
-// used in a given time range.
-func (s *Storage) getDataFilePathsInRange(collectionName string, startDate time.Time, endDate time.Time) ([]string, error) {
+// used in a given time range. Days which were not migrated yet can have a
+// legacy data file, which comes before the segment of the same day.
+func (s *Storage) getDataFilePathsInRange(collectionName string, timeRange TimeRange) ([]string, error) {
 	dataFilePaths := make([]string, 0)
 
-	// truncate time part to 00:00:00
-	currentDate := startDate.Truncate(24 * time.Hour)
-	limitDate := endDate.Truncate(24 * time.Hour)
-
 	// get collaction path
 	collectionPath, err := s.getCollectionPath(collectionName)
 	if err != nil {
 		return dataFilePaths, err
 	}
 
-	for currentDate.Before(limitDate) || currentDate.Equal(limitDate) {
+	for _, day := range timeRange.Days() {
 		dataFilePaths = append(
 			dataFilePaths,
-			filepath.Join(
-				collectionPath,
-				fmt.Sprintf("%v.%v", currentDate.Format("2006-01-02"), "json"),
-			),
+			filepath.Join(collectionPath, getDataFileName(day, legacyFileExtension)),
+			filepath.Join(collectionPath, getDataFileName(day, segmentFileExtension)),
 		)
-
-		currentDate = currentDate.AddDate(0, 0, 1)
 	}
 
 	return dataFilePaths, nil
@@ -151,66 +214,195 @@ func (s *Storage) readDataFile(dataFilePath string) (*DataFileContent, error) {
 }
 
 // ReadData loads all data items of a collection in a given time range
-func (s *Storage) ReadData(collectionName string, startDate time.Time, endDate time.Time) ([]*Data, error) {
+func (s *Storage) ReadData(collectionName string, timeRange TimeRange) ([]*Data, error) {
 	result := make([]*Data, 0)
 
-	filePaths, err := s.getDataFilePathsInRange(collectionName, startDate, endDate)
+	err := s.EachData(collectionName, timeRange, func(data *Data) error {
+		result = append(result, data)
+		return nil
+	})
+
+	return result, err
+}
+
+// EachData calls fn for every data item of a collection in a given time
+// range, in the order they were written, without loading whole data files
+// first. Reading stops without an error, if fn returns ErrStopReading.
+func (s *Storage) EachData(collectionName string, timeRange TimeRange, fn func(data *Data) error) error {
+	filePaths, err := s.getDataFilePathsInRange(collectionName, timeRange)
 	if err != nil {
-		return result, err
+		return err
 	}
 
+	inRange := func(data *Data) error {
+		if timeRange.Contains(data.CreatedAt) {
+			return fn(data)
+		}
+		return nil
+	}
+
+	// UUIDs of the legacy data file of the current day. Until an interrupted
+	// migration is resumed, its items are contained in the segment as well.
+	var legacy map[string]bool
+
 	for _, df := range filePaths {
-		if s.fileExists(df) {
-			dfContent, err := s.readDataFile(df)
-			if err != nil {
-				return result, err
-			}
+		isSegment := strings.HasSuffix(df, segmentFileExtension)
+		if !isSegment {
+			legacy = nil
+		}
+		if !s.fileExists(df) {
+			continue
+		}
 
-			result = append(result, dfContent.GetItemsInRange(startDate, endDate)...)
+		if isSegment {
+			err = readSegment(df, func(data *Data) error {
+				if legacy[data.UUID] {
+					return nil
+				}
+				return inRange(data)
+			})
+		} else {
+			legacy = map[string]bool{}
+			err = s.eachDataFileItem(df, func(data *Data) error {
+				legacy[data.UUID] = true
+				return inRange(data)
+			})
+		}
+
+		if err == ErrStopReading {
+			return nil
+		}
+		if err != nil {
+			return err
 		}
 	}
 
-	return result, nil
+	return nil
 }
 
-// writeDataFile writes a data file
-func (s *Storage) writeDataFile(dataFilePath string, file []byte) error {
-	s.MutexLock.Lock()
-	defer s.MutexLock.Unlock()
+// eachDataFileItem calls fn for every data item of a legacy data file
+func (s *Storage) eachDataFileItem(dataFilePath string, fn func(data *Data) error) error {
+	dfContent, err := s.readDataFile(dataFilePath)
+	if err != nil {
+		return err
+	}
 
-	return ioutil.WriteFile(dataFilePath, file, 0755)
+	for _, item := range dfContent.Items {
+		if err := fn(item); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// getSegment returns the open current segment of a collection. Segments of
+// previous days are closed.
+func (s *Storage) getSegment(collectionName string) (*os.File, error) {
+	segmentPath, err := s.getCurrentSegmentPath(collectionName)
+	if err != nil {
+		return nil, err
+	}
+
+	if file, ok := s.segments[collectionName]; ok {
+		if file.Name() == segmentPath {
+			return file, nil
+		}
+
+		s.closeSegment(collectionName)
+	}
+
+	file, err := openSegment(segmentPath)
+	if err != nil {
+		return nil, err
+	}
+	s.segments[collectionName] = file
+
+	return file, nil
+}
+
+// closeSegment syncs and closes the open segment of a collection
+func (s *Storage) closeSegment(collectionName string) {
+	file, ok := s.segments[collectionName]
+	if !ok {
+		return
+	}
+
+	if err := file.Sync(); err != nil {
+		log.Printf("Failed to sync segment %v: %v\n", file.Name(), err)
+	}
+	file.Close()
+	delete(s.segments, collectionName)
+}
+
+// syncSegments syncs all open segments periodically, until the storage is
+// closed.
+func (s *Storage) syncSegments() {
+	ticker := time.NewTicker(s.Config.SyncInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			s.MutexLock.Lock()
+			for _, file := range s.segments {
+				if err := file.Sync(); err != nil {
+					log.Printf("Failed to sync segment %v: %v\n", file.Name(), err)
+				}
+			}
+			s.MutexLock.Unlock()
+		}
+	}
 }
 
 // WriteData stores a new data item to a collection and returns it wrapped in a
-// *Data structure
+// *Data structure. The data item is appended to the current segment of the
+// collection and synced according to the sync mode.
 func (s *Storage) WriteData(collectionName string, payload map[string]interface{}) (*Data, error) {
 	data := &Data{
 		Payload: payload,
 	}
 	data.Initialize()
 
-	dataFilePath, err := s.getCurrentDatafilePath(collectionName)
-	if err != nil {
-		return data, err
-	}
-
-	dataFile, err := s.readDataFile(dataFilePath)
-
-	dataFile.Items = append(dataFile.Items, data)
+	s.MutexLock.Lock()
+	defer s.MutexLock.Unlock()
 
-	file, err := json.Marshal(dataFile)
+	file, err := s.getSegment(collectionName)
 	if err != nil {
 		return data, err
 	}
 
-	err = s.writeDataFile(dataFilePath, file)
+	err = appendSegment(file, data)
+	if err == nil && s.Config.SyncMode == SyncModeAlways {
+		err = file.Sync()
+	}
 	if err != nil {
+		// the segment is recovered, when it is opened again
+		s.closeSegment(collectionName)
 		return data, err
 	}
 
 	return data, nil
 }
 
+// Close syncs and closes all open segments.
+func (s *Storage) Close() {
+	s.MutexLock.Lock()
+	defer s.MutexLock.Unlock()
+
+	select {
+	case <-s.stop:
+	default:
+		close(s.stop)
+	}
+
+	for collectionName := range s.segments {
+		s.closeSegment(collectionName)
+	}
+}
+
 // ListCollections return all available collections
 func (s *Storage) ListCollections() ([]string, error) {
 	collections := make([]string, 0)
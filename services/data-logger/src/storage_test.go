@@ -0,0 +1,230 @@
+/*
+storage_test.go
+Tests of reading data items from the day files of a collection.
+
+###################################################################################
+
+MIT License
+
+Copyright (c) 2020 Bruno Hautzenberger
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+//fixtureItems are the data items of the fixture collection by the day file
+//they are written to. Items of 2020-09-02 are in a legacy data file, all
+//others in segments. 2020-09-04 has no data file.
+var fixtureItems = map[string][]string{
+	"2020-09-01": {"2020-09-01T00:00:00Z", "2020-09-01T12:00:00Z", "2020-09-01T23:59:59.999Z"},
+	"2020-09-02": {"2020-09-02T00:00:00Z", "2020-09-02T22:00:00Z"},
+	"2020-09-03": {"2020-09-03T00:00:00Z", "2020-09-03T23:59:59Z"},
+	"2020-09-05": {"2020-09-05T00:00:00Z"},
+}
+
+//newFixtureStorage creates a storage in a temporary DATA_DIRECTORY, which is
+//removed once the test finished, with the collection "fixtures" holding the
+//fixtureItems. The UUID of every item is its creation time.
+func newFixtureStorage(t *testing.T) *Storage {
+	t.Helper()
+
+	dir, err := ioutil.TempDir("", "data-logger")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	s := &Storage{}
+	s.Initialize(StorageConfig{DataRootDirectory: dir, SyncMode: SyncModeNone})
+	t.Cleanup(s.Close)
+
+	collectionPath := filepath.Join(dir, "fixtures")
+	if err := os.Mkdir(collectionPath, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	for day, times := range fixtureItems {
+		items := make([]*Data, 0, len(times))
+		for _, createdAt := range times {
+			items = append(items, &Data{UUID: createdAt, CreatedAt: mustParse(t, createdAt), Payload: map[string]interface{}{"day": day}})
+		}
+
+		if day == "2020-09-02" {
+			writeLegacyFixture(t, filepath.Join(collectionPath, day+"."+legacyFileExtension), items)
+		} else {
+			writeSegmentFixture(t, filepath.Join(collectionPath, day+"."+segmentFileExtension), items)
+		}
+	}
+
+	return s
+}
+
+//writeSegmentFixture writes the data items to a segment.
+func writeSegmentFixture(t *testing.T, segmentPath string, items []*Data) {
+	t.Helper()
+
+	file, err := openSegment(segmentPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	for _, item := range items {
+		if err := appendSegment(file, item); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+//writeLegacyFixture writes the data items to a legacy data file.
+func writeLegacyFixture(t *testing.T, dataFilePath string, items []*Data) {
+	t.Helper()
+
+	content, err := json.Marshal(DataFileContent{Items: items})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ioutil.WriteFile(dataFilePath, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+//uuids returns the UUIDs of the data items.
+func uuids(items []*Data) []string {
+	result := make([]string, 0, len(items))
+	for _, item := range items {
+		result = append(result, item.UUID)
+	}
+	return result
+}
+
+func TestReadDataBounds(t *testing.T) {
+	s := newFixtureStorage(t)
+
+	tests := []struct {
+		name           string
+		start          string
+		end            string
+		startExclusive bool
+		endExclusive   bool
+		expected       []string
+	}{
+		{"whole day", "2020-09-01T00:00:00Z", "2020-09-01T23:59:59.999Z", false, false,
+			[]string{"2020-09-01T00:00:00Z", "2020-09-01T12:00:00Z", "2020-09-01T23:59:59.999Z"}},
+		{"whole day exclusive", "2020-09-01T00:00:00Z", "2020-09-01T23:59:59.999Z", true, true,
+			[]string{"2020-09-01T12:00:00Z"}},
+		{"across midnight", "2020-09-01T23:59:59.999Z", "2020-09-02T00:00:00Z", false, false,
+			[]string{"2020-09-01T23:59:59.999Z", "2020-09-02T00:00:00Z"}},
+		{"across midnight exclusive start", "2020-09-01T23:59:59.999Z", "2020-09-02T00:00:00Z", true, false,
+			[]string{"2020-09-02T00:00:00Z"}},
+		{"across midnight exclusive end", "2020-09-01T23:59:59.999Z", "2020-09-02T00:00:00Z", false, true,
+			[]string{"2020-09-01T23:59:59.999Z"}},
+		{"starts at midnight", "2020-09-03T00:00:00Z", "2020-09-05T00:00:00Z", false, false,
+			[]string{"2020-09-03T00:00:00Z", "2020-09-03T23:59:59Z", "2020-09-05T00:00:00Z"}},
+		{"ends at midnight exclusive", "2020-09-03T00:00:00Z", "2020-09-05T00:00:00Z", false, true,
+			[]string{"2020-09-03T00:00:00Z", "2020-09-03T23:59:59Z"}},
+		{"several days", "2020-09-01T12:00:00Z", "2020-09-03T00:00:00Z", true, true,
+			[]string{"2020-09-01T23:59:59.999Z", "2020-09-02T00:00:00Z", "2020-09-02T22:00:00Z"}},
+		{"day without data file", "2020-09-04T00:00:00Z", "2020-09-04T23:59:59Z", false, false,
+			[]string{}},
+		{"positive offset across the UTC midnight", "2020-09-02T01:00:00+02:00", "2020-09-02T02:00:00+02:00", false, false,
+			[]string{"2020-09-01T23:59:59.999Z", "2020-09-02T00:00:00Z"}},
+		{"positive offset exclusive end", "2020-09-02T01:00:00+02:00", "2020-09-02T02:00:00+02:00", false, true,
+			[]string{"2020-09-01T23:59:59.999Z"}},
+		{"negative offset on the next UTC day", "2020-09-02T18:00:00-04:00", "2020-09-02T20:00:00-04:00", false, false,
+			[]string{"2020-09-02T22:00:00Z", "2020-09-03T00:00:00Z"}},
+		{"negative offset exclusive start", "2020-09-02T20:00:00-04:00", "2020-09-03T19:59:59-04:00", true, false,
+			[]string{"2020-09-03T23:59:59Z"}},
+	}
+
+	for _, test := range tests {
+		timeRange := TimeRange{
+			Start:          mustParse(t, test.start),
+			End:            mustParse(t, test.end),
+			StartExclusive: test.startExclusive,
+			EndExclusive:   test.endExclusive,
+		}
+
+		items, err := s.ReadData("fixtures", timeRange)
+		if err != nil {
+			t.Errorf("%v: ReadData failed: %v", test.name, err)
+			continue
+		}
+
+		if got := uuids(items); !reflect.DeepEqual(got, test.expected) {
+			t.Errorf("%v: got %v, expected %v", test.name, got, test.expected)
+		}
+	}
+}
+
+func TestEachDataStopsReading(t *testing.T) {
+	s := newFixtureStorage(t)
+	timeRange := TimeRange{Start: mustParse(t, "2020-09-01T00:00:00Z"), End: mustParse(t, "2020-09-05T00:00:00Z")}
+
+	read := make([]string, 0)
+	err := s.EachData("fixtures", timeRange, func(data *Data) error {
+		read = append(read, data.UUID)
+		if len(read) == 4 {
+			return ErrStopReading
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("EachData failed: %v", err)
+	}
+
+	expected := []string{"2020-09-01T00:00:00Z", "2020-09-01T12:00:00Z", "2020-09-01T23:59:59.999Z", "2020-09-02T00:00:00Z"}
+	if !reflect.DeepEqual(read, expected) {
+		t.Errorf("read %v, expected %v", read, expected)
+	}
+}
+
+func TestEachDataSkipsItemsOfInterruptedMigration(t *testing.T) {
+	s := newFixtureStorage(t)
+
+	// an interrupted migration left the items of the legacy data file in the
+	// segment of the same day as well
+	items := []*Data{
+		{UUID: "2020-09-02T00:00:00Z", CreatedAt: mustParse(t, "2020-09-02T00:00:00Z")},
+		{UUID: "2020-09-02T22:00:00Z", CreatedAt: mustParse(t, "2020-09-02T22:00:00Z")},
+		{UUID: "2020-09-02T23:00:00Z", CreatedAt: mustParse(t, "2020-09-02T23:00:00Z")},
+	}
+	writeSegmentFixture(t, filepath.Join(s.DataRootDirectory, "fixtures", "2020-09-02."+segmentFileExtension), items)
+
+	timeRange := TimeRange{Start: mustParse(t, "2020-09-02T00:00:00Z"), End: mustParse(t, "2020-09-02T23:59:59Z")}
+	read, err := s.ReadData("fixtures", timeRange)
+	if err != nil {
+		t.Fatalf("ReadData failed: %v", err)
+	}
+
+	expected := []string{"2020-09-02T00:00:00Z", "2020-09-02T22:00:00Z", "2020-09-02T23:00:00Z"}
+	if got := uuids(read); !reflect.DeepEqual(got, expected) {
+		t.Errorf("got %v, expected %v", got, expected)
+	}
+}
@@ -0,0 +1,109 @@
+/*
+timerange.go
+Implements time ranges, which are used to query data items of a collection.
+Both bounds of a time range can either be inclusive or exclusive.
+
+###################################################################################
+
+MIT License
+
+Copyright (c) 2020 Bruno Hautzenberger
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+package main
+
+import (
+	"errors"
+	"time"
+)
+
+//DefaultQueryRange is the duration queried, if the start of a time range is
+//not given.
+const DefaultQueryRange = 24 * time.Hour
+
+//ErrInvalidTimeRange is returned, if the start of a time range is after its
+//end.
+var ErrInvalidTimeRange = errors.New("Start of the time range must not be after its end")
+
+//ErrTimeRangeTooLong is returned, if a time range is longer than allowed.
+var ErrTimeRangeTooLong = errors.New("Time range is too long")
+
+//TimeRange defines the time range of a query. Start and End are included,
+//unless StartExclusive or EndExclusive are set.
+type TimeRange struct {
+	Start          time.Time
+	End            time.Time
+	StartExclusive bool
+	EndExclusive   bool
+}
+
+//Validate checks if the time range is not reversed, not empty and not longer
+//than maxRange, so queries never read an unbounded number of days.
+func (tr TimeRange) Validate(maxRange time.Duration) error {
+	if tr.Start.After(tr.End) {
+		return ErrInvalidTimeRange
+	}
+
+	if tr.Start.Equal(tr.End) && (tr.StartExclusive || tr.EndExclusive) {
+		return ErrInvalidTimeRange
+	}
+
+	if tr.End.Sub(tr.Start) > maxRange {
+		return ErrTimeRangeTooLong
+	}
+
+	return nil
+}
+
+//Contains checks if given time is within the time range.
+func (tr TimeRange) Contains(t time.Time) bool {
+	if t.Before(tr.Start) || (tr.StartExclusive && t.Equal(tr.Start)) {
+		return false
+	}
+
+	if t.After(tr.End) || (tr.EndExclusive && t.Equal(tr.End)) {
+		return false
+	}
+
+	return true
+}
+
+//Days returns the UTC dates of all days touched by the time range, which are
+//the names of the data files containing its data items. The bounds are
+//converted to UTC first, so time ranges given in any timezone end up in the
+//right data files.
+func (tr TimeRange) Days() []time.Time {
+	days := make([]time.Time, 0)
+
+	current := utcDate(tr.Start)
+	last := utcDate(tr.End)
+	for !current.After(last) {
+		days = append(days, current)
+		current = current.AddDate(0, 0, 1)
+	}
+
+	return days
+}
+
+//utcDate returns midnight of the UTC day of given time.
+func utcDate(t time.Time) time.Time {
+	year, month, day := t.UTC().Date()
+	return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+}
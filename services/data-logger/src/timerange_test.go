@@ -0,0 +1,226 @@
+/*
+timerange_test.go
+Tests the time ranges of queries: inclusive and exclusive bounds, ranges
+spanning multiple days, default and last ranges and timezone offsets.
+
+###################################################################################
+
+MIT License
+
+Copyright (c) 2020 Bruno Hautzenberger
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+//mustParse parses an RFC3339 date or fails the test.
+func mustParse(t *testing.T, value string) time.Time {
+	t.Helper()
+
+	parsed, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return parsed
+}
+
+func TestTimeRangeContainsBounds(t *testing.T) {
+	start := mustParse(t, "2020-09-01T10:00:00Z")
+	end := mustParse(t, "2020-09-01T12:00:00Z")
+
+	tests := []struct {
+		name           string
+		startExclusive bool
+		endExclusive   bool
+		time           time.Time
+		expected       bool
+	}{
+		{"inclusive start", false, false, start, true},
+		{"inclusive end", false, false, end, true},
+		{"exclusive start", true, false, start, false},
+		{"exclusive end", false, true, end, false},
+		{"inside exclusive bounds", true, true, start.Add(time.Minute), true},
+		{"before start", false, false, start.Add(-time.Nanosecond), false},
+		{"after end", false, false, end.Add(time.Nanosecond), false},
+	}
+
+	for _, test := range tests {
+		tr := TimeRange{Start: start, End: end, StartExclusive: test.startExclusive, EndExclusive: test.endExclusive}
+		if actual := tr.Contains(test.time); actual != test.expected {
+			t.Errorf("%v: Contains(%v) = %v, expected %v", test.name, test.time, actual, test.expected)
+		}
+	}
+}
+
+func TestTimeRangeValidate(t *testing.T) {
+	start := mustParse(t, "2020-09-01T10:00:00Z")
+	maxRange := 7 * 24 * time.Hour
+
+	tests := []struct {
+		name     string
+		tr       TimeRange
+		expected error
+	}{
+		{"valid", TimeRange{Start: start, End: start.Add(time.Hour)}, nil},
+		{"single instant", TimeRange{Start: start, End: start}, nil},
+		{"empty exclusive start", TimeRange{Start: start, End: start, StartExclusive: true}, ErrInvalidTimeRange},
+		{"empty exclusive end", TimeRange{Start: start, End: start, EndExclusive: true}, ErrInvalidTimeRange},
+		{"reversed", TimeRange{Start: start, End: start.Add(-time.Hour)}, ErrInvalidTimeRange},
+		{"maximum", TimeRange{Start: start, End: start.Add(maxRange)}, nil},
+		{"too long", TimeRange{Start: start, End: start.Add(maxRange + time.Second)}, ErrTimeRangeTooLong},
+		{"since year 1", TimeRange{Start: time.Time{}, End: start}, ErrTimeRangeTooLong},
+	}
+
+	for _, test := range tests {
+		if actual := test.tr.Validate(maxRange); actual != test.expected {
+			t.Errorf("%v: Validate() = %v, expected %v", test.name, actual, test.expected)
+		}
+	}
+}
+
+func TestTimeRangeDays(t *testing.T) {
+	tests := []struct {
+		name     string
+		start    string
+		end      string
+		expected []string
+	}{
+		{"single day", "2020-09-01T10:00:00Z", "2020-09-01T12:00:00Z", []string{"2020-09-01"}},
+		{"multiple days", "2020-09-01T23:00:00Z", "2020-09-03T01:00:00Z", []string{"2020-09-01", "2020-09-02", "2020-09-03"}},
+		{"month boundary", "2020-08-31T12:00:00Z", "2020-09-01T12:00:00Z", []string{"2020-08-31", "2020-09-01"}},
+		{"positive offset", "2020-09-02T01:00:00+02:00", "2020-09-02T03:00:00+02:00", []string{"2020-09-01", "2020-09-02"}},
+		{"negative offset", "2020-09-01T22:00:00-05:00", "2020-09-01T23:00:00-05:00", []string{"2020-09-02"}},
+	}
+
+	for _, test := range tests {
+		tr := TimeRange{Start: mustParse(t, test.start), End: mustParse(t, test.end)}
+		days := tr.Days()
+
+		actual := make([]string, 0, len(days))
+		for _, day := range days {
+			if day.Location() != time.UTC {
+				t.Errorf("%v: day %v is not in UTC", test.name, day)
+			}
+			actual = append(actual, day.Format("2006-01-02"))
+		}
+
+		if len(actual) != len(test.expected) {
+			t.Errorf("%v: Days() = %v, expected %v", test.name, actual, test.expected)
+			continue
+		}
+		for i := range actual {
+			if actual[i] != test.expected[i] {
+				t.Errorf("%v: Days() = %v, expected %v", test.name, actual, test.expected)
+				break
+			}
+		}
+	}
+}
+
+func TestGetTimeRange(t *testing.T) {
+	a := &API{Config: APIConfig{MaxQueryRange: 366 * 24 * time.Hour}}
+
+	tests := []struct {
+		name           string
+		query          string
+		start          string
+		end            string
+		startExclusive bool
+		endExclusive   bool
+	}{
+		{"inclusive bounds", "from=2020-09-01T10:00:00Z&to=2020-09-03T22:00:00Z", "2020-09-01T10:00:00Z", "2020-09-03T22:00:00Z", false, false},
+		{"exclusive bounds", "after=2020-09-01T10:00:00Z&before=2020-09-03T22:00:00Z", "2020-09-01T10:00:00Z", "2020-09-03T22:00:00Z", true, true},
+		{"default range", "to=2020-09-03T22:00:00Z", "2020-09-02T22:00:00Z", "2020-09-03T22:00:00Z", false, false},
+		{"last", "last=90m&before=2020-09-03T22:00:00Z", "2020-09-03T20:30:00Z", "2020-09-03T22:00:00Z", false, true},
+		{"offsets", "from=2020-09-01T10:00:00%2B02:00&to=2020-09-01T10:00:00-03:00", "2020-09-01T08:00:00Z", "2020-09-01T13:00:00Z", false, false},
+	}
+
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", "/testCollection?"+test.query, nil)
+		tr, errorMessage := a.GetTimeRange(r)
+		if errorMessage != nil {
+			t.Errorf("%v: unexpected error %v", test.name, errorMessage.Message)
+			continue
+		}
+
+		if !tr.Start.Equal(mustParse(t, test.start)) || !tr.End.Equal(mustParse(t, test.end)) {
+			t.Errorf("%v: got %v - %v, expected %v - %v", test.name, tr.Start, tr.End, test.start, test.end)
+		}
+
+		if tr.StartExclusive != test.startExclusive || tr.EndExclusive != test.endExclusive {
+			t.Errorf("%v: got exclusive %v/%v, expected %v/%v", test.name, tr.StartExclusive, tr.EndExclusive, test.startExclusive, test.endExclusive)
+		}
+	}
+}
+
+func TestGetTimeRangeDefaultsToNow(t *testing.T) {
+	a := &API{Config: APIConfig{MaxQueryRange: 366 * 24 * time.Hour}}
+
+	before := time.Now().UTC()
+	tr, errorMessage := a.GetTimeRange(httptest.NewRequest("GET", "/testCollection", nil))
+	after := time.Now().UTC()
+	if errorMessage != nil {
+		t.Fatal(errorMessage.Message)
+	}
+
+	if tr.End.Before(before) || tr.End.After(after) {
+		t.Errorf("End %v is not now", tr.End)
+	}
+
+	if tr.End.Sub(tr.Start) != DefaultQueryRange {
+		t.Errorf("Range %v is not the default range %v", tr.End.Sub(tr.Start), DefaultQueryRange)
+	}
+}
+
+func TestGetTimeRangeErrors(t *testing.T) {
+	a := &API{Config: APIConfig{MaxQueryRange: 366 * 24 * time.Hour}}
+
+	tests := []struct {
+		name     string
+		query    string
+		expected ErrorCode
+	}{
+		{"invalid from", "from=yesterday", ErrorCodeInvalidFromDate},
+		{"invalid before", "before=tomorrow", ErrorCodeInvalidToDate},
+		{"from and after", "from=2020-09-01T10:00:00Z&after=2020-09-01T10:00:00Z", ErrorCodeInvalidTimeRange},
+		{"from and last", "from=2020-09-01T10:00:00Z&last=1h", ErrorCodeInvalidTimeRange},
+		{"invalid last", "last=-1h", ErrorCodeInvalidDuration},
+		{"reversed", "from=2020-09-02T10:00:00Z&to=2020-09-01T10:00:00Z", ErrorCodeInvalidTimeRange},
+		{"too long", "from=0001-01-01T00:00:00Z&to=2020-09-01T10:00:00Z", ErrorCodeInvalidTimeRange},
+	}
+
+	for _, test := range tests {
+		_, errorMessage := a.GetTimeRange(httptest.NewRequest("GET", "/testCollection?"+test.query, nil))
+		if errorMessage == nil {
+			t.Errorf("%v: expected error code %v", test.name, test.expected)
+			continue
+		}
+
+		if errorMessage.Code != test.expected {
+			t.Errorf("%v: got error code %v, expected %v", test.name, errorMessage.Code, test.expected)
+		}
+	}
+}
@@ -2,4 +2,8 @@ module in-memory-db
 
 go 1.14
 
-require github.com/gorilla/mux v1.7.4
+require (
+	github.com/gorilla/mux v1.7.4
+	google.golang.org/grpc v1.46.2
+	google.golang.org/protobuf v1.28.0
+)
@@ -0,0 +1,324 @@
+/*
+access.go
+Implements the access control of this service. Requests are authenticated using
+bearer tokens, which are verified by the auth service, and authorized per realm
+and operation based on the permissions of the token.
+
+###################################################################################
+
+MIT License
+
+Copyright (c) 2020 Bruno Hautzenberger
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+//Operation defines the type of access to a realm
+type Operation string
+
+const (
+	OperationRead  Operation = "read"
+	OperationWrite           = "write"
+)
+
+const (
+	//PermissionRoot grants all operations on all realms.
+	PermissionRoot = "ROOT"
+
+	//PermissionInMemoryDB grants the operations listed in its meta to the
+	//listed realm patterns, e.g. {"read":["*"], "write":["sessions"]}.
+	PermissionInMemoryDB = "IN-MEMORY-DB"
+
+	//AllRealms is the realm pattern used to check access to all realms.
+	AllRealms = "*"
+
+	//ChannelNamespace is prepended to channel names, before they are
+	//authorized, so permissions of realms never grant access to channels with
+	//the same name. Realm names can't contain "/", e.g. "channels/news".
+	ChannelNamespace = "channels/"
+)
+
+//ErrUnauthorized is returned, if a token is missing or invalid.
+var ErrUnauthorized = errors.New("Missing or invalid access token")
+
+//ErrAuthUnavailable is returned, if the auth service can't be reached.
+var ErrAuthUnavailable = errors.New("Auth service unavailable")
+
+//principalContextKey is the context key of the authenticated Principal.
+type principalContextKey struct{}
+
+//Permission holds all information about a specific permission, as served by
+//the auth service.
+type Permission struct {
+	Key  string                 `json:"key"`
+	Meta map[string]interface{} `json:"meta"`
+}
+
+//Principal holds the user and permissions of a verified access token, as
+//served by the decode method of the auth service.
+type Principal struct {
+	UserID      string       `json:"user-id"`
+	Permissions []Permission `json:"permissions"`
+	Expires     time.Time    `json:"expires"`
+}
+
+//isPattern checks if the given realm name contains pattern characters.
+func isPattern(realm string) bool {
+	return strings.ContainsAny(realm, "*?[\\")
+}
+
+//realmPatterns returns the realm patterns listed for given operation in the
+//meta of a permission.
+func (p Permission) realmPatterns(op Operation) []string {
+	patterns := make([]string, 0)
+
+	list, ok := p.Meta[string(op)].([]interface{})
+	if !ok {
+		return patterns
+	}
+
+	for _, item := range list {
+		if pattern, ok := item.(string); ok {
+			patterns = append(patterns, pattern)
+		}
+	}
+
+	return patterns
+}
+
+//Allows checks if this principal may perform given operation on given realm.
+//If the realm is a pattern itself, e.g. to watch multiple realms, access to
+//all realms or to exactly the same pattern is needed.
+func (p *Principal) Allows(realm string, op Operation) bool {
+	for _, permission := range p.Permissions {
+		if permission.Key == PermissionRoot {
+			return true
+		}
+
+		if permission.Key != PermissionInMemoryDB {
+			continue
+		}
+
+		for _, pattern := range permission.realmPatterns(op) {
+			if pattern == AllRealms || pattern == realm {
+				return true
+			}
+
+			if ok, _ := path.Match(pattern, realm); ok && !isPattern(realm) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+//channelResource returns the name, which is authorized for given channel or
+//channel pattern.
+func channelResource(channel string) string {
+	return ChannelNamespace + channel
+}
+
+//AccessInterface defines the interface for authentication and authorization
+//of requests.
+type AccessInterface interface {
+	Initialize(config AccessConfig)
+	Middleware(next http.Handler) http.Handler
+	Authorized(r *http.Request, realm string, op Operation) bool
+	Authenticate(ctx context.Context, authorization string) (context.Context, error)
+	AuthorizedContext(ctx context.Context, realm string, op Operation) bool
+}
+
+//cachedPrincipal is a verified Principal, which is cached until ExpiresAt.
+type cachedPrincipal struct {
+	Principal *Principal
+	ExpiresAt time.Time
+}
+
+//Access implements AccessInterface using the auth service to verify tokens.
+//Verified tokens are cached for a short time, to not call the auth service
+//for every single request.
+type Access struct {
+	Config    AccessConfig
+	Client    *http.Client
+	Cache     map[string]*cachedPrincipal
+	MutexLock sync.Mutex
+}
+
+//Initialize sets the configuration and creates an empty cache.
+func (a *Access) Initialize(config AccessConfig) {
+	a.Config = config
+	a.Client = &http.Client{Timeout: 5 * time.Second}
+	a.Cache = make(map[string]*cachedPrincipal)
+
+	if !a.Enabled() {
+		log.Printf("AUTH_DISABLED is set. Access control is disabled!\n")
+	}
+}
+
+//Enabled returns true, if access control is configured.
+func (a *Access) Enabled() bool {
+	return len(a.Config.AuthURL) > 0
+}
+
+//Middleware authenticates all requests using the bearer token of the
+//Authorization header and stores the Principal in the request context.
+func (a *Access) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !a.Enabled() {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ctx, err := a.Authenticate(r.Context(), r.Header.Get("Authorization"))
+		switch err {
+		case nil:
+			next.ServeHTTP(w, r.WithContext(ctx))
+		case ErrUnauthorized:
+			RaiseError(w, err.Error(), http.StatusUnauthorized, ErrorCodeUnauthorized)
+		default:
+			RaiseError(w, err.Error(), http.StatusServiceUnavailable, ErrorCodeAuthUnavailable)
+		}
+	})
+}
+
+//Authenticate verifies the bearer token of an Authorization header and
+//returns a context holding its Principal. It is used for requests, which
+//are not served by Middleware, e.g. gRPC calls.
+func (a *Access) Authenticate(ctx context.Context, authorization string) (context.Context, error) {
+	if !a.Enabled() {
+		return ctx, nil
+	}
+
+	token := strings.TrimSpace(strings.TrimPrefix(authorization, "Bearer "))
+	principal, err := a.authenticate(token)
+	if err != nil {
+		return nil, err
+	}
+
+	return context.WithValue(ctx, principalContextKey{}, principal), nil
+}
+
+//Authorized checks if the Principal of the request may perform given
+//operation on given realm. Use AllRealms to check access to all realms.
+func (a *Access) Authorized(r *http.Request, realm string, op Operation) bool {
+	return a.AuthorizedContext(r.Context(), realm, op)
+}
+
+//AuthorizedContext checks if the Principal stored in given context by
+//Authenticate may perform given operation on given realm.
+func (a *Access) AuthorizedContext(ctx context.Context, realm string, op Operation) bool {
+	if !a.Enabled() {
+		return true
+	}
+
+	principal, ok := ctx.Value(principalContextKey{}).(*Principal)
+	return ok && principal.Allows(realm, op)
+}
+
+//authenticate returns the Principal of given token, either from the cache or
+//by verifying it using the auth service.
+func (a *Access) authenticate(token string) (*Principal, error) {
+	if len(token) == 0 {
+		return nil, ErrUnauthorized
+	}
+
+	now := time.Now().UTC()
+
+	a.MutexLock.Lock()
+	cached, ok := a.Cache[token]
+	a.MutexLock.Unlock()
+
+	if ok && cached.ExpiresAt.After(now) {
+		return cached.Principal, nil
+	}
+
+	principal, err := a.decode(token)
+	if err != nil {
+		return nil, err
+	}
+
+	expiresAt := now.Add(a.Config.CacheTTL)
+	if principal.Expires.Before(expiresAt) {
+		expiresAt = principal.Expires
+	}
+
+	a.MutexLock.Lock()
+	defer a.MutexLock.Unlock()
+
+	for t, c := range a.Cache {
+		if !c.ExpiresAt.After(now) {
+			delete(a.Cache, t)
+		}
+	}
+	a.Cache[token] = &cachedPrincipal{principal, expiresAt}
+
+	return principal, nil
+}
+
+//decode verifies and decodes given token using the decode method of the auth
+//service.
+func (a *Access) decode(token string) (*Principal, error) {
+	body, err := json.Marshal(map[string]string{"access-token": token})
+	if err != nil {
+		return nil, err
+	}
+
+	url := fmt.Sprintf("%v/decode", strings.TrimSuffix(a.Config.AuthURL, "/"))
+	resp, err := a.Client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		log.Printf("Failed to decode token: %v\n", err)
+		return nil, ErrAuthUnavailable
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return nil, ErrAuthUnavailable
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, ErrUnauthorized
+	}
+
+	principal := &Principal{}
+	if err := json.NewDecoder(resp.Body).Decode(principal); err != nil {
+		return nil, ErrAuthUnavailable
+	}
+
+	if !principal.Expires.After(time.Now().UTC()) {
+		return nil, ErrUnauthorized
+	}
+
+	return principal, nil
+}
@@ -0,0 +1,79 @@
+/*
+access_test.go
+Tests of the authorization of realms and channels.
+
+###################################################################################
+
+MIT License
+
+Copyright (c) 2020 Bruno Hautzenberger
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+package main
+
+import (
+	"testing"
+)
+
+func TestPrincipalAllows(t *testing.T) {
+	principal := &Principal{Permissions: []Permission{{
+		Key: PermissionInMemoryDB,
+		Meta: map[string]interface{}{
+			"read":  []interface{}{"sess*", "news", channelResource("alerts-*"), channelResource("*")},
+			"write": []interface{}{"sessions"},
+		},
+	}}}
+
+	tests := []struct {
+		resource string
+		op       Operation
+		allowed  bool
+	}{
+		{"sessions", OperationRead, true},
+		{"sessions", OperationWrite, true},
+		{"users", OperationRead, false},
+		{"sess*", OperationRead, true},
+		{"se*", OperationRead, false},
+		{channelResource("alerts-cpu"), OperationRead, true},
+		{channelResource("alerts-*"), OperationRead, true},
+		{channelResource("*"), OperationRead, true},
+		{channelResource("sessions"), OperationWrite, false},
+		{channelResource("news"), OperationWrite, false},
+	}
+
+	for _, test := range tests {
+		if allowed := principal.Allows(test.resource, test.op); allowed != test.allowed {
+			t.Errorf("Allows(%v, %v) = %v, expected %v", test.resource, test.op, allowed, test.allowed)
+		}
+	}
+}
+
+func TestRealmPermissionsDoNotGrantChannels(t *testing.T) {
+	principal := &Principal{Permissions: []Permission{{
+		Key:  PermissionInMemoryDB,
+		Meta: map[string]interface{}{"read": []interface{}{"news"}, "write": []interface{}{"news"}},
+	}}}
+
+	for _, op := range []Operation{OperationRead, OperationWrite} {
+		if principal.Allows(channelResource("news"), op) {
+			t.Errorf("%v access to realm news grants %v access to channel news", op, op)
+		}
+	}
+}
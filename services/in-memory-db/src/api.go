@@ -32,7 +32,9 @@ package main
 import (
 	"encoding/json"
 	"fmt"
+	"mime"
 	"net/http"
+	"strings"
 
 	"github.com/gorilla/mux"
 )
@@ -41,35 +43,167 @@ import (
 type APIInterface interface {
 	Get(w http.ResponseWriter, r *http.Request)
 	Set(w http.ResponseWriter, r *http.Request)
+	CompareAndSwap(w http.ResponseWriter, r *http.Request)
 	Delete(w http.ResponseWriter, r *http.Request)
 	Keys(w http.ResponseWriter, r *http.Request)
 	Realms(w http.ResponseWriter, r *http.Request)
-	Initialize(storage StorageInterface)
+	GetRealmConfig(w http.ResponseWriter, r *http.Request)
+	SetRealmConfig(w http.ResponseWriter, r *http.Request)
+	DeleteRealm(w http.ResponseWriter, r *http.Request)
+	Scan(w http.ResponseWriter, r *http.Request)
+	Query(w http.ResponseWriter, r *http.Request)
+	Memory(w http.ResponseWriter, r *http.Request)
+	Info(w http.ResponseWriter, r *http.Request)
+	Metrics(w http.ResponseWriter, r *http.Request)
+	MGet(w http.ResponseWriter, r *http.Request)
+	MSet(w http.ResponseWriter, r *http.Request)
+	MDelete(w http.ResponseWriter, r *http.Request)
+	Transaction(w http.ResponseWriter, r *http.Request)
+	Script(w http.ResponseWriter, r *http.Request)
+	GetLock(w http.ResponseWriter, r *http.Request)
+	AcquireLock(w http.ResponseWriter, r *http.Request)
+	RenewLock(w http.ResponseWriter, r *http.Request)
+	ReleaseLock(w http.ResponseWriter, r *http.Request)
+	Watch(w http.ResponseWriter, r *http.Request)
+	Publish(w http.ResponseWriter, r *http.Request)
+	Subscribe(w http.ResponseWriter, r *http.Request)
+	ReplicationStream(w http.ResponseWriter, r *http.Request)
+	ReplicationStatus(w http.ResponseWriter, r *http.Request)
+	Promote(w http.ResponseWriter, r *http.Request)
+	ClusterStatus(w http.ResponseWriter, r *http.Request)
+	SetClusterNodes(w http.ResponseWriter, r *http.Request)
+	RateLimit(w http.ResponseWriter, r *http.Request)
+	Export(w http.ResponseWriter, r *http.Request)
+	Import(w http.ResponseWriter, r *http.Request)
+	Initialize(storage StorageInterface, locks LockManagerInterface, channels *EventBus, access AccessInterface, replication ReplicationInterface, cluster ClusterInterface)
 }
 
 //API implements APIInterface
 type API struct {
-	Storage StorageInterface
+	Storage     StorageInterface
+	Locks       LockManagerInterface
+	Channels    *EventBus
+	Access      AccessInterface
+	Replication ReplicationInterface
+	Cluster     ClusterInterface
 }
 
-//Initialize initializes the API by setting the active storage
-func (a *API) Initialize(storage StorageInterface) {
+//Initialize initializes the API by setting the active storage, lock manager,
+//the event bus used for channels, the access control, the replication and
+//the cluster
+func (a *API) Initialize(storage StorageInterface, locks LockManagerInterface, channels *EventBus, access AccessInterface, replication ReplicationInterface, cluster ClusterInterface) {
 	a.Storage = storage
+	a.Locks = locks
+	a.Channels = channels
+	a.Access = access
+	a.Replication = replication
+	a.Cluster = cluster
 }
 
-//API handler to get values
-func (a *API) Get(w http.ResponseWriter, r *http.Request) {
-	// Get Request Vars
+//authorize checks if the request may perform given operation on given realm
+//and raises an error, if it may not. Writes are never allowed on replicas.
+func (a *API) authorize(w http.ResponseWriter, r *http.Request, realm string, op Operation) bool {
+	if op == OperationWrite && a.Replication.IsReplica() {
+		RaiseError(w, "This instance is a read-only replica", http.StatusForbidden, ErrorCodeReadOnlyReplica)
+		return false
+	}
+
+	if !a.Access.Authorized(r, realm, op) {
+		RaiseError(w, fmt.Sprintf("No %v access to %v", op, realm), http.StatusForbidden, ErrorCodeForbidden)
+		return false
+	}
+
+	return true
+}
+
+//realmFromRequest returns the realm of the request path and raises an error,
+//if it is missing or invalid.
+func realmFromRequest(w http.ResponseWriter, r *http.Request) (string, bool) {
+	realm, ok := mux.Vars(r)["realm"]
+	if !ok {
+		RaiseError(w, "Realm is missing", http.StatusBadRequest, ErrorCodeRealmMissing)
+		return "", false
+	}
+
+	v := Validator{}
+	v.Realm("realm", realm)
+	if err := v.Err(); err != nil {
+		RaiseStorageError(w, err)
+		return "", false
+	}
+
+	return realm, true
+}
+
+//keyFromRequest returns realm and key of the request path and raises an
+//error, if they are missing or invalid.
+func keyFromRequest(w http.ResponseWriter, r *http.Request) (string, string, bool) {
 	vars := mux.Vars(r)
 	realm, ok := vars["realm"]
 	if !ok {
 		RaiseError(w, "Realm is missing", http.StatusBadRequest, ErrorCodeRealmMissing)
-		return
+		return "", "", false
 	}
 
 	key, ok := vars["key"]
 	if !ok {
 		RaiseError(w, "Key is missing", http.StatusBadRequest, ErrorCodeKeyMissing)
+		return "", "", false
+	}
+
+	v := Validator{}
+	v.Realm("realm", realm)
+	v.Key("key", key)
+	if err := v.Err(); err != nil {
+		RaiseStorageError(w, err)
+		return "", "", false
+	}
+
+	return realm, key, true
+}
+
+//hasMediaType checks if the given Content-Type or Accept header contains the
+//given media type.
+func hasMediaType(header string, mediaType string) bool {
+	for _, part := range strings.Split(header, ",") {
+		parsed, _, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err == nil && parsed == mediaType {
+			return true
+		}
+	}
+
+	return false
+}
+
+//isJSONRequest checks if the request body is a JSON message. Requests without
+//Content-Type or with the form Content-Type sent by default by curl and most
+//http clients are handled as JSON, as they were before raw values existed.
+func isJSONRequest(r *http.Request) bool {
+	contentType := r.Header.Get("Content-Type")
+	return len(contentType) == 0 || hasMediaType(contentType, "application/json") ||
+		hasMediaType(contentType, "application/x-www-form-urlencoded")
+}
+
+//writeRawValue writes the value as it is with its content type.
+func writeRawValue(w http.ResponseWriter, value *Value) {
+	w.Header().Set("Content-Type", value.ContentType)
+	w.Header().Set("Expires", value.ExpiresAt.Format(http.TimeFormat))
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(value.Value))
+}
+
+//API handler to get values
+func (a *API) Get(w http.ResponseWriter, r *http.Request) {
+	realm, key, ok := keyFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	if a.forward(w, r, realm, key) {
+		return
+	}
+
+	if !a.authorize(w, r, realm, OperationRead) {
 		return
 	}
 
@@ -80,6 +214,19 @@ func (a *API) Get(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Serve not modified, if the client already knows this version
+	w.Header().Set("ETag", value.ETag())
+	if matchETag(parseETags(r.Header.Get("If-None-Match")), value) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	// Serve raw values as they are, unless the JSON message is requested
+	if len(value.ContentType) > 0 && !hasMediaType(r.Header.Get("Accept"), "application/json") {
+		writeRawValue(w, value)
+		return
+	}
+
 	// Write Response
 	w.Header().Add("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
@@ -88,28 +235,121 @@ func (a *API) Get(w http.ResponseWriter, r *http.Request) {
 
 //API handler to set values
 func (a *API) Set(w http.ResponseWriter, r *http.Request) {
-	// Get Request Vars
-	vars := mux.Vars(r)
-	realm, ok := vars["realm"]
+	realm, key, ok := keyFromRequest(w, r)
 	if !ok {
-		RaiseError(w, "Realm is missing", http.StatusBadRequest, ErrorCodeRealmMissing)
 		return
 	}
 
-	key, ok := vars["key"]
+	if a.forward(w, r, realm, key) {
+		return
+	}
+
+	if !a.authorize(w, r, realm, OperationWrite) {
+		return
+	}
+
+	var value *Value
+	var err error
+	if isJSONRequest(r) {
+		value, err = ValueFromValueMessageType(r.Body)
+	} else {
+		_, config := a.Storage.GetRealmConfig(realm)
+		value, err = ValueFromRawBody(r, config.DefaultTTL)
+	}
+	if _, ok := err.(ValidationError); ok {
+		RaiseStorageError(w, err)
+		return
+	}
+	if err != nil {
+		RaiseError(w, "Invalid request body", http.StatusBadRequest, ErrorCodeInvalidRequestBody)
+		return
+	}
+
+	precondition := PreconditionFromRequest(r)
+	if precondition.IsEmpty() {
+		err = a.Storage.Set(realm, key, value)
+	} else {
+		ok, _, err = a.Storage.SetIf(realm, key, value, precondition)
+		if err == nil && !ok {
+			RaiseError(w, fmt.Sprintf("Precondition failed for key %v/%v", realm, key), http.StatusPreconditionFailed, ErrorCodePreconditionFailed)
+			return
+		}
+	}
+
+	if err != nil {
+		RaiseStorageError(w, err)
+		return
+	}
+
+	w.Header().Set("ETag", value.ETag())
+
+	// Raw values are not sent back, the version is available in the ETag
+	if !isJSONRequest(r) {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(value.ToValueMessageType())
+}
+
+//API handler to atomically replace values, if they still have the expected
+//version
+func (a *API) CompareAndSwap(w http.ResponseWriter, r *http.Request) {
+	realm, key, ok := keyFromRequest(w, r)
 	if !ok {
-		RaiseError(w, "Key is missing", http.StatusBadRequest, ErrorCodeKeyMissing)
 		return
 	}
 
-	value, err := ValueFromValueMessageType(r.Body)
+	if a.forward(w, r, realm, key) {
+		return
+	}
+
+	if !a.authorize(w, r, realm, OperationWrite) {
+		return
+	}
+
+	msg := CompareAndSwapMessageType{}
+	err := json.NewDecoder(r.Body).Decode(&msg)
+	if err != nil {
+		RaiseError(w, "Invalid request body", http.StatusBadRequest, ErrorCodeInvalidRequestBody)
+		return
+	}
+
+	v := Validator{}
+	v.ExpiresIn("expires-in", msg.ExpiresIn)
+	if err := v.Err(); err != nil {
+		RaiseStorageError(w, err)
+		return
+	}
+
+	decoded, err := decodeValue(msg.Value, msg.Encoding)
 	if err != nil {
 		RaiseError(w, "Invalid request body", http.StatusBadRequest, ErrorCodeInvalidRequestBody)
 		return
 	}
 
-	a.Storage.Set(realm, key, value)
+	value := NewValue(decoded, msg.ExpiresIn)
+	value.ContentType = msg.ContentType
+
+	ok, current, err := a.Storage.SetIf(realm, key, value, PreconditionFromVersion(msg.Version))
+	if err != nil {
+		RaiseStorageError(w, err)
+		return
+	}
+
+	if !ok {
+		currentVersion := uint64(0)
+		if current != nil {
+			currentVersion = current.Version
+		}
+
+		RaiseError(w, fmt.Sprintf("Version mismatch for key %v/%v. Expected %v, found %v", realm, key, msg.Version, currentVersion), http.StatusPreconditionFailed, ErrorCodePreconditionFailed)
+		return
+	}
 
+	w.Header().Set("ETag", value.ETag())
 	w.Header().Add("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(value.ToValueMessageType())
@@ -117,17 +357,16 @@ func (a *API) Set(w http.ResponseWriter, r *http.Request) {
 
 //API handler to delete values
 func (a *API) Delete(w http.ResponseWriter, r *http.Request) {
-	// Get Request Vars
-	vars := mux.Vars(r)
-	realm, ok := vars["realm"]
+	realm, key, ok := keyFromRequest(w, r)
 	if !ok {
-		RaiseError(w, "Realm is missing", http.StatusBadRequest, ErrorCodeRealmMissing)
 		return
 	}
 
-	key, ok := vars["key"]
-	if !ok {
-		RaiseError(w, "Key is missing", http.StatusBadRequest, ErrorCodeKeyMissing)
+	if a.forward(w, r, realm, key) {
+		return
+	}
+
+	if !a.authorize(w, r, realm, OperationWrite) {
 		return
 	}
 
@@ -143,11 +382,12 @@ func (a *API) Delete(w http.ResponseWriter, r *http.Request) {
 
 //API handler to get keys of a realm
 func (a *API) Keys(w http.ResponseWriter, r *http.Request) {
-	// Get Request Vars
-	vars := mux.Vars(r)
-	realm, ok := vars["realm"]
+	realm, ok := realmFromRequest(w, r)
 	if !ok {
-		RaiseError(w, "Realm is missing", http.StatusBadRequest, ErrorCodeRealmMissing)
+		return
+	}
+
+	if !a.authorize(w, r, realm, OperationRead) {
 		return
 	}
 
@@ -163,6 +403,10 @@ func (a *API) Keys(w http.ResponseWriter, r *http.Request) {
 
 //API handler to get realms
 func (a *API) Realms(w http.ResponseWriter, r *http.Request) {
+	if !a.authorize(w, r, AllRealms, OperationRead) {
+		return
+	}
+
 	realms := a.Storage.Realms()
 	keysMessage := RealmListMessageType{
 		Realms: realms,
@@ -172,3 +416,14 @@ func (a *API) Realms(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(keysMessage)
 }
+
+//API handler to get the memory usage and eviction counters
+func (a *API) Memory(w http.ResponseWriter, r *http.Request) {
+	if !a.authorize(w, r, AllRealms, OperationRead) {
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(a.Storage.Memory())
+}
@@ -0,0 +1,275 @@
+/*
+api_batch.go
+Implements all api methods to get, set and delete multiple values with a
+single request and to apply transactions.
+
+###################################################################################
+
+MIT License
+
+Copyright (c) 2020 Bruno Hautzenberger
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+)
+
+//validateEntry checks realm, key and expiration of a batch entry or
+//transaction operation. Field is the name of the list of entries.
+func validateEntry(v *Validator, field string, index int, realm string, key string, expiresIn int) {
+	field = fmt.Sprintf("%v[%v]", field, index)
+	v.Realm(field+".realm", realm)
+	v.Key(field+".key", key)
+	v.ExpiresIn(field+".expires-in", expiresIn)
+}
+
+//batchFromRequest reads and validates the BatchMessageType of the request
+//body and checks, that the request may perform given operation on all realms
+//of its entries.
+func (a *API) batchFromRequest(w http.ResponseWriter, r *http.Request, op Operation) (*BatchMessageType, bool) {
+	msg := &BatchMessageType{}
+	body, err := ioutil.ReadAll(r.Body)
+	if err == nil {
+		err = json.Unmarshal(body, msg)
+	}
+	if err != nil {
+		RaiseError(w, "Invalid request body", http.StatusBadRequest, ErrorCodeInvalidRequestBody)
+		return nil, false
+	}
+
+	v := Validator{}
+	for i, entry := range msg.Entries {
+		validateEntry(&v, "entries", i, entry.Realm, entry.Key, entry.ExpiresIn)
+	}
+	if err := v.Err(); err != nil {
+		RaiseStorageError(w, err)
+		return nil, false
+	}
+
+	if a.forwardAll(w, r, body, msg.Entries) {
+		return nil, false
+	}
+
+	for _, entry := range msg.Entries {
+		if !a.authorize(w, r, entry.Realm, op) {
+			return nil, false
+		}
+	}
+
+	return msg, true
+}
+
+//batchResult creates the BatchResultMessageType of a single entry.
+func batchResult(realm string, key string, found bool, value *Value) BatchResultMessageType {
+	result := BatchResultMessageType{
+		Realm: realm,
+		Key:   key,
+		Found: found,
+	}
+
+	if found && value != nil {
+		msg := value.ToValueMessageType()
+		result.Value = &msg
+	}
+
+	return result
+}
+
+//writeBatchResults writes the given results as BatchResultListMessageType
+//to the response.
+func writeBatchResults(w http.ResponseWriter, results []BatchResultMessageType) {
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(BatchResultListMessageType{
+		Results: results,
+	})
+}
+
+//API handler to get multiple values
+func (a *API) MGet(w http.ResponseWriter, r *http.Request) {
+	msg, ok := a.batchFromRequest(w, r, OperationRead)
+	if !ok {
+		return
+	}
+
+	values := a.Storage.MGet(msg.Entries)
+	results := make([]BatchResultMessageType, 0, len(msg.Entries))
+	for i, entry := range msg.Entries {
+		results = append(results, batchResult(entry.Realm, entry.Key, values[i] != nil, values[i]))
+	}
+
+	writeBatchResults(w, results)
+}
+
+//API handler to atomically set multiple values
+func (a *API) MSet(w http.ResponseWriter, r *http.Request) {
+	msg, ok := a.batchFromRequest(w, r, OperationWrite)
+	if !ok {
+		return
+	}
+
+	values := make([]*Value, 0, len(msg.Entries))
+	err := a.Storage.Transaction(func(tx *Transaction) error {
+		for _, entry := range msg.Entries {
+			value := NewValue(entry.Value, entry.ExpiresIn)
+			if err := tx.Set(entry.Realm, entry.Key, value); err != nil {
+				return err
+			}
+			values = append(values, value)
+		}
+		return nil
+	})
+	if err != nil {
+		RaiseStorageError(w, err)
+		return
+	}
+
+	results := make([]BatchResultMessageType, 0, len(msg.Entries))
+	for i, entry := range msg.Entries {
+		results = append(results, batchResult(entry.Realm, entry.Key, true, values[i]))
+	}
+
+	writeBatchResults(w, results)
+}
+
+//API handler to atomically delete multiple values
+func (a *API) MDelete(w http.ResponseWriter, r *http.Request) {
+	msg, ok := a.batchFromRequest(w, r, OperationWrite)
+	if !ok {
+		return
+	}
+
+	results := make([]BatchResultMessageType, 0, len(msg.Entries))
+	err := a.Storage.Transaction(func(tx *Transaction) error {
+		for _, entry := range msg.Entries {
+			found := tx.Delete(entry.Realm, entry.Key)
+			results = append(results, batchResult(entry.Realm, entry.Key, found, nil))
+		}
+		return nil
+	})
+	if err != nil {
+		RaiseStorageError(w, err)
+		return
+	}
+
+	writeBatchResults(w, results)
+}
+
+//operationAccess returns the type of access a transaction operation needs.
+func operationAccess(operation string) Operation {
+	if operation == "get" || operation == "check" {
+		return OperationRead
+	}
+
+	return OperationWrite
+}
+
+//applyOperation applies a single operation of a transaction. It returns
+//whether the value was found and the value, that was read or written.
+func applyOperation(tx *Transaction, index int, op TransactionOperationMessageType) (bool, *Value, error) {
+	found, current := tx.Get(op.Realm, op.Key)
+
+	if op.Version != nil && !PreconditionFromVersion(*op.Version).Check(current) {
+		return false, nil, ErrorMessage{
+			fmt.Sprintf("Operation %v failed. Version of %v/%v is not %v", index, op.Realm, op.Key, *op.Version),
+			http.StatusPreconditionFailed,
+			ErrorCodePreconditionFailed,
+		}
+	}
+
+	switch op.Operation {
+	case "get", "check":
+		return found, current, nil
+	case "set":
+		value := NewValue(op.Value, op.ExpiresIn)
+		if err := tx.Set(op.Realm, op.Key, value); err != nil {
+			return false, nil, err
+		}
+		return true, value, nil
+	case "delete":
+		return tx.Delete(op.Realm, op.Key), nil, nil
+	}
+
+	return false, nil, ErrorMessage{fmt.Sprintf("Unknown operation %v of entry %v", op.Operation, index), http.StatusBadRequest, ErrorCodeInvalidOperation}
+}
+
+//API handler to apply a list of operations all-or-nothing
+func (a *API) Transaction(w http.ResponseWriter, r *http.Request) {
+	msg := &TransactionMessageType{}
+	body, err := ioutil.ReadAll(r.Body)
+	if err == nil {
+		err = json.Unmarshal(body, msg)
+	}
+	if err != nil {
+		RaiseError(w, "Invalid request body", http.StatusBadRequest, ErrorCodeInvalidRequestBody)
+		return
+	}
+
+	entries := make([]BatchEntryMessageType, 0, len(msg.Operations))
+	v := Validator{}
+	for i, op := range msg.Operations {
+		validateEntry(&v, "operations", i, op.Realm, op.Key, op.ExpiresIn)
+		entries = append(entries, BatchEntryMessageType{Realm: op.Realm, Key: op.Key})
+	}
+	if err := v.Err(); err != nil {
+		RaiseStorageError(w, err)
+		return
+	}
+
+	if a.forwardAll(w, r, body, entries) {
+		return
+	}
+
+	for _, op := range msg.Operations {
+		if !a.authorize(w, r, op.Realm, operationAccess(op.Operation)) {
+			return
+		}
+	}
+
+	// results are built after the commit, so written values have their versions
+	found := make([]bool, len(msg.Operations))
+	values := make([]*Value, len(msg.Operations))
+	err = a.Storage.Transaction(func(tx *Transaction) error {
+		for i, op := range msg.Operations {
+			var err error
+			found[i], values[i], err = applyOperation(tx, i, op)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		RaiseStorageError(w, err)
+		return
+	}
+
+	results := make([]BatchResultMessageType, 0, len(msg.Operations))
+	for i, op := range msg.Operations {
+		results = append(results, batchResult(op.Realm, op.Key, found[i], values[i]))
+	}
+
+	writeBatchResults(w, results)
+}
@@ -0,0 +1,151 @@
+/*
+api_cluster.go
+Implements all api methods of the cluster mode and the forwarding of
+requests to the nodes owning their keys.
+
+###################################################################################
+
+MIT License
+
+Copyright (c) 2020 Bruno Hautzenberger
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+)
+
+//forwarded checks if the request was forwarded by another node.
+func forwarded(r *http.Request) bool {
+	return len(r.Header.Get(ClusterForwardedHeader)) > 0
+}
+
+//forward forwards the request to the node owning given realm and key. It
+//returns false, if the request has to be served by this node.
+func (a *API) forward(w http.ResponseWriter, r *http.Request, realm string, key string) bool {
+	if forwarded(r) || a.Cluster.IsLocal(realm, key) {
+		return false
+	}
+
+	a.Cluster.Forward(w, r, a.Cluster.Owner(realm, key))
+	return true
+}
+
+//forwardAll forwards a request with given body, which reads or writes all of
+//given entries, to the node owning them. Entries owned by different nodes
+//can't be served atomically and are rejected. It returns false, if the
+//request has to be served by this node.
+func (a *API) forwardAll(w http.ResponseWriter, r *http.Request, body []byte, entries []BatchEntryMessageType) bool {
+	if forwarded(r) || !a.Cluster.Enabled() || len(entries) == 0 {
+		return false
+	}
+
+	owner := a.Cluster.Owner(entries[0].Realm, entries[0].Key)
+	for _, entry := range entries[1:] {
+		if a.Cluster.Owner(entry.Realm, entry.Key) != owner {
+			RaiseError(w, "Keys are owned by different nodes", http.StatusBadRequest, ErrorCodeCrossNode)
+			return true
+		}
+	}
+
+	if a.Cluster.IsLocal(entries[0].Realm, entries[0].Key) {
+		return false
+	}
+
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	r.ContentLength = int64(len(body))
+	a.Cluster.Forward(w, r, owner)
+
+	return true
+}
+
+//uniqueNodes removes duplicates from a list of nodes.
+func uniqueNodes(nodes []string) []string {
+	seen := make(map[string]bool, len(nodes))
+	unique := make([]string, 0, len(nodes))
+	for _, node := range nodes {
+		if !seen[node] {
+			seen[node] = true
+			unique = append(unique, node)
+		}
+	}
+
+	return unique
+}
+
+//broadcast sends a change, which applies to all nodes, to all other nodes,
+//unless the request was forwarded by another node.
+func (a *API) broadcast(r *http.Request, method string, path string, body []byte) {
+	if forwarded(r) || !a.Cluster.Enabled() {
+		return
+	}
+
+	a.Cluster.Send(r, a.Cluster.Nodes(), method, path, body)
+}
+
+//API handler to get the cluster status
+func (a *API) ClusterStatus(w http.ResponseWriter, r *http.Request) {
+	if !a.authorize(w, r, AllRealms, OperationRead) {
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(a.Cluster.Status())
+}
+
+//API handler to change the members of the cluster
+func (a *API) SetClusterNodes(w http.ResponseWriter, r *http.Request) {
+	if !a.authorize(w, r, AllRealms, OperationWrite) {
+		return
+	}
+
+	if !a.Cluster.Enabled() {
+		RaiseError(w, "Cluster mode is disabled", http.StatusConflict, ErrorCodeClusterDisabled)
+		return
+	}
+
+	msg := ClusterNodesMessageType{}
+	err := json.NewDecoder(r.Body).Decode(&msg)
+	if err != nil {
+		RaiseError(w, "Invalid request body", http.StatusBadRequest, ErrorCodeInvalidRequestBody)
+		return
+	}
+
+	// old and new members are notified, so removed nodes migrate their keys
+	nodes := append(a.Cluster.Nodes(), msg.Nodes...)
+	if err := a.Cluster.SetNodes(msg.Nodes); err != nil {
+		RaiseError(w, err.Error(), http.StatusBadRequest, ErrorCodeInvalidRequestBody)
+		return
+	}
+
+	if !forwarded(r) {
+		body, _ := json.Marshal(msg)
+		a.Cluster.Send(r, uniqueNodes(nodes), http.MethodPut, "/v1/cluster", body)
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(a.Cluster.Status())
+}
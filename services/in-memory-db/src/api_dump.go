@@ -0,0 +1,173 @@
+/*
+api_dump.go
+Implements all api methods to export and import dumps.
+
+###################################################################################
+
+MIT License
+
+Copyright (c) 2020 Bruno Hautzenberger
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+)
+
+//dumpFormatFromRequest reads the dump format from the format query parameter.
+//It defaults to NDJSON.
+func dumpFormatFromRequest(r *http.Request) DumpFormat {
+	format := DumpFormat(r.URL.Query().Get("format"))
+	if len(format) == 0 {
+		return DumpFormatNDJSON
+	}
+
+	return format
+}
+
+//dumpRealm returns the realm given as query parameter and the realm, that has
+//to be authorized for it.
+func dumpRealm(r *http.Request) (string, string) {
+	realm := r.URL.Query().Get("realm")
+	if len(realm) == 0 {
+		return "", AllRealms
+	}
+
+	return realm, realm
+}
+
+//API handler to export realms as dump
+func (a *API) Export(w http.ResponseWriter, r *http.Request) {
+	realm, authorized := dumpRealm(r)
+	if !a.authorize(w, r, authorized, OperationRead) {
+		return
+	}
+
+	format := dumpFormatFromRequest(r)
+	encoder, err := format.NewDumpEncoder(w)
+	if err != nil {
+		RaiseError(w, err.Error(), http.StatusBadRequest, ErrorCodeInvalidDumpFormat)
+		return
+	}
+
+	w.Header().Add("Content-Type", format.ContentType())
+	w.WriteHeader(http.StatusOK)
+
+	for _, event := range a.Storage.Export(realm) {
+		if err := encoder.Encode(event.ToReplicationMessageType()); err != nil {
+			return
+		}
+	}
+}
+
+//API handler to import dumps
+func (a *API) Import(w http.ResponseWriter, r *http.Request) {
+	realm, authorized := dumpRealm(r)
+	if !a.authorize(w, r, authorized, OperationWrite) {
+		return
+	}
+
+	mode := ImportMode(r.URL.Query().Get("mode"))
+	if len(mode) == 0 {
+		mode = ImportModeMerge
+	}
+	if !mode.IsValid() {
+		RaiseError(w, "Mode must be merge, replace or missing", http.StatusBadRequest, ErrorCodeInvalidImportMode)
+		return
+	}
+
+	decoder, err := dumpFormatFromRequest(r).NewDumpDecoder(r.Body)
+	if err != nil {
+		RaiseError(w, err.Error(), http.StatusBadRequest, ErrorCodeInvalidDumpFormat)
+		return
+	}
+
+	// the whole dump is read first, so invalid dumps change nothing
+	result := ImportResultMessageType{}
+	dump := make([]*Event, 0)
+	for {
+		msg := ReplicationMessageType{}
+		err := decoder.Decode(&msg)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			RaiseError(w, "Invalid request body", http.StatusBadRequest, ErrorCodeInvalidRequestBody)
+			return
+		}
+
+		event, err := EventFromReplicationMessageType(msg)
+		if err != nil {
+			RaiseError(w, "Invalid request body", http.StatusBadRequest, ErrorCodeInvalidRequestBody)
+			return
+		}
+
+		// realms, keys and lock names are validated like in all other writes
+		if event.Type == EventTypeConfigure || event.Type == EventTypeSet || event.Type == EventTypeLock {
+			v := Validator{}
+			if event.Type == EventTypeLock {
+				v.Realm("key", event.Key)
+			} else {
+				v.Realm("realm", event.Realm)
+			}
+			if event.Type == EventTypeSet {
+				v.Key("key", event.Key)
+			}
+			if err := v.Err(); err != nil {
+				RaiseStorageError(w, err)
+				return
+			}
+		}
+
+		switch {
+		case len(realm) > 0 && event.Realm != realm:
+			result.Skipped++
+		case event.Type == EventTypeConfigure && event.Config != nil:
+			if err := event.Config.Validate(); err != nil {
+				RaiseStorageError(w, err)
+				return
+			}
+			dump = append(dump, event)
+			result.Realms++
+		case event.Type == EventTypeSet && msg.ExpiresInMs > 0:
+			dump = append(dump, event)
+			result.Values++
+		case event.Type == EventTypeLock && msg.ExpiresInMs > 0:
+			dump = append(dump, event)
+			result.Locks++
+		case event.Type == EventTypeToken:
+			dump = append(dump, event)
+		default:
+			result.Skipped++
+		}
+	}
+
+	if err := a.Storage.Import(dump, realm, mode); err != nil {
+		RaiseStorageError(w, err)
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(result)
+}
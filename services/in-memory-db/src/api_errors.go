@@ -38,11 +38,45 @@ import (
 type ErrorCode int
 
 const (
-	ErrorCodeInternal           ErrorCode = 0
-	ErrorCodeRealmMissing                 = 1
-	ErrorCodeKeyMissing                   = 2
-	ErrorCodeEntityNotFound               = 3
-	ErrorCodeInvalidRequestBody           = 4
+	ErrorCodeInternal             ErrorCode = 0
+	ErrorCodeRealmMissing                   = 1
+	ErrorCodeKeyMissing                     = 2
+	ErrorCodeEntityNotFound                 = 3
+	ErrorCodeInvalidRequestBody             = 4
+	ErrorCodePreconditionFailed             = 5
+	ErrorCodeLockNameMissing                = 6
+	ErrorCodeInvalidLease                   = 7
+	ErrorCodeLockHeld                       = 8
+	ErrorCodeLockNotHeld                    = 9
+	ErrorCodeInvalidPattern                 = 10
+	ErrorCodeStreamingUnsupported           = 11
+	ErrorCodeChannelMissing                 = 12
+	ErrorCodeOutOfMemory                    = 13
+	ErrorCodeInvalidOperation               = 14
+	ErrorCodeInvalidScanParameter           = 15
+	ErrorCodeUnauthorized                   = 16
+	ErrorCodeForbidden                      = 17
+	ErrorCodeAuthUnavailable                = 18
+	ErrorCodeValueTooLarge                  = 19
+	ErrorCodeTTLTooLong                     = 20
+	ErrorCodeRealmKeyLimitReached           = 21
+	ErrorCodeInvalidRealmConfig             = 22
+	ErrorCodeReadOnlyReplica                = 23
+	ErrorCodeNotAReplica                    = 24
+	ErrorCodeInvalidRateLimit               = 25
+	ErrorCodeNoRateLimitState               = 26
+	ErrorCodeInvalidDumpFormat              = 27
+	ErrorCodeInvalidImportMode              = 28
+	ErrorCodeFieldNotIndexed                = 29
+	ErrorCodeInvalidQuery                   = 30
+	ErrorCodeNodeUnavailable                = 31
+	ErrorCodeCrossNode                      = 32
+	ErrorCodeClusterDisabled                = 33
+	ErrorCodeInvalidScript                  = 34
+	ErrorCodeScriptError                    = 35
+	ErrorCodeScriptLimit                    = 36
+	ErrorCodeScriptFailed                   = 37
+	ErrorCodeValidationFailed               = 38
 )
 
 // ErrorMessage holds all information of a certain error
@@ -52,6 +86,12 @@ type ErrorMessage struct {
 	Code       ErrorCode `json:"code"`
 }
 
+// Error returns the message of this error, so an ErrorMessage can be returned
+// as error
+func (e ErrorMessage) Error() string {
+	return e.Message
+}
+
 // RaiseError logs and returns a given error via on the current http request
 func RaiseError(w http.ResponseWriter, message string, statusCode int, code ErrorCode) {
 	errorMessage := ErrorMessage{
@@ -70,3 +110,58 @@ func RaiseError(w http.ResponseWriter, message string, statusCode int, code Erro
 	w.WriteHeader(statusCode)
 	json.NewEncoder(w).Encode(response)
 }
+
+// RaiseValidationError logs and returns all invalid fields of a request
+func RaiseValidationError(w http.ResponseWriter, err ValidationError) {
+	log.Printf("Error: %v. HTTP Status: %v Code: %v", err.Message, err.StatusCode, err.Code)
+
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(err.StatusCode)
+	json.NewEncoder(w).Encode(ErrorMessageType{
+		Error: err,
+	})
+}
+
+// RaiseStorageError returns errors of the storage with a matching http status
+// and error code. ErrorMessages and ValidationErrors are returned as they are.
+func RaiseStorageError(w http.ResponseWriter, err error) {
+	if validationError, ok := err.(ValidationError); ok {
+		RaiseValidationError(w, validationError)
+		return
+	}
+
+	errorMessage := StorageErrorMessage(err)
+	RaiseError(w, errorMessage.Message, errorMessage.StatusCode, errorMessage.Code)
+}
+
+// StorageErrorMessage returns the ErrorMessage with matching http status and
+// error code of an error of the storage.
+func StorageErrorMessage(err error) ErrorMessage {
+	switch e := err.(type) {
+	case ErrorMessage:
+		return e
+	case ValidationError:
+		return e.ErrorMessage
+	}
+
+	switch err {
+	case ErrOutOfMemory:
+		return ErrorMessage{err.Error(), http.StatusInsufficientStorage, ErrorCodeOutOfMemory}
+	case ErrValueTooLarge:
+		return ErrorMessage{err.Error(), http.StatusRequestEntityTooLarge, ErrorCodeValueTooLarge}
+	case ErrTTLTooLong:
+		return ErrorMessage{err.Error(), http.StatusBadRequest, ErrorCodeTTLTooLong}
+	case ErrRealmKeyLimit:
+		return ErrorMessage{err.Error(), http.StatusInsufficientStorage, ErrorCodeRealmKeyLimitReached}
+	case ErrInvalidRealmConfig:
+		return ErrorMessage{err.Error(), http.StatusBadRequest, ErrorCodeInvalidRealmConfig}
+	case ErrFieldNotIndexed:
+		return ErrorMessage{err.Error(), http.StatusBadRequest, ErrorCodeFieldNotIndexed}
+	case ErrInvalidRateLimit:
+		return ErrorMessage{err.Error(), http.StatusBadRequest, ErrorCodeInvalidRateLimit}
+	case ErrNoRateLimitState:
+		return ErrorMessage{err.Error(), http.StatusConflict, ErrorCodeNoRateLimitState}
+	}
+
+	return ErrorMessage{err.Error(), http.StatusInternalServerError, ErrorCodeInternal}
+}
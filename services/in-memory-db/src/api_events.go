@@ -0,0 +1,173 @@
+/*
+api_events.go
+Implements all api methods to stream keyspace notifications and to publish
+and subscribe to channels. Events are streamed using Server-Sent Events.
+
+###################################################################################
+
+MIT License
+
+Copyright (c) 2020 Bruno Hautzenberger
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+//keepAliveInterval is the interval in which comments are sent to idle event
+//streams, so proxies do not close the connection.
+const keepAliveInterval = 15 * time.Second
+
+//serveEvents streams all events of given subscription as Server-Sent Events,
+//until the client closes the connection or does not keep up.
+func serveEvents(w http.ResponseWriter, r *http.Request, subscription *Subscription) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		RaiseError(w, "Streaming is not supported", http.StatusInternalServerError, ErrorCodeStreamingUnsupported)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(keepAliveInterval)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-subscription.Overflow:
+			return
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+		case event := <-subscription.Events:
+			// locks and counters are only replicated
+			if event.isReplicationOnly() {
+				continue
+			}
+
+			data, err := json.Marshal(event.ToEventMessageType())
+			if err != nil {
+				continue
+			}
+			fmt.Fprintf(w, "event: %v\ndata: %s\n\n", event.Type, data)
+		}
+
+		flusher.Flush()
+	}
+}
+
+//API handler to stream keyspace notifications of all keys matching the realm
+//and key patterns given as query params
+func (a *API) Watch(w http.ResponseWriter, r *http.Request) {
+	realmPattern := r.FormValue("realm")
+	if len(realmPattern) == 0 {
+		realmPattern = "*"
+	}
+
+	keyPattern := r.FormValue("key")
+	if len(keyPattern) == 0 {
+		keyPattern = "*"
+	}
+
+	pattern := realmPattern + "/" + keyPattern
+	if err := ValidatePattern(pattern); err != nil {
+		RaiseError(w, fmt.Sprintf("Invalid pattern %v", pattern), http.StatusBadRequest, ErrorCodeInvalidPattern)
+		return
+	}
+
+	if !a.authorize(w, r, realmPattern, OperationRead) {
+		return
+	}
+
+	subscription := a.Storage.Subscribe(pattern)
+	defer a.Storage.Unsubscribe(subscription)
+
+	serveEvents(w, r, subscription)
+}
+
+//API handler to publish messages to a channel
+func (a *API) Publish(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	channel, ok := vars["channel"]
+	if !ok {
+		RaiseError(w, "Channel is missing", http.StatusBadRequest, ErrorCodeChannelMissing)
+		return
+	}
+
+	if !a.authorize(w, r, channelResource(channel), OperationWrite) {
+		return
+	}
+
+	msg := PublishMessageType{}
+	err := json.NewDecoder(r.Body).Decode(&msg)
+	if err != nil {
+		RaiseError(w, "Invalid request body", http.StatusBadRequest, ErrorCodeInvalidRequestBody)
+		return
+	}
+
+	receivers := a.Channels.Publish(&Event{
+		Type:    EventTypeMessage,
+		Topic:   channel,
+		Message: msg.Message,
+	})
+
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(PublishResultMessageType{
+		Receivers: receivers,
+	})
+}
+
+//API handler to stream all messages published to channels matching the
+//given channel pattern
+func (a *API) Subscribe(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	channel, ok := vars["channel"]
+	if !ok {
+		RaiseError(w, "Channel is missing", http.StatusBadRequest, ErrorCodeChannelMissing)
+		return
+	}
+
+	if err := ValidatePattern(channel); err != nil {
+		RaiseError(w, fmt.Sprintf("Invalid pattern %v", channel), http.StatusBadRequest, ErrorCodeInvalidPattern)
+		return
+	}
+
+	if !a.authorize(w, r, channelResource(channel), OperationRead) {
+		return
+	}
+
+	subscription := a.Channels.Subscribe(channel)
+	defer a.Channels.Unsubscribe(subscription)
+
+	serveEvents(w, r, subscription)
+}
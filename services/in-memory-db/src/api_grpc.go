@@ -0,0 +1,409 @@
+/*
+api_grpc.go
+Implements the gRPC api defined in pb/inmemorydb.proto. It uses the same
+storage, access control, replication and cluster as the RESTful API.
+
+###################################################################################
+
+MIT License
+
+Copyright (c) 2020 Bruno Hautzenberger
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"in-memory-db/src/pb"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+//grpcCodes maps the http status codes of errors to gRPC codes.
+var grpcCodes = map[int]codes.Code{
+	http.StatusBadRequest:            codes.InvalidArgument,
+	http.StatusUnauthorized:          codes.Unauthenticated,
+	http.StatusForbidden:             codes.PermissionDenied,
+	http.StatusNotFound:              codes.NotFound,
+	http.StatusConflict:              codes.Aborted,
+	http.StatusPreconditionFailed:    codes.FailedPrecondition,
+	http.StatusRequestEntityTooLarge: codes.InvalidArgument,
+	http.StatusUnprocessableEntity:   codes.InvalidArgument,
+	http.StatusTooManyRequests:       codes.ResourceExhausted,
+	http.StatusBadGateway:            codes.Unavailable,
+	http.StatusServiceUnavailable:    codes.Unavailable,
+	http.StatusInsufficientStorage:   codes.ResourceExhausted,
+}
+
+//grpcError converts errors of the storage to gRPC errors with a matching
+//code.
+func grpcError(err error) error {
+	errorMessage := StorageErrorMessage(err)
+
+	code, ok := grpcCodes[errorMessage.StatusCode]
+	if !ok {
+		code = codes.Internal
+	}
+
+	return status.Error(code, errorMessage.Message)
+}
+
+//GRPCServer serves the gRPC api.
+type GRPCServer struct {
+	pb.UnimplementedInMemoryDBServer
+
+	Storage     StorageInterface
+	Access      AccessInterface
+	Replication ReplicationInterface
+	Cluster     ClusterInterface
+	server      *grpc.Server
+}
+
+//Initialize sets the storage, access control, replication and cluster used by
+//the gRPC api.
+func (s *GRPCServer) Initialize(storage StorageInterface, access AccessInterface, replication ReplicationInterface, cluster ClusterInterface) {
+	s.Storage = storage
+	s.Access = access
+	s.Replication = replication
+	s.Cluster = cluster
+
+	s.server = grpc.NewServer(
+		grpc.UnaryInterceptor(s.authenticateUnary),
+		grpc.StreamInterceptor(s.authenticateStream),
+	)
+	pb.RegisterInMemoryDBServer(s.server, s)
+}
+
+//Serve serves the gRPC api on given port until it fails or is shut down.
+func (s *GRPCServer) Serve(port string) error {
+	listener, err := net.Listen("tcp", fmt.Sprintf(":%v", port))
+	if err != nil {
+		return err
+	}
+
+	return s.server.Serve(listener)
+}
+
+//Shutdown stops accepting calls and waits for running calls to finish. Calls
+//still running, when ctx is done, are cancelled.
+func (s *GRPCServer) Shutdown(ctx context.Context) {
+	stopped := make(chan struct{})
+	go func() {
+		s.server.GracefulStop()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		s.server.Stop()
+	}
+}
+
+//authenticate authenticates a call using the bearer token of its
+//authorization metadata, like the RESTful API does with the Authorization
+//header.
+func (s *GRPCServer) authenticate(ctx context.Context) (context.Context, error) {
+	authorization := ""
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get("authorization"); len(values) > 0 {
+			authorization = values[0]
+		}
+	}
+
+	ctx, err := s.Access.Authenticate(ctx, authorization)
+	switch err {
+	case nil:
+		return ctx, nil
+	case ErrUnauthorized:
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+	return nil, status.Error(codes.Unavailable, err.Error())
+}
+
+func (s *GRPCServer) authenticateUnary(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	ctx, err := s.authenticate(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return handler(ctx, req)
+}
+
+//authenticatedStream is a stream with the context returned by authenticate.
+type authenticatedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *authenticatedStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *GRPCServer) authenticateStream(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx, err := s.authenticate(stream.Context())
+	if err != nil {
+		return err
+	}
+
+	return handler(srv, &authenticatedStream{stream, ctx})
+}
+
+//authorize checks if the call may perform given operation on given realm.
+//Writes are never allowed on replicas.
+func (s *GRPCServer) authorize(ctx context.Context, realm string, op Operation) error {
+	if op == OperationWrite && s.Replication.IsReplica() {
+		return status.Error(codes.PermissionDenied, "This instance is a read-only replica")
+	}
+
+	if !s.Access.AuthorizedContext(ctx, realm, op) {
+		return status.Error(codes.PermissionDenied, fmt.Sprintf("No %v access to %v", op, realm))
+	}
+
+	return nil
+}
+
+//key validates realm and key and checks if this node owns them. Calls are
+//not forwarded in cluster mode, clients have to call the owner instead.
+func (s *GRPCServer) key(ctx context.Context, realm string, key string, op Operation) error {
+	v := Validator{}
+	v.Realm("realm", realm)
+	v.Key("key", key)
+	if err := v.Err(); err != nil {
+		return grpcError(err)
+	}
+
+	if !s.Cluster.IsLocal(realm, key) {
+		return status.Error(codes.FailedPrecondition, fmt.Sprintf("Key %v/%v is owned by node %v", realm, key, s.Cluster.Owner(realm, key)))
+	}
+
+	return s.authorize(ctx, realm, op)
+}
+
+//expiresIn returns the remaining seconds until a Value expires.
+func expiresIn(value *Value) int64 {
+	return int64(value.ExpiresAt.Sub(time.Now().UTC()).Seconds())
+}
+
+//toPBValue transforms a Value to its gRPC message.
+func toPBValue(value *Value) *pb.Value {
+	return &pb.Value{
+		Value:       []byte(value.Value),
+		ExpiresIn:   expiresIn(value),
+		Version:     value.Version,
+		ContentType: value.ContentType,
+	}
+}
+
+//notFound returns the error of keys without value.
+func notFound(realm string, key string) error {
+	return status.Error(codes.NotFound, fmt.Sprintf("No value found for key %v/%v", realm, key))
+}
+
+//Get returns the value of a key.
+func (s *GRPCServer) Get(ctx context.Context, req *pb.GetRequest) (*pb.Value, error) {
+	if err := s.key(ctx, req.Realm, req.Key, OperationRead); err != nil {
+		return nil, err
+	}
+
+	ok, value := s.Storage.Get(req.Realm, req.Key)
+	if !ok {
+		return nil, notFound(req.Realm, req.Key)
+	}
+
+	return toPBValue(value), nil
+}
+
+//Set stores a value, optionally only if the stored value has the given
+//version.
+func (s *GRPCServer) Set(ctx context.Context, req *pb.SetRequest) (*pb.Value, error) {
+	if err := s.key(ctx, req.Realm, req.Key, OperationWrite); err != nil {
+		return nil, err
+	}
+
+	v := Validator{}
+	v.ExpiresIn("expires_in", int(req.ExpiresIn))
+	if err := v.Err(); err != nil {
+		return nil, grpcError(err)
+	}
+
+	value := NewValue(string(req.Value), int(req.ExpiresIn))
+	value.ContentType = req.ContentType
+
+	if req.Version == nil {
+		if err := s.Storage.Set(req.Realm, req.Key, value); err != nil {
+			return nil, grpcError(err)
+		}
+		return toPBValue(value), nil
+	}
+
+	ok, _, err := s.Storage.SetIf(req.Realm, req.Key, value, PreconditionFromVersion(*req.Version))
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	if !ok {
+		return nil, status.Error(codes.FailedPrecondition, fmt.Sprintf("Precondition failed for key %v/%v", req.Realm, req.Key))
+	}
+
+	return toPBValue(value), nil
+}
+
+//Delete deletes a key.
+func (s *GRPCServer) Delete(ctx context.Context, req *pb.DeleteRequest) (*pb.DeleteResponse, error) {
+	if err := s.key(ctx, req.Realm, req.Key, OperationWrite); err != nil {
+		return nil, err
+	}
+
+	if !s.Storage.Delete(req.Realm, req.Key) {
+		return nil, notFound(req.Realm, req.Key)
+	}
+
+	return &pb.DeleteResponse{}, nil
+}
+
+//Keys lists all keys of a realm stored on this node.
+func (s *GRPCServer) Keys(ctx context.Context, req *pb.KeysRequest) (*pb.KeysResponse, error) {
+	v := Validator{}
+	v.Realm("realm", req.Realm)
+	if err := v.Err(); err != nil {
+		return nil, grpcError(err)
+	}
+
+	if err := s.authorize(ctx, req.Realm, OperationRead); err != nil {
+		return nil, err
+	}
+
+	return &pb.KeysResponse{Keys: s.Storage.Keys(req.Realm)}, nil
+}
+
+//Realms lists all realms stored on this node.
+func (s *GRPCServer) Realms(ctx context.Context, req *pb.RealmsRequest) (*pb.RealmsResponse, error) {
+	if err := s.authorize(ctx, AllRealms, OperationRead); err != nil {
+		return nil, err
+	}
+
+	return &pb.RealmsResponse{Realms: s.Storage.Realms()}, nil
+}
+
+//TTL returns the remaining time to live of a key.
+func (s *GRPCServer) TTL(ctx context.Context, req *pb.TTLRequest) (*pb.TTLResponse, error) {
+	if err := s.key(ctx, req.Realm, req.Key, OperationRead); err != nil {
+		return nil, err
+	}
+
+	ok, value := s.Storage.Get(req.Realm, req.Key)
+	if !ok {
+		return nil, notFound(req.Realm, req.Key)
+	}
+
+	return &pb.TTLResponse{ExpiresIn: expiresIn(value)}, nil
+}
+
+//Expire atomically replaces a value by a copy with a new expiration, so it
+//gets a new version.
+func (s *GRPCServer) Expire(ctx context.Context, req *pb.ExpireRequest) (*pb.Value, error) {
+	if err := s.key(ctx, req.Realm, req.Key, OperationWrite); err != nil {
+		return nil, err
+	}
+
+	v := Validator{}
+	v.ExpiresIn("expires_in", int(req.ExpiresIn))
+	if err := v.Err(); err != nil {
+		return nil, grpcError(err)
+	}
+
+	value := NewValue("", int(req.ExpiresIn))
+	err := s.Storage.Transaction(func(tx *Transaction) error {
+		ok, current := tx.Get(req.Realm, req.Key)
+		if !ok {
+			return ErrorMessage{fmt.Sprintf("No value found for key %v/%v", req.Realm, req.Key), http.StatusNotFound, ErrorCodeEntityNotFound}
+		}
+
+		value.Value = current.Value
+		value.ContentType = current.ContentType
+		return tx.Set(req.Realm, req.Key, value)
+	})
+	if err != nil {
+		return nil, grpcError(err)
+	}
+
+	return toPBValue(value), nil
+}
+
+//Watch streams changes of all keys matching the realm and key patterns,
+//until the client cancels the call or does not keep up.
+func (s *GRPCServer) Watch(req *pb.WatchRequest, stream pb.InMemoryDB_WatchServer) error {
+	realmPattern := req.Realm
+	if len(realmPattern) == 0 {
+		realmPattern = "*"
+	}
+
+	keyPattern := req.Key
+	if len(keyPattern) == 0 {
+		keyPattern = "*"
+	}
+
+	pattern := realmPattern + "/" + keyPattern
+	if err := ValidatePattern(pattern); err != nil {
+		return status.Error(codes.InvalidArgument, fmt.Sprintf("Invalid pattern %v", pattern))
+	}
+
+	if err := s.authorize(stream.Context(), realmPattern, OperationRead); err != nil {
+		return err
+	}
+
+	subscription := s.Storage.Subscribe(pattern)
+	defer s.Storage.Unsubscribe(subscription)
+
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case <-subscription.Overflow:
+			return status.Error(codes.ResourceExhausted, "Watcher does not keep up")
+		case event := <-subscription.Events:
+			// locks and counters are only replicated, like in serveEvents
+			if event.isReplicationOnly() {
+				continue
+			}
+
+			msg := &pb.Event{
+				Type:  string(event.Type),
+				Realm: event.Realm,
+				Key:   event.Key,
+			}
+			if event.Value != nil {
+				msg.Value = toPBValue(event.Value)
+			}
+
+			if err := stream.Send(msg); err != nil {
+				return err
+			}
+		}
+	}
+}
@@ -0,0 +1,102 @@
+/*
+api_info.go
+Implements all api methods of operational stats.
+
+###################################################################################
+
+MIT License
+
+Copyright (c) 2020 Bruno Hautzenberger
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+//metricsPrefix is the prefix of all metrics in Prometheus exposition format.
+const metricsPrefix = "in_memory_db_"
+
+//labelEscaper escapes label values in Prometheus exposition format.
+var labelEscaper = strings.NewReplacer("\\", "\\\\", "\"", "\\\"", "\n", "\\n")
+
+//writeMetric writes a single metric without labels in Prometheus exposition
+//format.
+func writeMetric(w io.Writer, name string, metricType string, help string, value interface{}) {
+	fmt.Fprintf(w, "# HELP %v%v %v\n", metricsPrefix, name, help)
+	fmt.Fprintf(w, "# TYPE %v%v %v\n", metricsPrefix, name, metricType)
+	fmt.Fprintf(w, "%v%v %v\n", metricsPrefix, name, value)
+}
+
+//API handler to get operational stats
+func (a *API) Info(w http.ResponseWriter, r *http.Request) {
+	if !a.authorize(w, r, AllRealms, OperationRead) {
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(a.Storage.Info())
+}
+
+//API handler to get operational stats in Prometheus exposition format
+func (a *API) Metrics(w http.ResponseWriter, r *http.Request) {
+	if !a.authorize(w, r, AllRealms, OperationRead) {
+		return
+	}
+
+	info := a.Storage.Info()
+
+	w.Header().Add("Content-Type", "text/plain; version=0.0.4")
+	w.WriteHeader(http.StatusOK)
+
+	writeMetric(w, "uptime_seconds", "gauge", "Seconds since the service started.", info.Uptime)
+	writeMetric(w, "realms", "gauge", "Number of realms.", info.Realms)
+
+	realms := make([]string, 0, len(info.KeysPerRealm))
+	for realm := range info.KeysPerRealm {
+		realms = append(realms, realm)
+	}
+	sort.Strings(realms)
+
+	fmt.Fprintf(w, "# HELP %vkeys Number of keys per realm.\n", metricsPrefix)
+	fmt.Fprintf(w, "# TYPE %vkeys gauge\n", metricsPrefix)
+	for _, realm := range realms {
+		fmt.Fprintf(w, "%vkeys{realm=\"%v\"} %v\n", metricsPrefix, labelEscaper.Replace(realm), info.KeysPerRealm[realm])
+	}
+
+	writeMetric(w, "used_memory_bytes", "gauge", "Approximate memory usage of all values.", info.UsedMemory)
+	writeMetric(w, "max_memory_bytes", "gauge", "Memory limit, 0 if there is none.", info.MaxMemory)
+	writeMetric(w, "hits_total", "counter", "Number of gets, that found a value.", info.Hits)
+	writeMetric(w, "misses_total", "counter", "Number of gets, that found no value.", info.Misses)
+	writeMetric(w, "hit_ratio", "gauge", "Ratio of hits to all gets.", info.HitRatio)
+	writeMetric(w, "expirations_total", "counter", "Number of expired values.", info.Expirations)
+	writeMetric(w, "evictions_total", "counter", "Number of evicted values.", info.Evictions)
+	writeMetric(w, "rejected_writes_total", "counter", "Number of writes rejected because of limits.", info.RejectedWrites)
+	writeMetric(w, "expirations_per_second", "gauge", "Expirations per second over the last minute.", info.ExpirationsPerSecond)
+	writeMetric(w, "evictions_per_second", "gauge", "Evictions per second over the last minute.", info.EvictionsPerSecond)
+	writeMetric(w, "pending_timers", "gauge", "Number of pending expiry timers.", info.PendingTimers)
+}
@@ -0,0 +1,200 @@
+/*
+api_locks.go
+Implements all api methods to acquire, renew and release locks.
+
+###################################################################################
+
+MIT License
+
+Copyright (c) 2020 Bruno Hautzenberger
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+//lockNameFromRequest returns the lock name of the request path and raises an
+//error, if it is missing or invalid. Lock names follow the rules of realm
+//names, because access to locks is authorized like access to realms.
+func lockNameFromRequest(w http.ResponseWriter, r *http.Request) (string, bool) {
+	name, ok := mux.Vars(r)["name"]
+	if !ok {
+		RaiseError(w, "Lock name is missing", http.StatusBadRequest, ErrorCodeLockNameMissing)
+		return "", false
+	}
+
+	v := Validator{}
+	v.Realm("name", name)
+	if err := v.Err(); err != nil {
+		RaiseStorageError(w, err)
+		return "", false
+	}
+
+	return name, true
+}
+
+//lockRequestFromRequest reads the lock name from the request vars and the
+//LockRequestMessageType from the request body.
+func lockRequestFromRequest(w http.ResponseWriter, r *http.Request) (string, *LockRequestMessageType, bool) {
+	name, ok := lockNameFromRequest(w, r)
+	if !ok {
+		return "", nil, false
+	}
+
+	msg := &LockRequestMessageType{}
+	err := json.NewDecoder(r.Body).Decode(msg)
+	if err != nil || len(msg.Holder) == 0 {
+		RaiseError(w, "Invalid request body", http.StatusBadRequest, ErrorCodeInvalidRequestBody)
+		return "", nil, false
+	}
+
+	return name, msg, true
+}
+
+//leaseFromLockRequest converts the lease of a LockRequestMessageType to a
+//time.Duration. It raises an error, if the lease is not positive.
+func leaseFromLockRequest(w http.ResponseWriter, msg *LockRequestMessageType) (time.Duration, bool) {
+	if msg.Lease <= 0 {
+		RaiseError(w, "Lease must be greater than 0", http.StatusBadRequest, ErrorCodeInvalidLease)
+		return 0, false
+	}
+
+	return time.Duration(msg.Lease) * time.Second, true
+}
+
+//writeLock writes the given lock as LockMessageType to the response.
+func writeLock(w http.ResponseWriter, lock *Lock) {
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(lock.ToLockMessageType())
+}
+
+//API handler to get the current state of a lock
+func (a *API) GetLock(w http.ResponseWriter, r *http.Request) {
+	name, ok := lockNameFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	if a.forward(w, r, clusterLocksRealm, name) {
+		return
+	}
+
+	if !a.authorize(w, r, name, OperationRead) {
+		return
+	}
+
+	ok, lock := a.Locks.Get(name)
+	if !ok {
+		RaiseError(w, fmt.Sprintf("Lock %v is not acquired", name), http.StatusNotFound, ErrorCodeEntityNotFound)
+		return
+	}
+
+	writeLock(w, lock)
+}
+
+//API handler to acquire locks
+func (a *API) AcquireLock(w http.ResponseWriter, r *http.Request) {
+	if a.forward(w, r, clusterLocksRealm, mux.Vars(r)["name"]) {
+		return
+	}
+
+	name, msg, ok := lockRequestFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	if !a.authorize(w, r, name, OperationWrite) {
+		return
+	}
+
+	lease, ok := leaseFromLockRequest(w, msg)
+	if !ok {
+		return
+	}
+
+	ok, lock := a.Locks.Acquire(name, msg.Holder, lease)
+	if !ok {
+		RaiseError(w, fmt.Sprintf("Lock %v is held by %v", name, lock.Holder), http.StatusConflict, ErrorCodeLockHeld)
+		return
+	}
+
+	writeLock(w, lock)
+}
+
+//API handler to renew the lease of locks
+func (a *API) RenewLock(w http.ResponseWriter, r *http.Request) {
+	if a.forward(w, r, clusterLocksRealm, mux.Vars(r)["name"]) {
+		return
+	}
+
+	name, msg, ok := lockRequestFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	if !a.authorize(w, r, name, OperationWrite) {
+		return
+	}
+
+	lease, ok := leaseFromLockRequest(w, msg)
+	if !ok {
+		return
+	}
+
+	ok, lock := a.Locks.Renew(name, msg.Holder, msg.Token, lease)
+	if !ok {
+		RaiseError(w, fmt.Sprintf("Lock %v is not held by %v with token %v", name, msg.Holder, msg.Token), http.StatusConflict, ErrorCodeLockNotHeld)
+		return
+	}
+
+	writeLock(w, lock)
+}
+
+//API handler to release locks
+func (a *API) ReleaseLock(w http.ResponseWriter, r *http.Request) {
+	if a.forward(w, r, clusterLocksRealm, mux.Vars(r)["name"]) {
+		return
+	}
+
+	name, msg, ok := lockRequestFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	if !a.authorize(w, r, name, OperationWrite) {
+		return
+	}
+
+	if !a.Locks.Release(name, msg.Holder, msg.Token) {
+		RaiseError(w, fmt.Sprintf("Lock %v is not held by %v with token %v", name, msg.Holder, msg.Token), http.StatusConflict, ErrorCodeLockNotHeld)
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -28,9 +28,124 @@ SOFTWARE.
 */
 package main
 
-//ValueMessageType defines the API message for Values
+//ValueMessageType defines the API message for Values. ContentType is only
+//set for values, that were stored with a content type. Encoding is "base64",
+//if Value is base64 encoded, because it contains binary data.
 type ValueMessageType struct {
-	Value     string `json:"value"`
+	Value       string `json:"value"`
+	ExpiresIn   int    `json:"expires-in"`
+	Version     uint64 `json:"version"`
+	ContentType string `json:"content-type,omitempty"`
+	Encoding    string `json:"encoding,omitempty"`
+}
+
+//CompareAndSwapMessageType defines the API message for compare-and-swap
+//requests. Version is the version the stored value must have, 0 means that
+//there must not be a stored value yet. ContentType and Encoding are used like
+//in ValueMessageType.
+type CompareAndSwapMessageType struct {
+	Version     uint64 `json:"version"`
+	Value       string `json:"value"`
+	ExpiresIn   int    `json:"expires-in"`
+	ContentType string `json:"content-type,omitempty"`
+	Encoding    string `json:"encoding,omitempty"`
+}
+
+//BatchEntryMessageType defines the API message for single entries of batch
+//requests. Value and ExpiresIn are only used to set values.
+type BatchEntryMessageType struct {
+	Realm     string `json:"realm"`
+	Key       string `json:"key"`
+	Value     string `json:"value,omitempty"`
+	ExpiresIn int    `json:"expires-in,omitempty"`
+}
+
+//BatchMessageType defines the API message for batch requests
+type BatchMessageType struct {
+	Entries []BatchEntryMessageType `json:"entries"`
+}
+
+//BatchResultMessageType defines the API message for the result of a single
+//entry of a batch request or a single operation of a transaction
+type BatchResultMessageType struct {
+	Realm string            `json:"realm"`
+	Key   string            `json:"key"`
+	Found bool              `json:"found"`
+	Value *ValueMessageType `json:"value,omitempty"`
+}
+
+//BatchResultListMessageType defines the API response for batch requests and
+//transactions
+type BatchResultListMessageType struct {
+	Results []BatchResultMessageType `json:"results"`
+}
+
+//TransactionOperationMessageType defines the API message for a single
+//operation of a transaction. Operation is one of get, set, delete or check.
+//If Version is set, the stored value must have this version, 0 means that
+//there must not be a stored value.
+type TransactionOperationMessageType struct {
+	Operation string  `json:"op"`
+	Realm     string  `json:"realm"`
+	Key       string  `json:"key"`
+	Value     string  `json:"value,omitempty"`
+	ExpiresIn int     `json:"expires-in,omitempty"`
+	Version   *uint64 `json:"version,omitempty"`
+}
+
+//TransactionMessageType defines the API message for transactions
+type TransactionMessageType struct {
+	Operations []TransactionOperationMessageType `json:"operations"`
+}
+
+//RateLimitMessageType defines the API message to count a request to a rate
+//limit. Window is given in seconds, Cost defaults to 1.
+type RateLimitMessageType struct {
+	Algorithm RateLimitAlgorithm `json:"algorithm"`
+	Limit     int64              `json:"limit"`
+	Window    int64              `json:"window"`
+	Cost      int64              `json:"cost,omitempty"`
+}
+
+//RateLimitResultMessageType defines the API response of rate limits. Reset
+//and RetryAfter are given in seconds. RetryAfter is only set, if the request
+//was not allowed.
+type RateLimitResultMessageType struct {
+	Allowed    bool  `json:"allowed"`
+	Limit      int64 `json:"limit"`
+	Remaining  int64 `json:"remaining"`
+	Reset      int64 `json:"reset"`
+	RetryAfter int64 `json:"retry-after,omitempty"`
+}
+
+//ScriptMessageType defines the API message to run a script. The script can
+//only access the given keys by their index and the given arguments.
+type ScriptMessageType struct {
+	Keys   []BatchEntryMessageType `json:"keys"`
+	Args   []string                `json:"args,omitempty"`
+	Script string                  `json:"script"`
+}
+
+//ScriptResultMessageType defines the API response of scripts. Result is the
+//value returned by the script, which is either null, a bool, a number or a
+//string.
+type ScriptResultMessageType struct {
+	Result interface{} `json:"result"`
+}
+
+//LockRequestMessageType defines the API message to acquire, renew or release
+//locks. Lease is given in seconds, Token is ignored when acquiring a lock.
+type LockRequestMessageType struct {
+	Holder string `json:"holder"`
+	Token  uint64 `json:"token"`
+	Lease  int    `json:"lease"`
+}
+
+//LockMessageType defines the API message for acquired locks
+type LockMessageType struct {
+	Name      string `json:"name"`
+	Holder    string `json:"holder"`
+	Token     uint64 `json:"token"`
 	ExpiresIn int    `json:"expires-in"`
 }
 
@@ -44,6 +159,128 @@ type RealmListMessageType struct {
 	Realms []string `json:"realms"`
 }
 
+//KeyScanMessageType defines the API message for a page of scanned keys.
+//Cursor is empty, if there are no more keys.
+type KeyScanMessageType struct {
+	Cursor string   `json:"cursor"`
+	Keys   []string `json:"keys"`
+}
+
+//RealmScanMessageType defines the API message for a page of scanned realms.
+//Cursor is empty, if there are no more realms.
+type RealmScanMessageType struct {
+	Cursor string   `json:"cursor"`
+	Realms []string `json:"realms"`
+}
+
+//EventMessageType defines the API message for keyspace notifications and
+//messages published to channels
+type EventMessageType struct {
+	Type    EventType         `json:"type"`
+	Realm   string            `json:"realm,omitempty"`
+	Key     string            `json:"key,omitempty"`
+	Value   *ValueMessageType `json:"value,omitempty"`
+	Config  *RealmConfig      `json:"config,omitempty"`
+	Channel string            `json:"channel,omitempty"`
+	Message string            `json:"message,omitempty"`
+}
+
+//PublishMessageType defines the API message to publish messages to channels
+type PublishMessageType struct {
+	Message string `json:"message"`
+}
+
+//PublishResultMessageType defines the API response for published messages
+type PublishResultMessageType struct {
+	Receivers int `json:"receivers"`
+}
+
+//MemoryMessageType defines the API message for the memory usage and evictions
+type MemoryMessageType struct {
+	UsedMemory     int64          `json:"used-memory"`
+	MaxMemory      int64          `json:"max-memory"`
+	EvictionPolicy EvictionPolicy `json:"eviction-policy"`
+	Evictions      uint64         `json:"evictions"`
+	RejectedWrites uint64         `json:"rejected-writes"`
+}
+
+//InfoMessageType defines the API message for operational stats. Uptime is
+//given in seconds, rates are calculated over the last minute.
+type InfoMessageType struct {
+	Uptime               int64          `json:"uptime"`
+	Realms               int            `json:"realms"`
+	Keys                 int            `json:"keys"`
+	KeysPerRealm         map[string]int `json:"keys-per-realm"`
+	UsedMemory           int64          `json:"used-memory"`
+	MaxMemory            int64          `json:"max-memory"`
+	Hits                 uint64         `json:"hits"`
+	Misses               uint64         `json:"misses"`
+	HitRatio             float64        `json:"hit-ratio"`
+	Expirations          uint64         `json:"expirations"`
+	Evictions            uint64         `json:"evictions"`
+	RejectedWrites       uint64         `json:"rejected-writes"`
+	ExpirationsPerSecond float64        `json:"expirations-per-second"`
+	EvictionsPerSecond   float64        `json:"evictions-per-second"`
+	PendingTimers        int64          `json:"pending-timers"`
+}
+
+//ReplicationMessageType defines the API message for a single operation of
+//the replication stream and a single record of dumps. Values are sent with
+//their remaining TTL in milliseconds and their version, so replicas can store
+//exact copies.
+type ReplicationMessageType struct {
+	Type        EventType    `json:"type"`
+	Realm       string       `json:"realm,omitempty"`
+	Key         string       `json:"key,omitempty"`
+	Value       string       `json:"value,omitempty"`
+	ExpiresInMs int64        `json:"expires-in-ms,omitempty"`
+	Version     uint64       `json:"version,omitempty"`
+	ContentType string       `json:"content-type,omitempty"`
+	Encoding    string       `json:"encoding,omitempty"`
+	Config      *RealmConfig `json:"config,omitempty"`
+	Holder      string       `json:"holder,omitempty"`
+}
+
+//QueryResultMessageType defines the API message for a page of values found
+//by a query. Cursor is empty, if there are no more values.
+type QueryResultMessageType struct {
+	Cursor  string                   `json:"cursor"`
+	Results []BatchResultMessageType `json:"results"`
+}
+
+//ImportResultMessageType defines the API response for imported dumps
+type ImportResultMessageType struct {
+	Realms  int `json:"realms"`
+	Values  int `json:"values"`
+	Locks   int `json:"locks"`
+	Skipped int `json:"skipped"`
+}
+
+//ClusterNodesMessageType defines the API message to change the members of
+//the cluster
+type ClusterNodesMessageType struct {
+	Nodes []string `json:"nodes"`
+}
+
+//ClusterStatusMessageType defines the API message for the cluster status of
+//this node
+type ClusterStatusMessageType struct {
+	Self         string   `json:"self"`
+	Nodes        []string `json:"nodes"`
+	VirtualNodes int      `json:"virtual-nodes"`
+	Migrating    bool     `json:"migrating"`
+}
+
+//ReplicationStatusMessageType defines the API message for the replication
+//status of this instance
+type ReplicationStatusMessageType struct {
+	Role      string `json:"role"`
+	Primary   string `json:"primary,omitempty"`
+	Connected bool   `json:"connected"`
+	Synced    bool   `json:"synced"`
+	Replicas  int64  `json:"replicas"`
+}
+
 //ErrorMessageType defines the API message for errors
 type ErrorMessageType struct {
 	Error interface{} `json:"error"`
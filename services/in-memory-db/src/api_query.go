@@ -0,0 +1,123 @@
+/*
+api_query.go
+Implements all api methods of queries over indexed fields.
+
+###################################################################################
+
+MIT License
+
+Copyright (c) 2020 Bruno Hautzenberger
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+//queryFromRequest reads the query params field, eq, min, max, cursor and
+//count.
+func queryFromRequest(r *http.Request) (Query, error) {
+	query := Query{
+		Field:  r.FormValue("field"),
+		Cursor: r.FormValue("cursor"),
+	}
+
+	if len(query.Field) == 0 {
+		return query, fmt.Errorf("Field is missing")
+	}
+
+	count, err := getIntParam(r, "count", defaultScanCount)
+	if err != nil || count == 0 || count > maxScanCount {
+		return query, fmt.Errorf("Count must be between 1 and %v", maxScanCount)
+	}
+	query.Count = count
+
+	params := r.URL.Query()
+	if _, ok := params["eq"]; ok {
+		if _, ok := params["min"]; ok {
+			return query, fmt.Errorf("Eq can't be combined with min or max")
+		}
+		if _, ok := params["max"]; ok {
+			return query, fmt.Errorf("Eq can't be combined with min or max")
+		}
+
+		value := ParseIndexValue(params.Get("eq"))
+		query.Min, query.Max = &value, &value
+	}
+
+	if _, ok := params["min"]; ok {
+		value := ParseIndexValue(params.Get("min"))
+		query.Min = &value
+	}
+
+	if _, ok := params["max"]; ok {
+		value := ParseIndexValue(params.Get("max"))
+		query.Max = &value
+	}
+
+	if query.Min != nil && query.Max != nil && query.Min.Kind != query.Max.Kind {
+		return query, fmt.Errorf("Min and max must be of the same type")
+	}
+
+	return query, nil
+}
+
+//API handler to query values of a realm by an indexed field page by page
+func (a *API) Query(w http.ResponseWriter, r *http.Request) {
+	realm, ok := realmFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	if !a.authorize(w, r, realm, OperationRead) {
+		return
+	}
+
+	query, err := queryFromRequest(r)
+	if err != nil {
+		RaiseError(w, err.Error(), http.StatusBadRequest, ErrorCodeInvalidQuery)
+		return
+	}
+
+	found, next, err := a.Storage.Query(realm, query)
+	if err == ErrFieldNotIndexed {
+		RaiseStorageError(w, err)
+		return
+	}
+	if err != nil {
+		RaiseError(w, "Invalid cursor", http.StatusBadRequest, ErrorCodeInvalidQuery)
+		return
+	}
+
+	results := make([]BatchResultMessageType, 0, len(found))
+	for _, result := range found {
+		results = append(results, batchResult(realm, result.Key, true, result.Value))
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(QueryResultMessageType{
+		Cursor:  next,
+		Results: results,
+	})
+}
@@ -0,0 +1,108 @@
+/*
+api_ratelimit.go
+Implements all api methods of rate limits.
+
+###################################################################################
+
+MIT License
+
+Copyright (c) 2020 Bruno Hautzenberger
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+//seconds rounds given duration up to full seconds.
+func seconds(d time.Duration) int64 {
+	return int64(math.Ceil(d.Seconds()))
+}
+
+//API handler to count a request to a rate limit
+func (a *API) RateLimit(w http.ResponseWriter, r *http.Request) {
+	realm, key, ok := keyFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	if a.forward(w, r, realm, key) {
+		return
+	}
+
+	if !a.authorize(w, r, realm, OperationWrite) {
+		return
+	}
+
+	msg := RateLimitMessageType{}
+	err := json.NewDecoder(r.Body).Decode(&msg)
+	if err != nil {
+		RaiseError(w, "Invalid request body", http.StatusBadRequest, ErrorCodeInvalidRequestBody)
+		return
+	}
+
+	limit := RateLimit{
+		Algorithm: msg.Algorithm,
+		Limit:     msg.Limit,
+		Window:    time.Duration(msg.Window) * time.Second,
+		Cost:      msg.Cost,
+	}
+	if limit.Cost == 0 {
+		limit.Cost = 1
+	}
+
+	var result RateLimitResult
+	err = a.Storage.Transaction(func(tx *Transaction) error {
+		var err error
+		result, err = limit.Apply(tx, realm, key, time.Now().UTC())
+		return err
+	})
+	if err != nil {
+		RaiseStorageError(w, err)
+		return
+	}
+
+	response := RateLimitResultMessageType{
+		Allowed:   result.Allowed,
+		Limit:     result.Limit,
+		Remaining: result.Remaining,
+		Reset:     seconds(result.Reset),
+	}
+
+	w.Header().Set("RateLimit-Limit", strconv.FormatInt(response.Limit, 10))
+	w.Header().Set("RateLimit-Remaining", strconv.FormatInt(response.Remaining, 10))
+	w.Header().Set("RateLimit-Reset", strconv.FormatInt(response.Reset, 10))
+	w.Header().Add("Content-Type", "application/json")
+
+	if !result.Allowed {
+		response.RetryAfter = seconds(result.RetryAfter)
+		w.Header().Set("Retry-After", strconv.FormatInt(response.RetryAfter, 10))
+		w.WriteHeader(http.StatusTooManyRequests)
+	} else {
+		w.WriteHeader(http.StatusOK)
+	}
+
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,113 @@
+/*
+api_realms.go
+Implements all api methods to create, configure and delete realms.
+
+###################################################################################
+
+MIT License
+
+Copyright (c) 2020 Bruno Hautzenberger
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+//API handler to get the configuration of a realm
+func (a *API) GetRealmConfig(w http.ResponseWriter, r *http.Request) {
+	realm, ok := realmFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	if !a.authorize(w, r, realm, OperationRead) {
+		return
+	}
+
+	ok, config := a.Storage.GetRealmConfig(realm)
+	if !ok {
+		RaiseError(w, fmt.Sprintf("Realm %v not found", realm), http.StatusNotFound, ErrorCodeEntityNotFound)
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(config)
+}
+
+//API handler to create a realm or replace its configuration
+func (a *API) SetRealmConfig(w http.ResponseWriter, r *http.Request) {
+	realm, ok := realmFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	if !a.authorize(w, r, realm, OperationWrite) {
+		return
+	}
+
+	config := RealmConfig{}
+	err := json.NewDecoder(r.Body).Decode(&config)
+	if err != nil {
+		RaiseError(w, "Invalid request body", http.StatusBadRequest, ErrorCodeInvalidRequestBody)
+		return
+	}
+
+	err = a.Storage.SetRealmConfig(realm, config)
+	if err != nil {
+		RaiseStorageError(w, err)
+		return
+	}
+
+	body, _ := json.Marshal(config)
+	a.broadcast(r, http.MethodPut, "/v1/realms/"+url.PathEscape(realm), body)
+
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(config)
+}
+
+//API handler to delete a realm including all of its values and its
+//configuration
+func (a *API) DeleteRealm(w http.ResponseWriter, r *http.Request) {
+	realm, ok := realmFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	if !a.authorize(w, r, realm, OperationWrite) {
+		return
+	}
+
+	a.broadcast(r, http.MethodDelete, "/v1/realms/"+url.PathEscape(realm), nil)
+
+	if !a.Storage.DeleteRealm(realm) {
+		RaiseError(w, fmt.Sprintf("Realm %v not found", realm), http.StatusNotFound, ErrorCodeEntityNotFound)
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,111 @@
+/*
+api_replication.go
+Implements all api methods of the replication.
+
+###################################################################################
+
+MIT License
+
+Copyright (c) 2020 Bruno Hautzenberger
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+//API handler to stream a snapshot and all following changes to replicas
+func (a *API) ReplicationStream(w http.ResponseWriter, r *http.Request) {
+	if !a.authorize(w, r, AllRealms, OperationRead) {
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		RaiseError(w, "Streaming is not supported", http.StatusInternalServerError, ErrorCodeStreamingUnsupported)
+		return
+	}
+
+	snapshot, subscription := a.Storage.Snapshot()
+	defer a.Storage.Unsubscribe(subscription)
+
+	a.Replication.ReplicaConnected()
+	defer a.Replication.ReplicaDisconnected()
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+
+	encoder := json.NewEncoder(w)
+	for _, event := range snapshot {
+		encoder.Encode(event.ToReplicationMessageType())
+	}
+	encoder.Encode(ReplicationMessageType{Type: EventTypeSynced})
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(keepAliveInterval)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-subscription.Overflow:
+			return
+		case <-keepAlive.C:
+			encoder.Encode(ReplicationMessageType{Type: EventTypePing})
+		case event := <-subscription.Events:
+			encoder.Encode(event.ToReplicationMessageType())
+		}
+
+		flusher.Flush()
+	}
+}
+
+//API handler to get the replication status
+func (a *API) ReplicationStatus(w http.ResponseWriter, r *http.Request) {
+	if !a.authorize(w, r, AllRealms, OperationRead) {
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(a.Replication.Status())
+}
+
+//API handler to promote a replica to primary
+func (a *API) Promote(w http.ResponseWriter, r *http.Request) {
+	// writes are rejected on replicas, so only check the permission here
+	if !a.Access.Authorized(r, AllRealms, OperationWrite) {
+		RaiseError(w, "No write access to *", http.StatusForbidden, ErrorCodeForbidden)
+		return
+	}
+
+	if !a.Replication.Promote() {
+		RaiseError(w, "This instance is not a replica", http.StatusConflict, ErrorCodeNotAReplica)
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(a.Replication.Status())
+}
@@ -0,0 +1,139 @@
+/*
+api_scan.go
+Implements the api method to scan keys and realms page by page.
+
+###################################################################################
+
+MIT License
+
+Copyright (c) 2020 Bruno Hautzenberger
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	//defaultScanCount is the number of keys returned per page, if count is
+	//not set.
+	defaultScanCount = 10
+
+	//maxScanCount is the maximum number of keys returned per page.
+	maxScanCount = 1000
+)
+
+//getIntParam reads a non-negative integer query param. It returns
+//defaultValue, if the param is not set.
+func getIntParam(r *http.Request, name string, defaultValue int) (int, error) {
+	value := r.FormValue(name)
+	if len(value) == 0 {
+		return defaultValue, nil
+	}
+
+	i, err := strconv.Atoi(value)
+	if err != nil || i < 0 {
+		return 0, fmt.Errorf("Invalid %v %v", name, value)
+	}
+
+	return i, nil
+}
+
+//scanFilterFromRequest reads the query params match, type, min-ttl and
+//max-ttl.
+func scanFilterFromRequest(r *http.Request) (ScanFilter, error) {
+	filter := ScanFilter{
+		Match: r.FormValue("match"),
+		Type:  r.FormValue("type"),
+	}
+
+	if err := ValidatePattern(filter.Match); err != nil {
+		return filter, fmt.Errorf("Invalid match %v", filter.Match)
+	}
+
+	if err := ValidatePattern(filter.Type); err != nil {
+		return filter, fmt.Errorf("Invalid type %v", filter.Type)
+	}
+
+	minTTL, err := getIntParam(r, "min-ttl", 0)
+	if err != nil {
+		return filter, err
+	}
+	filter.MinTTL = time.Duration(minTTL) * time.Second
+
+	maxTTL, err := getIntParam(r, "max-ttl", 0)
+	if err != nil {
+		return filter, err
+	}
+	filter.MaxTTL = time.Duration(maxTTL) * time.Second
+
+	return filter, nil
+}
+
+//API handler to scan the keys of a realm, or all realms if no realm is given,
+//page by page
+func (a *API) Scan(w http.ResponseWriter, r *http.Request) {
+	count, err := getIntParam(r, "count", defaultScanCount)
+	if err != nil || count == 0 || count > maxScanCount {
+		RaiseError(w, fmt.Sprintf("Count must be between 1 and %v", maxScanCount), http.StatusBadRequest, ErrorCodeInvalidScanParameter)
+		return
+	}
+
+	filter, err := scanFilterFromRequest(r)
+	if err != nil {
+		RaiseError(w, err.Error(), http.StatusBadRequest, ErrorCodeInvalidScanParameter)
+		return
+	}
+
+	cursor := r.FormValue("cursor")
+	realm := r.FormValue("realm")
+
+	scope := realm
+	if len(scope) == 0 {
+		scope = AllRealms
+	}
+
+	if !a.authorize(w, r, scope, OperationRead) {
+		return
+	}
+
+	var msg interface{}
+	if len(realm) == 0 {
+		realms, next, scanErr := a.Storage.ScanRealms(cursor, count, filter)
+		msg, err = RealmScanMessageType{Cursor: next, Realms: realms}, scanErr
+	} else {
+		keys, next, scanErr := a.Storage.Scan(realm, cursor, count, filter)
+		msg, err = KeyScanMessageType{Cursor: next, Keys: keys}, scanErr
+	}
+
+	if err != nil {
+		RaiseError(w, "Invalid cursor", http.StatusBadRequest, ErrorCodeInvalidScanParameter)
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(msg)
+}
@@ -0,0 +1,97 @@
+/*
+api_script.go
+Implements all api methods of scripts.
+
+###################################################################################
+
+MIT License
+
+Copyright (c) 2020 Bruno Hautzenberger
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+)
+
+//API handler to run a script atomically
+func (a *API) Script(w http.ResponseWriter, r *http.Request) {
+	msg := ScriptMessageType{}
+	body, err := ioutil.ReadAll(r.Body)
+	if err == nil {
+		err = json.Unmarshal(body, &msg)
+	}
+	if err != nil {
+		RaiseError(w, "Invalid request body", http.StatusBadRequest, ErrorCodeInvalidRequestBody)
+		return
+	}
+
+	if len(msg.Keys) > maxScriptKeys {
+		RaiseError(w, fmt.Sprintf("Scripts can access up to %v keys", maxScriptKeys), http.StatusBadRequest, ErrorCodeInvalidScript)
+		return
+	}
+
+	v := Validator{}
+	for i, key := range msg.Keys {
+		validateEntry(&v, "keys", i, key.Realm, key.Key, key.ExpiresIn)
+	}
+	if err := v.Err(); err != nil {
+		RaiseStorageError(w, err)
+		return
+	}
+
+	if a.forwardAll(w, r, body, msg.Keys) {
+		return
+	}
+
+	// scripts may read and write all of their keys
+	for _, key := range msg.Keys {
+		if !a.authorize(w, r, key.Realm, OperationRead) || !a.authorize(w, r, key.Realm, OperationWrite) {
+			return
+		}
+	}
+
+	stmts, err := ParseScript(msg.Script)
+	if err != nil {
+		RaiseError(w, err.Error(), http.StatusBadRequest, ErrorCodeInvalidScript)
+		return
+	}
+
+	var result interface{}
+	err = a.Storage.Transaction(func(tx *Transaction) error {
+		var err error
+		result, err = RunScript(tx, stmts, msg.Keys, msg.Args)
+		return err
+	})
+	if err != nil {
+		RaiseStorageError(w, err)
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(ScriptResultMessageType{
+		Result: result,
+	})
+}
@@ -0,0 +1,244 @@
+/*
+cache.go
+Implements the optional near-cache, which keeps values in the client, until
+they expire.
+
+###################################################################################
+
+MIT License
+
+Copyright (c) 2020 Bruno Hautzenberger
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+package client
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+//cacheEntry is a cached value, which is served until ExpiresAt.
+type cacheEntry struct {
+	Realm     string
+	Key       string
+	Value     ValueMessageType
+	ExpiresAt time.Time
+	StaleAt   time.Time
+}
+
+//cacheLoad tracks the loads of a key, which are in flight. Generation is
+//increased, whenever the key is changed, so loads started before the change
+//do not cache the old value.
+type cacheLoad struct {
+	Pending    int
+	Generation uint64
+}
+
+//nearCache holds a limited number of values by realm and key. Entries are
+//elements of a list ordered by their last use, so the least recently used
+//entry is evicted in constant time, when the cache is full.
+type nearCache struct {
+	Size      int
+	TTL       time.Duration
+	Entries   map[string]map[string]*list.Element
+	LRU       *list.List
+	Loads     map[string]map[string]*cacheLoad
+	MutexLock sync.Mutex
+}
+
+func newNearCache(size int, ttl time.Duration) *nearCache {
+	return &nearCache{
+		Size:    size,
+		TTL:     ttl,
+		Entries: make(map[string]map[string]*list.Element),
+		LRU:     list.New(),
+		Loads:   make(map[string]map[string]*cacheLoad),
+	}
+}
+
+//get returns a copy of a cached value with its remaining TTL.
+func (c *nearCache) get(realm string, key string) (*ValueMessageType, bool) {
+	c.MutexLock.Lock()
+	defer c.MutexLock.Unlock()
+
+	element, ok := c.Entries[realm][key]
+	if !ok {
+		return nil, false
+	}
+
+	now := time.Now()
+	entry := element.Value.(*cacheEntry)
+	if !now.Before(entry.StaleAt) {
+		c.remove(realm, key)
+		return nil, false
+	}
+
+	c.LRU.MoveToFront(element)
+	value := entry.Value
+	value.ExpiresIn = int(entry.ExpiresAt.Sub(now).Seconds())
+	return &value, true
+}
+
+//begin registers a load of a key, which has to be finished by calling finish
+//with the returned generation.
+func (c *nearCache) begin(realm string, key string) uint64 {
+	c.MutexLock.Lock()
+	defer c.MutexLock.Unlock()
+
+	load, ok := c.Loads[realm][key]
+	if !ok {
+		if _, ok := c.Loads[realm]; !ok {
+			c.Loads[realm] = make(map[string]*cacheLoad)
+		}
+		load = &cacheLoad{}
+		c.Loads[realm][key] = load
+	}
+	load.Pending++
+
+	return load.Generation
+}
+
+//finish ends a load of a key and caches the loaded value, which is nil if
+//the load failed, unless the key was changed since the load began.
+func (c *nearCache) finish(realm string, key string, generation uint64, value *ValueMessageType) {
+	c.MutexLock.Lock()
+	defer c.MutexLock.Unlock()
+
+	load, ok := c.Loads[realm][key]
+	if !ok {
+		return
+	}
+
+	load.Pending--
+	if load.Pending == 0 {
+		delete(c.Loads[realm], key)
+		if len(c.Loads[realm]) == 0 {
+			delete(c.Loads, realm)
+		}
+	}
+
+	if value != nil && load.Generation == generation {
+		c.set(realm, key, value)
+	}
+}
+
+//set caches a value until it expires or is stale without locking the cache.
+func (c *nearCache) set(realm string, key string, value *ValueMessageType) {
+	now := time.Now()
+	entry := &cacheEntry{
+		Realm:     realm,
+		Key:       key,
+		Value:     *value,
+		ExpiresAt: now.Add(time.Duration(value.ExpiresIn) * time.Second),
+	}
+
+	entry.StaleAt = entry.ExpiresAt
+	if c.TTL > 0 && now.Add(c.TTL).Before(entry.StaleAt) {
+		entry.StaleAt = now.Add(c.TTL)
+	}
+
+	if !now.Before(entry.StaleAt) {
+		return
+	}
+
+	c.remove(realm, key)
+	for c.LRU.Len() >= c.Size {
+		c.evict()
+	}
+
+	if _, ok := c.Entries[realm]; !ok {
+		c.Entries[realm] = make(map[string]*list.Element)
+	}
+	c.Entries[realm][key] = c.LRU.PushFront(entry)
+}
+
+//evict removes the least recently used entry without locking the cache.
+func (c *nearCache) evict() {
+	entry := c.LRU.Back().Value.(*cacheEntry)
+	c.remove(entry.Realm, entry.Key)
+}
+
+//remove removes an entry without locking the cache.
+func (c *nearCache) remove(realm string, key string) {
+	element, ok := c.Entries[realm][key]
+	if !ok {
+		return
+	}
+
+	c.LRU.Remove(element)
+	delete(c.Entries[realm], key)
+	if len(c.Entries[realm]) == 0 {
+		delete(c.Entries, realm)
+	}
+}
+
+//delete removes a value, which was changed.
+func (c *nearCache) delete(realm string, key string) {
+	c.MutexLock.Lock()
+	defer c.MutexLock.Unlock()
+
+	c.remove(realm, key)
+	if load, ok := c.Loads[realm][key]; ok {
+		load.Generation++
+	}
+}
+
+//deleteRealm removes all values of a realm, which was changed.
+func (c *nearCache) deleteRealm(realm string) {
+	c.MutexLock.Lock()
+	defer c.MutexLock.Unlock()
+
+	for _, element := range c.Entries[realm] {
+		c.LRU.Remove(element)
+	}
+	delete(c.Entries, realm)
+	for _, load := range c.Loads[realm] {
+		load.Generation++
+	}
+}
+
+//clear removes all values.
+func (c *nearCache) clear() {
+	c.MutexLock.Lock()
+	defer c.MutexLock.Unlock()
+
+	c.Entries = make(map[string]map[string]*list.Element)
+	c.LRU.Init()
+	for _, loads := range c.Loads {
+		for _, load := range loads {
+			load.Generation++
+		}
+	}
+}
+
+//uncache removes a value from the near-cache, if it is enabled.
+func (c *Client) uncache(realm string, key string) {
+	if c.cache != nil {
+		c.cache.delete(realm, key)
+	}
+}
+
+//uncacheEntries removes the values of all entries from the near-cache.
+func (c *Client) uncacheEntries(entries []BatchEntryMessageType) {
+	for _, entry := range entries {
+		c.uncache(entry.Realm, entry.Key)
+	}
+}
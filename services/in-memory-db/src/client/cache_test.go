@@ -0,0 +1,184 @@
+/*
+cache_test.go
+Tests of the near-cache.
+
+###################################################################################
+
+MIT License
+
+Copyright (c) 2020 Bruno Hautzenberger
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+//cacheValue caches a value, which expires in a minute, like a finished load.
+func cacheValue(c *nearCache, realm string, key string, value string) {
+	c.finish(realm, key, c.begin(realm, key), &ValueMessageType{Value: value, ExpiresIn: 60})
+}
+
+//expectCached fails the test, if the cached value of a key differs from the
+//expected value. An empty expected value means, that the key is not cached.
+func expectCached(t *testing.T, c *nearCache, realm string, key string, expected string) {
+	t.Helper()
+
+	value, ok := c.get(realm, key)
+	switch {
+	case len(expected) == 0 && ok:
+		t.Errorf("%v/%v is cached as %q, expected it to be uncached", realm, key, value.Value)
+	case len(expected) > 0 && (!ok || value.Value != expected):
+		t.Errorf("%v/%v is cached as %+v, expected %q", realm, key, value, expected)
+	}
+}
+
+func TestNearCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	c := newNearCache(3, 0)
+	cacheValue(c, "r", "a", "1")
+	cacheValue(c, "r", "b", "2")
+	cacheValue(c, "s", "c", "3")
+
+	// a is used, so b is the least recently used value
+	expectCached(t, c, "r", "a", "1")
+	cacheValue(c, "s", "d", "4")
+
+	expectCached(t, c, "r", "b", "")
+	expectCached(t, c, "r", "a", "1")
+	expectCached(t, c, "s", "c", "3")
+	expectCached(t, c, "s", "d", "4")
+
+	// overwriting a value does not evict another one
+	cacheValue(c, "s", "c", "5")
+	expectCached(t, c, "s", "c", "5")
+	expectCached(t, c, "r", "a", "1")
+
+	if c.LRU.Len() != 3 {
+		t.Errorf("cache holds %v values, expected 3", c.LRU.Len())
+	}
+}
+
+func TestNearCacheStaleValues(t *testing.T) {
+	c := newNearCache(10, 20*time.Millisecond)
+	cacheValue(c, "r", "a", "1")
+	c.finish("r", "expired", c.begin("r", "expired"), &ValueMessageType{Value: "x", ExpiresIn: 0})
+
+	expectCached(t, c, "r", "a", "1")
+	expectCached(t, c, "r", "expired", "")
+
+	time.Sleep(30 * time.Millisecond)
+	expectCached(t, c, "r", "a", "")
+
+	if c.LRU.Len() != 0 || len(c.Entries) != 0 {
+		t.Errorf("stale value was not removed")
+	}
+}
+
+func TestNearCacheChangesDuringLoad(t *testing.T) {
+	c := newNearCache(10, 0)
+
+	generation := c.begin("r", "a")
+	c.delete("r", "a")
+	c.finish("r", "a", generation, &ValueMessageType{Value: "old", ExpiresIn: 60})
+	expectCached(t, c, "r", "a", "")
+
+	generation = c.begin("r", "a")
+	c.deleteRealm("r")
+	c.finish("r", "a", generation, &ValueMessageType{Value: "old", ExpiresIn: 60})
+	expectCached(t, c, "r", "a", "")
+
+	generation = c.begin("r", "a")
+	c.clear()
+	c.finish("r", "a", generation, &ValueMessageType{Value: "old", ExpiresIn: 60})
+	expectCached(t, c, "r", "a", "")
+
+	if len(c.Loads) != 0 {
+		t.Errorf("finished loads are still tracked: %v", c.Loads)
+	}
+}
+
+func TestNearCacheDeleteRealmAndClear(t *testing.T) {
+	c := newNearCache(10, 0)
+	cacheValue(c, "r", "a", "1")
+	cacheValue(c, "r", "b", "2")
+	cacheValue(c, "s", "c", "3")
+
+	c.deleteRealm("r")
+	expectCached(t, c, "r", "a", "")
+	expectCached(t, c, "s", "c", "3")
+	if c.LRU.Len() != 1 {
+		t.Errorf("cache holds %v values, expected 1", c.LRU.Len())
+	}
+
+	c.clear()
+	expectCached(t, c, "s", "c", "")
+	if c.LRU.Len() != 0 {
+		t.Errorf("cache holds %v values, expected none", c.LRU.Len())
+	}
+
+	// the cache can be filled again after it was cleared
+	for i := 0; i < 20; i++ {
+		cacheValue(c, "r", fmt.Sprintf("k%v", i), "v")
+	}
+	if c.LRU.Len() != 10 {
+		t.Errorf("cache holds %v values, expected 10", c.LRU.Len())
+	}
+}
+
+func TestClientGetUsesNearCache(t *testing.T) {
+	var gets int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			atomic.AddInt64(&gets, 1)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(ValueMessageType{Value: "v", ExpiresIn: 60, Version: uint64(atomic.LoadInt64(&gets))})
+	}))
+	defer server.Close()
+
+	c := NewClient(Config{URL: server.URL, CacheSize: 10})
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		if value, err := c.Get(ctx, "r", "a"); err != nil || value.Version != 1 {
+			t.Fatalf("Get = %+v, %v, expected the first loaded value", value, err)
+		}
+	}
+
+	if _, err := c.Set(ctx, "r", "a", "changed", 60); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+
+	if value, err := c.Get(ctx, "r", "a"); err != nil || value.Version != 2 {
+		t.Errorf("Get after Set = %+v, %v, expected a loaded value", value, err)
+	}
+
+	if n := atomic.LoadInt64(&gets); n != 2 {
+		t.Errorf("server served %v gets, expected 2", n)
+	}
+}
@@ -0,0 +1,273 @@
+/*
+client.go
+Implements the Go client of the in-memory-db. It covers all methods of the
+RESTful API, retries failed requests with backoff, pools connections and
+optionally caches values in a near-cache.
+
+###################################################################################
+
+MIT License
+
+Copyright (c) 2020 Bruno Hautzenberger
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+//Package client is the Go client of the in-memory-db.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+//Config holds the configuration of a Client. Only URL is required.
+type Config struct {
+	//URL is the base url of the in-memory-db, e.g. http://localhost:7000.
+	URL string
+
+	//Token is sent as bearer token, if the in-memory-db uses access control.
+	Token string
+
+	//Timeout of single requests. Defaults to 10 seconds. Streams are only
+	//limited by their context.
+	Timeout time.Duration
+
+	//Retries is the number of times failed idempotent requests are retried,
+	//if the in-memory-db can't be reached or is unavailable. Defaults to 0.
+	Retries int
+
+	//RetryBackoff is the time waited before the first retry. It is doubled
+	//for every further retry up to MaxRetryBackoff. Defaults to 100ms and 5s.
+	RetryBackoff    time.Duration
+	MaxRetryBackoff time.Duration
+
+	//MaxIdleConns is the number of idle connections kept open for reuse.
+	//Defaults to 16.
+	MaxIdleConns int
+
+	//CacheSize is the maximum number of values kept in the near-cache. The
+	//near-cache is disabled, if it is 0.
+	CacheSize int
+
+	//CacheTTL is the maximum time values are served from the near-cache.
+	//Values are always removed, when they expire. Writes of other clients are
+	//only visible after CacheTTL, so it should be short. If it is 0, values
+	//are cached until they expire.
+	CacheTTL time.Duration
+
+	//HTTPClient is used to send requests instead of a pooled default client.
+	HTTPClient *http.Client
+}
+
+//Client sends requests to the in-memory-db. It is safe for concurrent use.
+type Client struct {
+	Config Config
+	HTTP   *http.Client
+	cache  *nearCache
+}
+
+//NewClient creates a Client using given configuration.
+func NewClient(config Config) *Client {
+	if config.Timeout == 0 {
+		config.Timeout = 10 * time.Second
+	}
+	if config.RetryBackoff == 0 {
+		config.RetryBackoff = 100 * time.Millisecond
+	}
+	if config.MaxRetryBackoff == 0 {
+		config.MaxRetryBackoff = 5 * time.Second
+	}
+	if config.MaxIdleConns == 0 {
+		config.MaxIdleConns = 16
+	}
+	config.URL = strings.TrimSuffix(config.URL, "/")
+
+	c := &Client{
+		Config: config,
+		HTTP:   config.HTTPClient,
+	}
+
+	// the client has no timeout, because it would also end streams
+	if c.HTTP == nil {
+		c.HTTP = &http.Client{
+			Transport: &http.Transport{
+				Proxy:               http.ProxyFromEnvironment,
+				MaxIdleConns:        config.MaxIdleConns,
+				MaxIdleConnsPerHost: config.MaxIdleConns,
+				IdleConnTimeout:     90 * time.Second,
+			},
+		}
+	}
+
+	if config.CacheSize > 0 {
+		c.cache = newNearCache(config.CacheSize, config.CacheTTL)
+	}
+
+	return c
+}
+
+//request describes a single request to the in-memory-db.
+type request struct {
+	Method      string
+	Path        string
+	Query       url.Values
+	Body        interface{}
+	Reader      io.Reader
+	ContentType string
+
+	//Retry is true for idempotent requests, which can be sent again.
+	Retry bool
+
+	//Accept are status codes >= 400, which are no errors.
+	Accept []int
+
+	//Attempts is the number of times the request was sent.
+	Attempts int
+}
+
+//path joins escaped path segments.
+func path(segments ...string) string {
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return "/" + strings.Join(segments, "/")
+}
+
+//send sends a request once.
+func (c *Client) send(ctx context.Context, req *request, body []byte) (*http.Response, error) {
+	u := c.Config.URL + "/v1" + req.Path
+	if len(req.Query) > 0 {
+		u += "?" + req.Query.Encode()
+	}
+
+	var reader io.Reader
+	if req.Reader != nil {
+		reader = req.Reader
+	} else if body != nil {
+		reader = bytes.NewReader(body)
+	}
+
+	r, err := http.NewRequest(req.Method, u, reader)
+	if err != nil {
+		return nil, err
+	}
+	r = r.WithContext(ctx)
+
+	r.Header.Set("Accept", "application/json")
+	if reader != nil {
+		contentType := req.ContentType
+		if len(contentType) == 0 {
+			contentType = "application/json"
+		}
+		r.Header.Set("Content-Type", contentType)
+	}
+	if len(c.Config.Token) > 0 {
+		r.Header.Set("Authorization", "Bearer "+c.Config.Token)
+	}
+
+	return c.HTTP.Do(r)
+}
+
+//do sends a request and retries it, if it is idempotent and failed
+//temporarily. The body of the returned response has to be closed.
+func (c *Client) do(ctx context.Context, req *request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		if body, err = json.Marshal(req.Body); err != nil {
+			return nil, err
+		}
+	}
+
+	backoff := c.Config.RetryBackoff
+	for attempt := 0; ; attempt++ {
+		req.Attempts = attempt + 1
+		resp, err := c.send(ctx, req, body)
+		if err == nil && (resp.StatusCode < http.StatusBadRequest || accepts(req.Accept, resp.StatusCode)) {
+			return resp, nil
+		}
+		if err == nil {
+			err = errorFromResponse(resp)
+		}
+
+		if !req.Retry || req.Reader != nil || attempt >= c.Config.Retries || !temporary(err) || ctx.Err() != nil {
+			return nil, err
+		}
+
+		// wait between half and the full backoff, so clients do not retry in sync
+		wait := backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(wait):
+		}
+
+		backoff *= 2
+		if backoff > c.Config.MaxRetryBackoff {
+			backoff = c.Config.MaxRetryBackoff
+		}
+	}
+}
+
+//call sends a request with the configured timeout and decodes the JSON
+//response into out, if it is not nil.
+func (c *Client) call(ctx context.Context, req *request, out interface{}) error {
+	ctx, cancel := context.WithTimeout(ctx, c.Config.Timeout)
+	defer cancel()
+
+	resp, err := c.do(ctx, req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if out == nil || resp.StatusCode == http.StatusNoContent {
+		// read the body, so the connection can be reused
+		io.Copy(ioutil.Discard, resp.Body)
+		return nil
+	}
+
+	return decodeBody(resp, out)
+}
+
+//decodeBody decodes the JSON body of a response into out.
+func decodeBody(resp *http.Response, out interface{}) error {
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("in-memory-db: invalid response: %v", err)
+	}
+	return nil
+}
+
+func accepts(statusCodes []int, statusCode int) bool {
+	for _, accepted := range statusCodes {
+		if accepted == statusCode {
+			return true
+		}
+	}
+	return false
+}
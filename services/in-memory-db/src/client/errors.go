@@ -0,0 +1,151 @@
+/*
+errors.go
+Defines the errors returned by the in-memory-db. They use the same error codes
+as the service.
+
+###################################################################################
+
+MIT License
+
+Copyright (c) 2020 Bruno Hautzenberger
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+)
+
+// ErrorCode defines all possible errors codes of the in-memory-db
+type ErrorCode int
+
+const (
+	ErrorCodeInternal             ErrorCode = 0
+	ErrorCodeRealmMissing                   = 1
+	ErrorCodeKeyMissing                     = 2
+	ErrorCodeEntityNotFound                 = 3
+	ErrorCodeInvalidRequestBody             = 4
+	ErrorCodePreconditionFailed             = 5
+	ErrorCodeLockNameMissing                = 6
+	ErrorCodeInvalidLease                   = 7
+	ErrorCodeLockHeld                       = 8
+	ErrorCodeLockNotHeld                    = 9
+	ErrorCodeInvalidPattern                 = 10
+	ErrorCodeStreamingUnsupported           = 11
+	ErrorCodeChannelMissing                 = 12
+	ErrorCodeOutOfMemory                    = 13
+	ErrorCodeInvalidOperation               = 14
+	ErrorCodeInvalidScanParameter           = 15
+	ErrorCodeUnauthorized                   = 16
+	ErrorCodeForbidden                      = 17
+	ErrorCodeAuthUnavailable                = 18
+	ErrorCodeValueTooLarge                  = 19
+	ErrorCodeTTLTooLong                     = 20
+	ErrorCodeRealmKeyLimitReached           = 21
+	ErrorCodeInvalidRealmConfig             = 22
+	ErrorCodeReadOnlyReplica                = 23
+	ErrorCodeNotAReplica                    = 24
+	ErrorCodeInvalidRateLimit               = 25
+	ErrorCodeNoRateLimitState               = 26
+	ErrorCodeInvalidDumpFormat              = 27
+	ErrorCodeInvalidImportMode              = 28
+	ErrorCodeFieldNotIndexed                = 29
+	ErrorCodeInvalidQuery                   = 30
+	ErrorCodeNodeUnavailable                = 31
+	ErrorCodeCrossNode                      = 32
+	ErrorCodeClusterDisabled                = 33
+	ErrorCodeInvalidScript                  = 34
+	ErrorCodeScriptError                    = 35
+	ErrorCodeScriptLimit                    = 36
+	ErrorCodeScriptFailed                   = 37
+	ErrorCodeValidationFailed               = 38
+)
+
+//FieldError describes why a single field of a request is invalid.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+//Error is an error returned by the in-memory-db. Fields are only set, if the
+//request was invalid.
+type Error struct {
+	Message    string       `json:"message"`
+	StatusCode int          `json:"status"`
+	Code       ErrorCode    `json:"code"`
+	Fields     []FieldError `json:"fields,omitempty"`
+}
+
+//Error returns the message, status and code of this error.
+func (e *Error) Error() string {
+	return fmt.Sprintf("in-memory-db: %v (status %v, code %v)", e.Message, e.StatusCode, e.Code)
+}
+
+//Is checks if target is an Error with the same code, so errors.Is can be
+//used with the errors below, e.g. errors.Is(err, client.ErrNotFound).
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.Code == e.Code
+}
+
+//Errors, which are compared by their code using errors.Is
+var (
+	ErrNotFound           = &Error{Message: "Not found", StatusCode: http.StatusNotFound, Code: ErrorCodeEntityNotFound}
+	ErrPreconditionFailed = &Error{Message: "Precondition failed", StatusCode: http.StatusPreconditionFailed, Code: ErrorCodePreconditionFailed}
+	ErrLockHeld           = &Error{Message: "Lock is held", StatusCode: http.StatusConflict, Code: ErrorCodeLockHeld}
+	ErrLockNotHeld        = &Error{Message: "Lock is not held", StatusCode: http.StatusConflict, Code: ErrorCodeLockNotHeld}
+	ErrOutOfMemory        = &Error{Message: "Out of memory", StatusCode: http.StatusInsufficientStorage, Code: ErrorCodeOutOfMemory}
+	ErrRealmKeyLimit      = &Error{Message: "Realm key limit reached", StatusCode: http.StatusInsufficientStorage, Code: ErrorCodeRealmKeyLimitReached}
+	ErrUnauthorized       = &Error{Message: "Unauthorized", StatusCode: http.StatusUnauthorized, Code: ErrorCodeUnauthorized}
+	ErrForbidden          = &Error{Message: "Forbidden", StatusCode: http.StatusForbidden, Code: ErrorCodeForbidden}
+	ErrReadOnlyReplica    = &Error{Message: "Read-only replica", StatusCode: http.StatusForbidden, Code: ErrorCodeReadOnlyReplica}
+	ErrScriptFailed       = &Error{Message: "Script failed", StatusCode: http.StatusConflict, Code: ErrorCodeScriptFailed}
+	ErrValidationFailed   = &Error{Message: "Validation failed", StatusCode: http.StatusBadRequest, Code: ErrorCodeValidationFailed}
+)
+
+//errorFromResponse reads the Error of a failed request and closes the body.
+func errorFromResponse(resp *http.Response) error {
+	defer resp.Body.Close()
+
+	msg := struct {
+		Error *Error `json:"error"`
+	}{}
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil || json.Unmarshal(body, &msg) != nil || msg.Error == nil {
+		return &Error{Message: resp.Status, StatusCode: resp.StatusCode, Code: ErrorCodeInternal}
+	}
+
+	return msg.Error
+}
+
+//temporary checks if a request failed, because the in-memory-db could not be
+//reached or was unavailable, so it can be retried.
+func temporary(err error) bool {
+	if e, ok := err.(*Error); ok {
+		return e.StatusCode == http.StatusBadGateway || e.StatusCode == http.StatusServiceUnavailable || e.StatusCode == http.StatusGatewayTimeout
+	}
+
+	return err != context.Canceled && err != context.DeadlineExceeded
+}
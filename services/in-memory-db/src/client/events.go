@@ -0,0 +1,110 @@
+/*
+events.go
+Implements publishing to channels and streams of keyspace notifications and
+channel messages.
+
+###################################################################################
+
+MIT License
+
+Copyright (c) 2020 Bruno Hautzenberger
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+package client
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+//Publish publishes a message to a channel and returns the number of
+//subscribers, that received it.
+func (c *Client) Publish(ctx context.Context, channel string, message string) (int, error) {
+	msg := PublishResultMessageType{}
+	err := c.call(ctx, &request{Method: http.MethodPost, Path: path("channels", channel), Body: PublishMessageType{Message: message}}, &msg)
+	return msg.Receivers, err
+}
+
+//EventStream receives events sent as Server-Sent Events.
+type EventStream struct {
+	body   io.ReadCloser
+	reader *bufio.Reader
+}
+
+//Next blocks until the next event is received. It returns io.EOF, if the
+//stream ended, e.g. because the subscriber did not keep up.
+func (s *EventStream) Next() (*EventMessageType, error) {
+	data := ""
+	for {
+		line, err := s.reader.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+
+		switch {
+		case len(line) == 0 && len(data) > 0:
+			event := &EventMessageType{}
+			if err := json.Unmarshal([]byte(data), event); err != nil {
+				return nil, err
+			}
+			return event, nil
+		case strings.HasPrefix(line, "data:"):
+			data += strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+		}
+	}
+}
+
+//Close closes the stream.
+func (s *EventStream) Close() error {
+	return s.body.Close()
+}
+
+//stream opens a stream of events, which lasts until it is closed or the
+//context is done.
+func (c *Client) stream(ctx context.Context, path string, query url.Values) (*EventStream, error) {
+	resp, err := c.do(ctx, &request{Method: http.MethodGet, Path: path, Query: query, Retry: true})
+	if err != nil {
+		return nil, err
+	}
+
+	return &EventStream{resp.Body, bufio.NewReader(resp.Body)}, nil
+}
+
+//Watch streams keyspace notifications of all keys matching the realm and key
+//patterns, e.g. "*" for all realms or keys.
+func (c *Client) Watch(ctx context.Context, realmPattern string, keyPattern string) (*EventStream, error) {
+	query := url.Values{}
+	setParam(query, "realm", realmPattern)
+	setParam(query, "key", keyPattern)
+
+	return c.stream(ctx, "/events", query)
+}
+
+//Subscribe streams all messages published to channels matching the given
+//channel pattern.
+func (c *Client) Subscribe(ctx context.Context, channelPattern string) (*EventStream, error) {
+	return c.stream(ctx, path("channels", channelPattern), nil)
+}
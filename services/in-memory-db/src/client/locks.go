@@ -0,0 +1,75 @@
+/*
+locks.go
+Implements all methods of distributed locks.
+
+###################################################################################
+
+MIT License
+
+Copyright (c) 2020 Bruno Hautzenberger
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+package client
+
+import (
+	"context"
+	"net/http"
+)
+
+//GetLock returns the current state of a lock. It fails with ErrNotFound, if
+//the lock is not held.
+func (c *Client) GetLock(ctx context.Context, name string) (*LockMessageType, error) {
+	return c.lock(ctx, http.MethodGet, path("locks", name), nil, true)
+}
+
+//AcquireLock acquires a lock for given holder with a lease in seconds. It
+//fails with ErrLockHeld, if the lock is held by someone else.
+func (c *Client) AcquireLock(ctx context.Context, name string, holder string, lease int) (*LockMessageType, error) {
+	msg := &LockRequestMessageType{Holder: holder, Lease: lease}
+	return c.lock(ctx, http.MethodPost, path("locks", name), msg, false)
+}
+
+//RenewLock extends the lease of a held lock. It fails with ErrLockNotHeld,
+//if the holder or token do not match.
+func (c *Client) RenewLock(ctx context.Context, name string, holder string, token uint64, lease int) (*LockMessageType, error) {
+	msg := &LockRequestMessageType{Holder: holder, Token: token, Lease: lease}
+	return c.lock(ctx, http.MethodPost, path("locks", name, "renew"), msg, true)
+}
+
+//ReleaseLock releases a held lock. It fails with ErrLockNotHeld, if the
+//holder or token do not match.
+func (c *Client) ReleaseLock(ctx context.Context, name string, holder string, token uint64) error {
+	msg := &LockRequestMessageType{Holder: holder, Token: token}
+	return c.call(ctx, &request{Method: http.MethodPost, Path: path("locks", name, "release"), Body: msg}, nil)
+}
+
+func (c *Client) lock(ctx context.Context, method string, path string, msg *LockRequestMessageType, retry bool) (*LockMessageType, error) {
+	req := &request{Method: method, Path: path, Retry: retry}
+	if msg != nil {
+		req.Body = msg
+	}
+
+	lock := &LockMessageType{}
+	if err := c.call(ctx, req, lock); err != nil {
+		return nil, err
+	}
+
+	return lock, nil
+}
@@ -0,0 +1,360 @@
+/*
+messages.go
+Defines all messages of the RESTful API of the in-memory-db.
+
+###################################################################################
+
+MIT License
+
+Copyright (c) 2020 Bruno Hautzenberger
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+package client
+
+import (
+	"encoding/base64"
+	"unicode/utf8"
+)
+
+//EncodingBase64 is the encoding of values containing binary data.
+const EncodingBase64 = "base64"
+
+//Event types
+const (
+	EventTypeSet       = "set"
+	EventTypeDelete    = "delete"
+	EventTypeExpire    = "expire"
+	EventTypeEvict     = "evict"
+	EventTypeConfigure = "configure"
+	EventTypeDrop      = "drop"
+	EventTypeMessage   = "message"
+)
+
+//Eviction policies of realms and of the memory limit
+const (
+	EvictionPolicyLRU         = "lru"
+	EvictionPolicyLFU         = "lfu"
+	EvictionPolicyVolatileTTL = "volatile-ttl"
+	EvictionPolicyNoEviction  = "noeviction"
+)
+
+//Rate limit algorithms
+const (
+	RateLimitTokenBucket   = "token-bucket"
+	RateLimitSlidingWindow = "sliding-window"
+)
+
+//Dump formats of Export and Import
+const (
+	DumpFormatNDJSON = "ndjson"
+	DumpFormatGob    = "gob"
+)
+
+//Import modes
+const (
+	ImportModeMerge   = "merge"
+	ImportModeReplace = "replace"
+)
+
+//ValueMessageType defines the API message for Values. ContentType is only
+//set for values, that were stored with a content type. Encoding is "base64",
+//if Value is base64 encoded, because it contains binary data.
+type ValueMessageType struct {
+	Value       string `json:"value"`
+	ExpiresIn   int    `json:"expires-in"`
+	Version     uint64 `json:"version"`
+	ContentType string `json:"content-type,omitempty"`
+	Encoding    string `json:"encoding,omitempty"`
+}
+
+//Bytes returns the decoded value.
+func (v *ValueMessageType) Bytes() ([]byte, error) {
+	if v.Encoding == EncodingBase64 {
+		return base64.StdEncoding.DecodeString(v.Value)
+	}
+	return []byte(v.Value), nil
+}
+
+//NewValueMessageType creates a ValueMessageType for given data, which is
+//base64 encoded, if it is no valid UTF-8.
+func NewValueMessageType(data []byte, contentType string, expiresIn int) ValueMessageType {
+	msg := ValueMessageType{
+		Value:       string(data),
+		ExpiresIn:   expiresIn,
+		ContentType: contentType,
+	}
+
+	if !utf8.Valid(data) {
+		msg.Value = base64.StdEncoding.EncodeToString(data)
+		msg.Encoding = EncodingBase64
+	}
+
+	return msg
+}
+
+//CompareAndSwapMessageType defines the API message for compare-and-swap
+//requests. Version is the version the stored value must have, 0 means that
+//there must not be a stored value yet.
+type CompareAndSwapMessageType struct {
+	Version   uint64 `json:"version"`
+	Value     string `json:"value"`
+	ExpiresIn int    `json:"expires-in"`
+}
+
+//BatchEntryMessageType defines the API message for single entries of batch
+//requests. Value and ExpiresIn are only used to set values.
+type BatchEntryMessageType struct {
+	Realm     string `json:"realm"`
+	Key       string `json:"key"`
+	Value     string `json:"value,omitempty"`
+	ExpiresIn int    `json:"expires-in,omitempty"`
+}
+
+//BatchMessageType defines the API message for batch requests
+type BatchMessageType struct {
+	Entries []BatchEntryMessageType `json:"entries"`
+}
+
+//BatchResultMessageType defines the API message for the result of a single
+//entry of a batch request or a single operation of a transaction
+type BatchResultMessageType struct {
+	Realm string            `json:"realm"`
+	Key   string            `json:"key"`
+	Found bool              `json:"found"`
+	Value *ValueMessageType `json:"value,omitempty"`
+}
+
+//BatchResultListMessageType defines the API response for batch requests and
+//transactions
+type BatchResultListMessageType struct {
+	Results []BatchResultMessageType `json:"results"`
+}
+
+//TransactionOperationMessageType defines the API message for a single
+//operation of a transaction. Operation is one of get, set, delete or check.
+//If Version is set, the stored value must have this version, 0 means that
+//there must not be a stored value.
+type TransactionOperationMessageType struct {
+	Operation string  `json:"op"`
+	Realm     string  `json:"realm"`
+	Key       string  `json:"key"`
+	Value     string  `json:"value,omitempty"`
+	ExpiresIn int     `json:"expires-in,omitempty"`
+	Version   *uint64 `json:"version,omitempty"`
+}
+
+//TransactionMessageType defines the API message for transactions
+type TransactionMessageType struct {
+	Operations []TransactionOperationMessageType `json:"operations"`
+}
+
+//RateLimitMessageType defines the API message to count a request to a rate
+//limit. Window is given in seconds, Cost defaults to 1.
+type RateLimitMessageType struct {
+	Algorithm string `json:"algorithm"`
+	Limit     int64  `json:"limit"`
+	Window    int64  `json:"window"`
+	Cost      int64  `json:"cost,omitempty"`
+}
+
+//RateLimitResultMessageType defines the API response of rate limits. Reset
+//and RetryAfter are given in seconds. RetryAfter is only set, if the request
+//was not allowed.
+type RateLimitResultMessageType struct {
+	Allowed    bool  `json:"allowed"`
+	Limit      int64 `json:"limit"`
+	Remaining  int64 `json:"remaining"`
+	Reset      int64 `json:"reset"`
+	RetryAfter int64 `json:"retry-after,omitempty"`
+}
+
+//ScriptMessageType defines the API message to run a script. The script can
+//only access the given keys by their index and the given arguments.
+type ScriptMessageType struct {
+	Keys   []BatchEntryMessageType `json:"keys"`
+	Args   []string                `json:"args,omitempty"`
+	Script string                  `json:"script"`
+}
+
+//ScriptResultMessageType defines the API response of scripts. Result is the
+//value returned by the script, which is either nil, a bool, a float64 or a
+//string.
+type ScriptResultMessageType struct {
+	Result interface{} `json:"result"`
+}
+
+//LockRequestMessageType defines the API message to acquire, renew or release
+//locks. Lease is given in seconds, Token is ignored when acquiring a lock.
+type LockRequestMessageType struct {
+	Holder string `json:"holder"`
+	Token  uint64 `json:"token"`
+	Lease  int    `json:"lease"`
+}
+
+//LockMessageType defines the API message for acquired locks
+type LockMessageType struct {
+	Name      string `json:"name"`
+	Holder    string `json:"holder"`
+	Token     uint64 `json:"token"`
+	ExpiresIn int    `json:"expires-in"`
+}
+
+//KeyListMessageType defines the API message for lists of keys
+type KeyListMessageType struct {
+	Keys []string `json:"keys"`
+}
+
+//RealmListMessageType defines the API message for lists of realms
+type RealmListMessageType struct {
+	Realms []string `json:"realms"`
+}
+
+//RealmConfig holds the configuration of a realm. TTLs are given in seconds.
+//0 means, that there is no limit or default.
+type RealmConfig struct {
+	DefaultTTL     int      `json:"default-ttl"`
+	MaxTTL         int      `json:"max-ttl"`
+	MaxKeys        int      `json:"max-keys"`
+	MaxValueSize   int      `json:"max-value-size"`
+	EvictionPolicy string   `json:"eviction-policy,omitempty"`
+	Indexes        []string `json:"indexes,omitempty"`
+}
+
+//ScanMessageType defines the parameters of scans. Keys of Realm are scanned,
+//or all realms, if Realm is empty. Type filters keys by the media type of
+//their values. MinTTL and MaxTTL are given in seconds.
+type ScanMessageType struct {
+	Realm  string
+	Cursor string
+	Count  int
+	Match  string
+	Type   string
+	MinTTL int
+	MaxTTL int
+}
+
+//ScanResultMessageType defines the API message for a page of scanned keys or
+//realms. Cursor is empty, if there are no more keys or realms.
+type ScanResultMessageType struct {
+	Cursor string   `json:"cursor"`
+	Keys   []string `json:"keys,omitempty"`
+	Realms []string `json:"realms,omitempty"`
+}
+
+//QueryMessageType defines the parameters of queries by an indexed field.
+//Either Eq or Min and Max are used. Empty values are not set.
+type QueryMessageType struct {
+	Field  string
+	Eq     string
+	Min    string
+	Max    string
+	Count  int
+	Cursor string
+}
+
+//QueryResultMessageType defines the API message for a page of values found
+//by a query. Cursor is empty, if there are no more values.
+type QueryResultMessageType struct {
+	Cursor  string                   `json:"cursor"`
+	Results []BatchResultMessageType `json:"results"`
+}
+
+//EventMessageType defines the API message for keyspace notifications and
+//messages published to channels
+type EventMessageType struct {
+	Type    string            `json:"type"`
+	Realm   string            `json:"realm,omitempty"`
+	Key     string            `json:"key,omitempty"`
+	Value   *ValueMessageType `json:"value,omitempty"`
+	Config  *RealmConfig      `json:"config,omitempty"`
+	Channel string            `json:"channel,omitempty"`
+	Message string            `json:"message,omitempty"`
+}
+
+//PublishMessageType defines the API message to publish messages to channels
+type PublishMessageType struct {
+	Message string `json:"message"`
+}
+
+//PublishResultMessageType defines the API response for published messages
+type PublishResultMessageType struct {
+	Receivers int `json:"receivers"`
+}
+
+//MemoryMessageType defines the API message for the memory usage and evictions
+type MemoryMessageType struct {
+	UsedMemory     int64  `json:"used-memory"`
+	MaxMemory      int64  `json:"max-memory"`
+	EvictionPolicy string `json:"eviction-policy"`
+	Evictions      uint64 `json:"evictions"`
+	RejectedWrites uint64 `json:"rejected-writes"`
+}
+
+//InfoMessageType defines the API message for operational stats. Uptime is
+//given in seconds, rates are calculated over the last minute.
+type InfoMessageType struct {
+	Uptime               int64          `json:"uptime"`
+	Realms               int            `json:"realms"`
+	Keys                 int            `json:"keys"`
+	KeysPerRealm         map[string]int `json:"keys-per-realm"`
+	UsedMemory           int64          `json:"used-memory"`
+	MaxMemory            int64          `json:"max-memory"`
+	Hits                 uint64         `json:"hits"`
+	Misses               uint64         `json:"misses"`
+	HitRatio             float64        `json:"hit-ratio"`
+	Expirations          uint64         `json:"expirations"`
+	Evictions            uint64         `json:"evictions"`
+	RejectedWrites       uint64         `json:"rejected-writes"`
+	ExpirationsPerSecond float64        `json:"expirations-per-second"`
+	EvictionsPerSecond   float64        `json:"evictions-per-second"`
+	PendingTimers        int64          `json:"pending-timers"`
+}
+
+//ImportResultMessageType defines the API response for imported dumps
+type ImportResultMessageType struct {
+	Realms  int `json:"realms"`
+	Values  int `json:"values"`
+	Skipped int `json:"skipped"`
+}
+
+//ClusterNodesMessageType defines the API message to change the members of
+//the cluster
+type ClusterNodesMessageType struct {
+	Nodes []string `json:"nodes"`
+}
+
+//ClusterStatusMessageType defines the API message for the cluster status of
+//a node
+type ClusterStatusMessageType struct {
+	Self         string   `json:"self"`
+	Nodes        []string `json:"nodes"`
+	VirtualNodes int      `json:"virtual-nodes"`
+	Migrating    bool     `json:"migrating"`
+}
+
+//ReplicationStatusMessageType defines the API message for the replication
+//status of an instance
+type ReplicationStatusMessageType struct {
+	Role      string `json:"role"`
+	Primary   string `json:"primary,omitempty"`
+	Connected bool   `json:"connected"`
+	Synced    bool   `json:"synced"`
+	Replicas  int64  `json:"replicas"`
+}
@@ -0,0 +1,200 @@
+/*
+realms.go
+Implements all methods to manage realms and instances of the in-memory-db.
+
+###################################################################################
+
+MIT License
+
+Copyright (c) 2020 Bruno Hautzenberger
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+package client
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+)
+
+//Realms lists all realms.
+func (c *Client) Realms(ctx context.Context) ([]string, error) {
+	msg := RealmListMessageType{}
+	err := c.call(ctx, &request{Method: http.MethodGet, Path: "/realms", Retry: true}, &msg)
+	return msg.Realms, err
+}
+
+//GetRealmConfig returns the configuration of a realm.
+func (c *Client) GetRealmConfig(ctx context.Context, realm string) (*RealmConfig, error) {
+	config := &RealmConfig{}
+	err := c.call(ctx, &request{Method: http.MethodGet, Path: path("realms", realm), Retry: true}, config)
+	if err != nil {
+		return nil, err
+	}
+
+	return config, nil
+}
+
+//SetRealmConfig creates a realm or replaces its configuration.
+func (c *Client) SetRealmConfig(ctx context.Context, realm string, config RealmConfig) (*RealmConfig, error) {
+	result := &RealmConfig{}
+	err := c.call(ctx, &request{Method: http.MethodPut, Path: path("realms", realm), Body: config, Retry: true}, result)
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+//DeleteRealm deletes a realm including all of its values.
+func (c *Client) DeleteRealm(ctx context.Context, realm string) error {
+	if c.cache != nil {
+		defer c.cache.deleteRealm(realm)
+	}
+
+	err := c.call(ctx, &request{Method: http.MethodDelete, Path: path("realms", realm), Retry: true}, nil)
+	return err
+}
+
+//Memory returns the memory usage and eviction counters.
+func (c *Client) Memory(ctx context.Context) (*MemoryMessageType, error) {
+	msg := &MemoryMessageType{}
+	err := c.call(ctx, &request{Method: http.MethodGet, Path: "/memory", Retry: true}, msg)
+	if err != nil {
+		return nil, err
+	}
+
+	return msg, nil
+}
+
+//Info returns operational stats.
+func (c *Client) Info(ctx context.Context) (*InfoMessageType, error) {
+	msg := &InfoMessageType{}
+	err := c.call(ctx, &request{Method: http.MethodGet, Path: "/info", Retry: true}, msg)
+	if err != nil {
+		return nil, err
+	}
+
+	return msg, nil
+}
+
+//Metrics returns the stats in the Prometheus text format.
+func (c *Client) Metrics(ctx context.Context) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.Config.Timeout)
+	defer cancel()
+
+	resp, err := c.do(ctx, &request{Method: http.MethodGet, Path: "/metrics", Retry: true})
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	return string(body), err
+}
+
+//Export streams a dump of a realm, or of all realms, if realm is empty. The
+//format is either DumpFormatNDJSON or DumpFormatGob. The dump has to be
+//closed.
+func (c *Client) Export(ctx context.Context, realm string, format string) (io.ReadCloser, error) {
+	query := url.Values{}
+	setParam(query, "realm", realm)
+	setParam(query, "format", format)
+
+	resp, err := c.do(ctx, &request{Method: http.MethodGet, Path: "/export", Query: query, Retry: true})
+	if err != nil {
+		return nil, err
+	}
+
+	return resp.Body, nil
+}
+
+//Import imports a dump created by Export into a realm, or into all realms, if
+//realm is empty. Mode is either ImportModeMerge or ImportModeReplace. Imports
+//are never retried, because the dump can only be read once.
+func (c *Client) Import(ctx context.Context, dump io.Reader, realm string, mode string, format string) (*ImportResultMessageType, error) {
+	if c.cache != nil {
+		defer c.cache.clear()
+	}
+
+	query := url.Values{}
+	setParam(query, "realm", realm)
+	setParam(query, "mode", mode)
+	setParam(query, "format", format)
+
+	resp, err := c.do(ctx, &request{Method: http.MethodPost, Path: "/import", Query: query, Reader: dump, ContentType: "application/octet-stream"})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	result := &ImportResultMessageType{}
+	if err := decodeBody(resp, result); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+//ClusterStatus returns the cluster status of the node.
+func (c *Client) ClusterStatus(ctx context.Context) (*ClusterStatusMessageType, error) {
+	msg := &ClusterStatusMessageType{}
+	err := c.call(ctx, &request{Method: http.MethodGet, Path: "/cluster", Retry: true}, msg)
+	if err != nil {
+		return nil, err
+	}
+
+	return msg, nil
+}
+
+//SetClusterNodes changes the members of the cluster.
+func (c *Client) SetClusterNodes(ctx context.Context, nodes []string) (*ClusterStatusMessageType, error) {
+	msg := &ClusterStatusMessageType{}
+	err := c.call(ctx, &request{Method: http.MethodPut, Path: "/cluster", Body: ClusterNodesMessageType{Nodes: nodes}, Retry: true}, msg)
+	if err != nil {
+		return nil, err
+	}
+
+	return msg, nil
+}
+
+//ReplicationStatus returns the replication status of the instance.
+func (c *Client) ReplicationStatus(ctx context.Context) (*ReplicationStatusMessageType, error) {
+	msg := &ReplicationStatusMessageType{}
+	err := c.call(ctx, &request{Method: http.MethodGet, Path: "/replication", Retry: true}, msg)
+	if err != nil {
+		return nil, err
+	}
+
+	return msg, nil
+}
+
+//Promote promotes a replica to primary.
+func (c *Client) Promote(ctx context.Context) (*ReplicationStatusMessageType, error) {
+	msg := &ReplicationStatusMessageType{}
+	err := c.call(ctx, &request{Method: http.MethodPost, Path: "/replication/promote"}, msg)
+	if err != nil {
+		return nil, err
+	}
+
+	return msg, nil
+}
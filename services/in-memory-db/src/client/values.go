@@ -0,0 +1,251 @@
+/*
+values.go
+Implements all methods to get, set and delete values.
+
+###################################################################################
+
+MIT License
+
+Copyright (c) 2020 Bruno Hautzenberger
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+//Get returns the value of a key. It fails with ErrNotFound, if the key does
+//not exist. Values are served from the near-cache, if it is enabled.
+func (c *Client) Get(ctx context.Context, realm string, key string) (*ValueMessageType, error) {
+	if c.cache == nil {
+		value := &ValueMessageType{}
+		if err := c.call(ctx, &request{Method: http.MethodGet, Path: path("realms", realm, "keys", key), Retry: true}, value); err != nil {
+			return nil, err
+		}
+		return value, nil
+	}
+
+	if value, ok := c.cache.get(realm, key); ok {
+		return value, nil
+	}
+
+	// the value is not cached, if the key is changed while it is loaded
+	var loaded *ValueMessageType
+	generation := c.cache.begin(realm, key)
+	defer func() {
+		c.cache.finish(realm, key, generation, loaded)
+	}()
+
+	value := &ValueMessageType{}
+	if err := c.call(ctx, &request{Method: http.MethodGet, Path: path("realms", realm, "keys", key), Retry: true}, value); err != nil {
+		return nil, err
+	}
+	loaded = value
+
+	return value, nil
+}
+
+//Set stores a value, which expires in given number of seconds. If expiresIn
+//is 0, the default TTL of the realm is used.
+func (c *Client) Set(ctx context.Context, realm string, key string, value string, expiresIn int) (*ValueMessageType, error) {
+	return c.SetValue(ctx, realm, key, ValueMessageType{Value: value, ExpiresIn: expiresIn})
+}
+
+//SetBytes stores binary data with its content type. It is base64 encoded, if
+//it is no valid UTF-8.
+func (c *Client) SetBytes(ctx context.Context, realm string, key string, data []byte, contentType string, expiresIn int) (*ValueMessageType, error) {
+	return c.SetValue(ctx, realm, key, NewValueMessageType(data, contentType, expiresIn))
+}
+
+//SetValue stores a value with all of its fields.
+func (c *Client) SetValue(ctx context.Context, realm string, key string, value ValueMessageType) (*ValueMessageType, error) {
+	defer c.uncache(realm, key)
+
+	result := &ValueMessageType{}
+	err := c.call(ctx, &request{Method: http.MethodPut, Path: path("realms", realm, "keys", key), Body: value, Retry: true}, result)
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+//CompareAndSwap stores a value, if the stored value still has given version.
+//Version 0 means, that there must not be a stored value yet. It fails with
+//ErrPreconditionFailed otherwise.
+func (c *Client) CompareAndSwap(ctx context.Context, realm string, key string, version uint64, value string, expiresIn int) (*ValueMessageType, error) {
+	defer c.uncache(realm, key)
+
+	msg := CompareAndSwapMessageType{
+		Version:   version,
+		Value:     value,
+		ExpiresIn: expiresIn,
+	}
+
+	result := &ValueMessageType{}
+	err := c.call(ctx, &request{Method: http.MethodPost, Path: path("realms", realm, "keys", key, "cas"), Body: msg}, result)
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+//Delete deletes a key. It fails with ErrNotFound, if the key does not exist.
+func (c *Client) Delete(ctx context.Context, realm string, key string) error {
+	defer c.uncache(realm, key)
+
+	req := &request{Method: http.MethodDelete, Path: path("realms", realm, "keys", key), Retry: true}
+	err := c.call(ctx, req, nil)
+
+	// a retry finds no key, if the response of the first attempt was lost
+	if req.Attempts > 1 && errors.Is(err, ErrNotFound) {
+		return nil
+	}
+
+	return err
+}
+
+//Keys lists all keys of a realm.
+func (c *Client) Keys(ctx context.Context, realm string) ([]string, error) {
+	msg := KeyListMessageType{}
+	err := c.call(ctx, &request{Method: http.MethodGet, Path: path("realms", realm, "keys"), Retry: true}, &msg)
+	return msg.Keys, err
+}
+
+//Scan returns a page of keys of a realm, or of realms, if no realm is given.
+func (c *Client) Scan(ctx context.Context, scan ScanMessageType) (*ScanResultMessageType, error) {
+	query := url.Values{}
+	setParam(query, "realm", scan.Realm)
+	setParam(query, "cursor", scan.Cursor)
+	setParam(query, "match", scan.Match)
+	setParam(query, "type", scan.Type)
+	setIntParam(query, "count", scan.Count)
+	setIntParam(query, "min-ttl", scan.MinTTL)
+	setIntParam(query, "max-ttl", scan.MaxTTL)
+
+	result := &ScanResultMessageType{}
+	err := c.call(ctx, &request{Method: http.MethodGet, Path: "/scan", Query: query, Retry: true}, result)
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+//Query returns a page of values of a realm by an indexed field.
+func (c *Client) Query(ctx context.Context, realm string, q QueryMessageType) (*QueryResultMessageType, error) {
+	query := url.Values{}
+	setParam(query, "field", q.Field)
+	setParam(query, "eq", q.Eq)
+	setParam(query, "min", q.Min)
+	setParam(query, "max", q.Max)
+	setParam(query, "cursor", q.Cursor)
+	setIntParam(query, "count", q.Count)
+
+	result := &QueryResultMessageType{}
+	err := c.call(ctx, &request{Method: http.MethodGet, Path: path("realms", realm, "query"), Query: query, Retry: true}, result)
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+//MGet gets multiple values at once.
+func (c *Client) MGet(ctx context.Context, entries []BatchEntryMessageType) ([]BatchResultMessageType, error) {
+	return c.batch(ctx, "/mget", entries)
+}
+
+//MSet atomically sets multiple values.
+func (c *Client) MSet(ctx context.Context, entries []BatchEntryMessageType) ([]BatchResultMessageType, error) {
+	defer c.uncacheEntries(entries)
+	return c.batch(ctx, "/mset", entries)
+}
+
+//MDelete atomically deletes multiple values.
+func (c *Client) MDelete(ctx context.Context, entries []BatchEntryMessageType) ([]BatchResultMessageType, error) {
+	defer c.uncacheEntries(entries)
+	return c.batch(ctx, "/mdel", entries)
+}
+
+//batch sends a batch request, which can be retried, because applying it
+//twice has the same result.
+func (c *Client) batch(ctx context.Context, path string, entries []BatchEntryMessageType) ([]BatchResultMessageType, error) {
+	msg := BatchResultListMessageType{}
+	err := c.call(ctx, &request{Method: http.MethodPost, Path: path, Body: BatchMessageType{Entries: entries}, Retry: true}, &msg)
+	return msg.Results, err
+}
+
+//Transaction applies a list of operations all-or-nothing.
+func (c *Client) Transaction(ctx context.Context, operations []TransactionOperationMessageType) ([]BatchResultMessageType, error) {
+	defer func() {
+		for _, op := range operations {
+			if op.Operation != "get" && op.Operation != "check" {
+				c.uncache(op.Realm, op.Key)
+			}
+		}
+	}()
+
+	msg := BatchResultListMessageType{}
+	err := c.call(ctx, &request{Method: http.MethodPost, Path: "/transaction", Body: TransactionMessageType{Operations: operations}}, &msg)
+	return msg.Results, err
+}
+
+//Script runs a script atomically and returns its result. It fails with
+//ErrScriptFailed, if the script calls fail.
+func (c *Client) Script(ctx context.Context, script ScriptMessageType) (interface{}, error) {
+	defer c.uncacheEntries(script.Keys)
+
+	msg := ScriptResultMessageType{}
+	err := c.call(ctx, &request{Method: http.MethodPost, Path: "/script", Body: script}, &msg)
+	return msg.Result, err
+}
+
+//RateLimit counts a request to the rate limit stored in given realm using
+//given key. Denied requests are no error, but have Allowed set to false.
+func (c *Client) RateLimit(ctx context.Context, realm string, key string, limit RateLimitMessageType) (*RateLimitResultMessageType, error) {
+	defer c.uncache(realm, key)
+
+	result := &RateLimitResultMessageType{}
+	err := c.call(ctx, &request{Method: http.MethodPost, Path: path("realms", realm, "ratelimits", key), Body: limit, Accept: []int{http.StatusTooManyRequests}}, result)
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func setParam(query url.Values, name string, value string) {
+	if len(value) > 0 {
+		query.Set(name, value)
+	}
+}
+
+func setIntParam(query url.Values, name string, value int) {
+	if value > 0 {
+		query.Set(name, strconv.Itoa(value))
+	}
+}
@@ -0,0 +1,398 @@
+/*
+cluster.go
+Implements the cluster mode. Nodes form a ring using consistent hashing over
+realm/key. Requests for keys owned by other nodes are forwarded to them and
+keys are migrated to their new owners, whenever the membership changes.
+
+###################################################################################
+
+MIT License
+
+Copyright (c) 2020 Bruno Hautzenberger
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"log"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	//ClusterForwardedHeader marks requests forwarded by another node. They
+	//are always served locally, so requests are never forwarded twice.
+	ClusterForwardedHeader = "X-Cluster-Forwarded-By"
+
+	//clusterLocksRealm is the realm used to find the owner of locks.
+	clusterLocksRealm = "locks"
+
+	//migrationRetryDelay is the delay before a failed migration is retried.
+	migrationRetryDelay = 10 * time.Second
+)
+
+//ClusterInterface defines the interface for the cluster mode.
+type ClusterInterface interface {
+	Initialize(config ClusterConfig, storage StorageInterface) error
+	Enabled() bool
+	Owner(realm string, key string) string
+	IsLocal(realm string, key string) bool
+	Forward(w http.ResponseWriter, r *http.Request, node string)
+	Send(r *http.Request, nodes []string, method string, path string, body []byte)
+	Nodes() []string
+	SetNodes(nodes []string) error
+	Status() ClusterStatusMessageType
+}
+
+//vnode is a virtual node on the ring.
+type vnode struct {
+	Hash uint32
+	Node string
+}
+
+//Ring is a consistent hash ring. Every node is placed on the ring multiple
+//times, so keys are distributed evenly and only few keys move, if a node
+//joins or leaves.
+type Ring struct {
+	Nodes  []string
+	vnodes []vnode
+}
+
+//NewRing creates the ring of given nodes with given number of virtual nodes
+//per node.
+func NewRing(nodes []string, virtualNodes int) *Ring {
+	ring := &Ring{Nodes: nodes}
+
+	for _, node := range nodes {
+		for i := 0; i < virtualNodes; i++ {
+			ring.vnodes = append(ring.vnodes, vnode{
+				Hash: crc32.ChecksumIEEE([]byte(node + "#" + strconv.Itoa(i))),
+				Node: node,
+			})
+		}
+	}
+
+	sort.Slice(ring.vnodes, func(i, j int) bool {
+		return ring.vnodes[i].Hash < ring.vnodes[j].Hash
+	})
+
+	return ring
+}
+
+//Owner returns the node owning given realm and key. It is the first virtual
+//node following the hash of "realm/key" on the ring.
+func (r *Ring) Owner(realm string, key string) string {
+	if len(r.vnodes) == 0 {
+		return ""
+	}
+
+	hash := crc32.ChecksumIEEE([]byte(realm + "/" + key))
+	i := sort.Search(len(r.vnodes), func(i int) bool {
+		return r.vnodes[i].Hash >= hash
+	})
+
+	return r.vnodes[i%len(r.vnodes)].Node
+}
+
+//Cluster implements ClusterInterface. Migrations run in a single go routine,
+//which is triggered whenever the membership changes. Only requests for single
+//keys and locks are forwarded, so listing keys and realms, scans and queries
+//return the partial results of the node serving them.
+type Cluster struct {
+	Config    ClusterConfig
+	Storage   StorageInterface
+	Client    *http.Client
+	Migrating bool
+	MutexLock sync.RWMutex
+	ring      *Ring
+	trigger   chan struct{}
+}
+
+//Initialize sets the configuration and creates the ring of the configured
+//nodes. The cluster mode is disabled, if there are no nodes.
+func (c *Cluster) Initialize(config ClusterConfig, storage StorageInterface) error {
+	c.Config = config
+	c.Storage = storage
+	c.Client = &http.Client{Timeout: time.Minute}
+	c.ring = NewRing(nil, config.VirtualNodes)
+	c.trigger = make(chan struct{}, 1)
+
+	if !c.Enabled() {
+		return nil
+	}
+
+	go c.migrations()
+
+	return c.SetNodes(config.Nodes)
+}
+
+//Enabled returns true, if this instance is part of a cluster.
+func (c *Cluster) Enabled() bool {
+	return len(c.Config.Self) > 0
+}
+
+//Owner returns the node owning given realm and key.
+func (c *Cluster) Owner(realm string, key string) string {
+	c.MutexLock.RLock()
+	defer c.MutexLock.RUnlock()
+
+	return c.ring.Owner(realm, key)
+}
+
+//IsLocal returns true, if the cluster mode is disabled or this node owns
+//given realm and key.
+func (c *Cluster) IsLocal(realm string, key string) bool {
+	return !c.Enabled() || c.Owner(realm, key) == c.Config.Self
+}
+
+//Forward forwards the request to given node and writes its response.
+func (c *Cluster) Forward(w http.ResponseWriter, r *http.Request, node string) {
+	target, err := url.Parse(node)
+	if err != nil {
+		RaiseError(w, fmt.Sprintf("Invalid node %v", node), http.StatusBadGateway, ErrorCodeNodeUnavailable)
+		return
+	}
+
+	proxy := httputil.NewSingleHostReverseProxy(target)
+	proxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+		RaiseError(w, fmt.Sprintf("Node %v is unavailable", node), http.StatusBadGateway, ErrorCodeNodeUnavailable)
+	}
+
+	r.Header.Set(ClusterForwardedHeader, c.Config.Self)
+	r.Host = target.Host
+
+	proxy.ServeHTTP(w, r)
+}
+
+//Send sends a request to all given nodes except this node. It is used for
+//changes, which apply to all nodes, like realm configurations. The
+//authorization of the original request is passed on. Failures are only
+//logged.
+func (c *Cluster) Send(r *http.Request, nodes []string, method string, path string, body []byte) {
+	for _, node := range nodes {
+		if node == c.Config.Self {
+			continue
+		}
+
+		req, err := http.NewRequest(method, strings.TrimSuffix(node, "/")+path, bytes.NewReader(body))
+		if err != nil {
+			log.Printf("Sending %v %v to %v failed: %v\n", method, path, node, err)
+			continue
+		}
+
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", r.Header.Get("Authorization"))
+		req.Header.Set(ClusterForwardedHeader, c.Config.Self)
+
+		resp, err := c.Client.Do(req)
+		if err != nil {
+			log.Printf("Sending %v %v to %v failed: %v\n", method, path, node, err)
+			continue
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode >= 300 && resp.StatusCode != http.StatusNotFound {
+			log.Printf("Sending %v %v to %v failed with status %v\n", method, path, node, resp.StatusCode)
+		}
+	}
+}
+
+//Nodes returns the members of the cluster.
+func (c *Cluster) Nodes() []string {
+	c.MutexLock.RLock()
+	defer c.MutexLock.RUnlock()
+
+	return c.ring.Nodes
+}
+
+//SetNodes replaces the members of the cluster and migrates all keys, which
+//are owned by other nodes now.
+func (c *Cluster) SetNodes(nodes []string) error {
+	for _, node := range nodes {
+		if u, err := url.Parse(node); err != nil || len(u.Scheme) == 0 || len(u.Host) == 0 {
+			return fmt.Errorf("Invalid node %v", node)
+		}
+	}
+
+	c.MutexLock.Lock()
+	c.ring = NewRing(nodes, c.Config.VirtualNodes)
+	c.MutexLock.Unlock()
+
+	log.Printf("Cluster nodes: %v\n", strings.Join(nodes, ", "))
+
+	select {
+	case c.trigger <- struct{}{}:
+	default:
+	}
+
+	return nil
+}
+
+//Status returns the cluster status of this node.
+func (c *Cluster) Status() ClusterStatusMessageType {
+	c.MutexLock.RLock()
+	defer c.MutexLock.RUnlock()
+
+	return ClusterStatusMessageType{
+		Self:         c.Config.Self,
+		Nodes:        c.ring.Nodes,
+		VirtualNodes: c.Config.VirtualNodes,
+		Migrating:    c.Migrating,
+	}
+}
+
+//setMigrating sets whether a migration is running.
+func (c *Cluster) setMigrating(migrating bool) {
+	c.MutexLock.Lock()
+	defer c.MutexLock.Unlock()
+
+	c.Migrating = migrating
+}
+
+//migrations runs a migration whenever it is triggered. Failed migrations
+//are retried after a delay.
+func (c *Cluster) migrations() {
+	for range c.trigger {
+		c.setMigrating(true)
+		err := c.migrate()
+		c.setMigrating(false)
+
+		if err != nil {
+			log.Printf("Migration failed: %v. Retrying in %v\n", err, migrationRetryDelay)
+			time.AfterFunc(migrationRetryDelay, func() {
+				select {
+				case c.trigger <- struct{}{}:
+				default:
+				}
+			})
+		}
+	}
+}
+
+//migrate sends all keys and locks, which are owned by other nodes, to their
+//owners and deletes them afterwards, unless they were changed in the
+//meantime. The last fencing token is sent to all other nodes, so tokens keep
+//increasing across the ring, when locks move to another node.
+func (c *Cluster) migrate() error {
+	configs := make([]*Event, 0)
+	dumps := make(map[string][]*Event)
+	locks := make(map[string][]*Event)
+
+	for _, event := range c.Storage.Export("") {
+		if event.Type == EventTypeConfigure {
+			configs = append(configs, event)
+			continue
+		}
+
+		if owner := c.Owner(event.Realm, event.Key); owner != c.Config.Self {
+			dumps[owner] = append(dumps[owner], event)
+		}
+	}
+
+	var token *Event
+	for _, event := range c.Storage.ExportLocks() {
+		if event.Type == EventTypeToken {
+			token = event
+			continue
+		}
+
+		if owner := c.Owner(clusterLocksRealm, event.Key); owner != c.Config.Self {
+			locks[owner] = append(locks[owner], event)
+		}
+	}
+
+	var failed error
+	for _, node := range c.Nodes() {
+		if node == c.Config.Self {
+			continue
+		}
+
+		events := dumps[node]
+		dump := append(append(append([]*Event{}, configs...), events...), locks[node]...)
+		if err := c.send(node, append(dump, token)); err != nil {
+			failed = err
+			continue
+		}
+
+		c.Storage.Transaction(func(tx *Transaction) error {
+			for _, event := range events {
+				if _, current := tx.Get(event.Realm, event.Key); current != nil && current.Version == event.Value.Version {
+					tx.Delete(event.Realm, event.Key)
+				}
+			}
+			return nil
+		})
+
+		// locks, which were released and acquired again in the meantime, are kept
+		for _, event := range locks[node] {
+			c.Storage.ReleaseLock(event.Key, event.Lock.Holder, event.Lock.Token)
+		}
+
+		if len(events) > 0 || len(locks[node]) > 0 {
+			log.Printf("Migrated %v keys and %v locks to %v\n", len(events), len(locks[node]), node)
+		}
+	}
+
+	return failed
+}
+
+//send imports given events into the node using the import api. Only keys,
+//which do not exist on the node yet, are imported, so writes the node already
+//accepted as new owner are never overwritten.
+func (c *Cluster) send(node string, events []*Event) error {
+	body := &bytes.Buffer{}
+	encoder := json.NewEncoder(body)
+	for _, event := range events {
+		encoder.Encode(event.ToReplicationMessageType())
+	}
+
+	req, err := http.NewRequest(http.MethodPost, strings.TrimSuffix(node, "/")+"/v1/import?mode=missing", body)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", DumpFormatNDJSON.ContentType())
+	req.Header.Set(ClusterForwardedHeader, c.Config.Self)
+	if len(c.Config.Token) > 0 {
+		req.Header.Set("Authorization", "Bearer "+c.Config.Token)
+	}
+
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%v responded with status %v", node, resp.StatusCode)
+	}
+
+	return nil
+}
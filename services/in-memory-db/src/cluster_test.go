@@ -0,0 +1,179 @@
+/*
+cluster_test.go
+Tests of the migration of keys, locks and fencing tokens between nodes.
+
+###################################################################################
+
+MIT License
+
+Copyright (c) 2020 Bruno Hautzenberger
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+//newTestNode starts a node, which imports migrated dumps into given storage
+//like the import api.
+func newTestNode(t *testing.T, s *Storage) *httptest.Server {
+	t.Helper()
+
+	node := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		dump := make([]*Event, 0)
+		decoder := json.NewDecoder(r.Body)
+		for {
+			msg := ReplicationMessageType{}
+			if err := decoder.Decode(&msg); err == io.EOF {
+				break
+			} else if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+
+			event, err := EventFromReplicationMessageType(msg)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			dump = append(dump, event)
+		}
+
+		if err := s.Import(dump, "", ImportMode(r.URL.Query().Get("mode"))); err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+	}))
+	t.Cleanup(node.Close)
+
+	return node
+}
+
+//newTestCluster creates the cluster of self and given node without starting
+//migrations.
+func newTestCluster(s *Storage, self string, node *httptest.Server) *Cluster {
+	return &Cluster{
+		Config:  ClusterConfig{Self: self, VirtualNodes: 16},
+		Storage: s,
+		Client:  node.Client(),
+		ring:    NewRing([]string{self, node.URL}, 16),
+	}
+}
+
+//ownedLockName returns a lock name, which is owned by given node.
+func ownedLockName(t *testing.T, c *Cluster, node string) string {
+	t.Helper()
+
+	for i := 0; i < 1000; i++ {
+		if name := fmt.Sprintf("lock-%v", i); c.Owner(clusterLocksRealm, name) == node {
+			return name
+		}
+	}
+
+	t.Fatalf("no lock name is owned by %v", node)
+	return ""
+}
+
+func TestMigrateLocks(t *testing.T) {
+	a := newTestStorage(t, StorageConfig{})
+	b := newTestStorage(t, StorageConfig{})
+	node := newTestNode(t, b)
+	c := newTestCluster(a, "http://self", node)
+
+	moved := ownedLockName(t, c, node.URL)
+	kept := ownedLockName(t, c, "http://self")
+	for i := 0; i < 5; i++ {
+		a.AcquireLock("other", "x", time.Minute)
+		a.ReleaseLock("other", "x", a.LastToken)
+	}
+	_, lock := a.AcquireLock(moved, "x", time.Minute)
+	a.AcquireLock(kept, "y", time.Minute)
+
+	if err := c.migrate(); err != nil {
+		t.Fatalf("migrate failed: %v", err)
+	}
+
+	if ok, migrated := b.GetLock(moved); !ok || migrated.Holder != "x" || migrated.Token != lock.Token {
+		t.Errorf("GetLock on new owner = %v, %+v, expected lock of x with token %v", ok, migrated, lock.Token)
+	}
+
+	if ok, _ := a.GetLock(moved); ok {
+		t.Errorf("migrated lock is still acquired on the old owner")
+	}
+
+	if ok, _ := a.GetLock(kept); !ok {
+		t.Errorf("lock of the old owner was migrated")
+	}
+
+	if ok, _ := b.GetLock(kept); ok {
+		t.Errorf("lock of the old owner was sent to the new owner")
+	}
+
+	// tokens of the new owner continue after the tokens of the old owner
+	if b.LastToken != a.LastToken {
+		t.Errorf("last token of the new owner is %v, expected %v", b.LastToken, a.LastToken)
+	}
+}
+
+func TestMigrateKeepsLocksOfNewOwner(t *testing.T) {
+	a := newTestStorage(t, StorageConfig{})
+	b := newTestStorage(t, StorageConfig{})
+	node := newTestNode(t, b)
+	c := newTestCluster(a, "http://self", node)
+
+	name := ownedLockName(t, c, node.URL)
+	a.AcquireLock(name, "x", time.Minute)
+	b.AcquireLock(name, "y", time.Minute)
+
+	if err := c.migrate(); err != nil {
+		t.Fatalf("migrate failed: %v", err)
+	}
+
+	if ok, lock := b.GetLock(name); !ok || lock.Holder != "y" {
+		t.Errorf("GetLock on new owner = %v, %+v, expected lock of y", ok, lock)
+	}
+}
+
+func TestMigrateTokenWithoutLocks(t *testing.T) {
+	a := newTestStorage(t, StorageConfig{})
+	b := newTestStorage(t, StorageConfig{})
+	node := newTestNode(t, b)
+	c := newTestCluster(a, "http://self", node)
+
+	a.AcquireLock("backup", "x", time.Minute)
+	a.AcquireLock("cleanup", "x", time.Minute)
+	a.ReleaseLock("backup", "x", 1)
+	a.ReleaseLock("cleanup", "x", 2)
+
+	if err := c.migrate(); err != nil {
+		t.Fatalf("migrate failed: %v", err)
+	}
+
+	if _, lock := b.AcquireLock("backup", "y", time.Minute); lock.Token != 3 {
+		t.Errorf("token on the other node is %v, expected 3", lock.Token)
+	}
+}
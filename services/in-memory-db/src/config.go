@@ -0,0 +1,251 @@
+/*
+config.go
+Defines the configuration of the storage, which is read from env vars.
+
+###################################################################################
+
+MIT License
+
+Copyright (c) 2020 Bruno Hautzenberger
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//StorageConfig holds the configuration of the storage.
+//MaxMemory is the approximate number of bytes the storage may use, 0 means
+//there is no limit. EvictionPolicy defines what happens, if this limit is
+//reached.
+type StorageConfig struct {
+	MaxMemory      int64
+	EvictionPolicy EvictionPolicy
+}
+
+//StorageConfigFromEnv reads the storage configuration from the env vars
+//MAX_MEMORY (e.g. 256MB) and EVICTION_POLICY (e.g. lru).
+func StorageConfigFromEnv() (StorageConfig, error) {
+	config := StorageConfig{
+		EvictionPolicy: EvictionPolicyNoEviction,
+	}
+
+	if value := os.Getenv("MAX_MEMORY"); len(value) > 0 {
+		maxMemory, err := parseByteSize(value)
+		if err != nil {
+			return config, fmt.Errorf("Invalid MAX_MEMORY: %v", err)
+		}
+		config.MaxMemory = maxMemory
+	}
+
+	if value := os.Getenv("EVICTION_POLICY"); len(value) > 0 {
+		policy := EvictionPolicy(strings.ToLower(value))
+		if !policy.IsValid() {
+			return config, fmt.Errorf("Invalid EVICTION_POLICY: %v", value)
+		}
+		config.EvictionPolicy = policy
+	}
+
+	return config, nil
+}
+
+//AccessConfig holds the configuration of the access control.
+//AuthURL is the base url of the auth service. Access control is disabled, if
+//it is empty, which requires AUTH_DISABLED. CacheTTL is the maximum duration
+//verified tokens are cached.
+type AccessConfig struct {
+	AuthURL  string
+	CacheTTL time.Duration
+}
+
+//AccessConfigFromEnv reads the access configuration from the env vars AUTH_URL
+//and AUTH_CACHE_TTL (seconds, defaults to 30). AUTH_URL is required, unless
+//access control is disabled explicitly by setting AUTH_DISABLED to true, so a
+//missing AUTH_URL never turns off access control silently.
+func AccessConfigFromEnv() (AccessConfig, error) {
+	config := AccessConfig{
+		AuthURL:  os.Getenv("AUTH_URL"),
+		CacheTTL: 30 * time.Second,
+	}
+
+	disabled := false
+	if value := os.Getenv("AUTH_DISABLED"); len(value) > 0 {
+		var err error
+		if disabled, err = strconv.ParseBool(value); err != nil {
+			return config, fmt.Errorf("Invalid AUTH_DISABLED: %v", value)
+		}
+	}
+
+	if len(config.AuthURL) == 0 && !disabled {
+		return config, fmt.Errorf("AUTH_URL is not set. Set AUTH_DISABLED=true to run without access control")
+	}
+
+	if len(config.AuthURL) > 0 && disabled {
+		return config, fmt.Errorf("AUTH_URL is set, but access control is disabled by AUTH_DISABLED")
+	}
+
+	if value := os.Getenv("AUTH_CACHE_TTL"); len(value) > 0 {
+		seconds, err := strconv.Atoi(value)
+		if err != nil || seconds < 0 {
+			return config, fmt.Errorf("Invalid AUTH_CACHE_TTL: %v", value)
+		}
+		config.CacheTTL = time.Duration(seconds) * time.Second
+	}
+
+	return config, nil
+}
+
+//ReplicationConfig holds the configuration of the replication.
+//PrimaryURL is the base url of the primary to follow. This instance is a
+//primary itself, if it is empty. Token is sent as bearer token to the
+//primary, if it uses access control.
+type ReplicationConfig struct {
+	PrimaryURL string
+	Token      string
+}
+
+//ReplicationConfigFromEnv reads the replication configuration from the env
+//vars REPLICA_OF and REPLICA_TOKEN.
+func ReplicationConfigFromEnv() ReplicationConfig {
+	return ReplicationConfig{
+		PrimaryURL: os.Getenv("REPLICA_OF"),
+		Token:      os.Getenv("REPLICA_TOKEN"),
+	}
+}
+
+//ClusterConfig holds the configuration of the cluster mode. Self is the base
+//url of this node, as it is reachable by the other nodes. Nodes are the base
+//urls of all nodes of the cluster. Token is sent as bearer token to migrate
+//keys to other nodes, if they use access control. The cluster mode is
+//disabled, if Self is empty.
+type ClusterConfig struct {
+	Self         string
+	Nodes        []string
+	Token        string
+	VirtualNodes int
+}
+
+//ClusterConfigFromEnv reads the cluster configuration from the env vars
+//CLUSTER_SELF, CLUSTER_NODES, CLUSTER_TOKEN and CLUSTER_VIRTUAL_NODES.
+func ClusterConfigFromEnv() (ClusterConfig, error) {
+	config := ClusterConfig{
+		Self:         strings.TrimSpace(os.Getenv("CLUSTER_SELF")),
+		Token:        os.Getenv("CLUSTER_TOKEN"),
+		VirtualNodes: 64,
+	}
+
+	for _, node := range strings.Split(os.Getenv("CLUSTER_NODES"), ",") {
+		if node = strings.TrimSpace(node); len(node) > 0 {
+			config.Nodes = append(config.Nodes, node)
+		}
+	}
+
+	if len(config.Nodes) > 0 && len(config.Self) == 0 {
+		return config, fmt.Errorf("CLUSTER_SELF is required, if CLUSTER_NODES is set")
+	}
+
+	if value := os.Getenv("CLUSTER_VIRTUAL_NODES"); len(value) > 0 {
+		virtualNodes, err := strconv.Atoi(value)
+		if err != nil || virtualNodes <= 0 {
+			return config, fmt.Errorf("Invalid CLUSTER_VIRTUAL_NODES: %v", value)
+		}
+		config.VirtualNodes = virtualNodes
+	}
+
+	return config, nil
+}
+
+//PersistenceConfig holds the configuration of snapshots and the shutdown.
+//SnapshotPath is the file the storage is written to on shutdown and loaded
+//from on startup. Snapshots are disabled, if it is empty. ShutdownTimeout is
+//the maximum duration in-flight requests are waited for on shutdown.
+type PersistenceConfig struct {
+	SnapshotPath    string
+	SnapshotFormat  DumpFormat
+	ShutdownTimeout time.Duration
+}
+
+//PersistenceConfigFromEnv reads the persistence configuration from the env
+//vars SNAPSHOT_PATH, SNAPSHOT_FORMAT (ndjson or gob, defaults to ndjson) and
+//SHUTDOWN_TIMEOUT (seconds, defaults to 30).
+func PersistenceConfigFromEnv() (PersistenceConfig, error) {
+	config := PersistenceConfig{
+		SnapshotPath:    strings.TrimSpace(os.Getenv("SNAPSHOT_PATH")),
+		SnapshotFormat:  DumpFormatNDJSON,
+		ShutdownTimeout: 30 * time.Second,
+	}
+
+	if value := os.Getenv("SNAPSHOT_FORMAT"); len(value) > 0 {
+		format := DumpFormat(strings.ToLower(value))
+		if format != DumpFormatNDJSON && format != DumpFormatGob {
+			return config, fmt.Errorf("Invalid SNAPSHOT_FORMAT: %v", value)
+		}
+		config.SnapshotFormat = format
+	}
+
+	if value := os.Getenv("SHUTDOWN_TIMEOUT"); len(value) > 0 {
+		seconds, err := strconv.Atoi(value)
+		if err != nil || seconds < 0 {
+			return config, fmt.Errorf("Invalid SHUTDOWN_TIMEOUT: %v", value)
+		}
+		config.ShutdownTimeout = time.Duration(seconds) * time.Second
+	}
+
+	return config, nil
+}
+
+//parseByteSize parses sizes like 1024, 512KB, 256MB or 1GB to bytes.
+func parseByteSize(value string) (int64, error) {
+	units := []struct {
+		suffix     string
+		multiplier int64
+	}{
+		{"GB", 1024 * 1024 * 1024},
+		{"MB", 1024 * 1024},
+		{"KB", 1024},
+		{"B", 1},
+	}
+
+	value = strings.ToUpper(strings.TrimSpace(value))
+	multiplier := int64(1)
+	for _, unit := range units {
+		if strings.HasSuffix(value, unit.suffix) {
+			value = strings.TrimSpace(strings.TrimSuffix(value, unit.suffix))
+			multiplier = unit.multiplier
+			break
+		}
+	}
+
+	size, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+
+	if size < 0 {
+		return 0, fmt.Errorf("size must not be negative")
+	}
+
+	return size * multiplier, nil
+}
@@ -0,0 +1,262 @@
+/*
+dump.go
+Implements exporting and importing dumps of realms. Dumps contain the same
+records as the replication stream, encoded as NDJSON or gob.
+
+###################################################################################
+
+MIT License
+
+Copyright (c) 2020 Bruno Hautzenberger
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+package main
+
+import (
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"io"
+	"time"
+)
+
+//DumpFormat defines how records of dumps are encoded.
+type DumpFormat string
+
+const (
+	//DumpFormatNDJSON encodes every record as JSON on its own line.
+	DumpFormatNDJSON DumpFormat = "ndjson"
+
+	//DumpFormatGob encodes all records as a compact binary gob stream.
+	DumpFormatGob DumpFormat = "gob"
+)
+
+//ImportMode defines how an import treats existing values.
+type ImportMode string
+
+const (
+	//ImportModeMerge overwrites existing values with the same keys.
+	ImportModeMerge ImportMode = "merge"
+
+	//ImportModeReplace deletes all values and configurations first.
+	ImportModeReplace ImportMode = "replace"
+
+	//ImportModeMissing only imports values and configurations, which do not
+	//exist yet. Migrations use it, so they never overwrite newer writes.
+	ImportModeMissing ImportMode = "missing"
+)
+
+//IsValid checks if this is a known import mode.
+func (m ImportMode) IsValid() bool {
+	switch m {
+	case ImportModeMerge, ImportModeReplace, ImportModeMissing:
+		return true
+	}
+
+	return false
+}
+
+//ErrInvalidDumpFormat is returned for unknown dump formats.
+var ErrInvalidDumpFormat = errors.New("Invalid dump format")
+
+//DumpEncoder encodes records of dumps.
+type DumpEncoder interface {
+	Encode(v interface{}) error
+}
+
+//DumpDecoder decodes records of dumps.
+type DumpDecoder interface {
+	Decode(v interface{}) error
+}
+
+//ContentType returns the content type of dumps in this format.
+func (f DumpFormat) ContentType() string {
+	if f == DumpFormatGob {
+		return "application/x-gob"
+	}
+
+	return "application/x-ndjson"
+}
+
+//NewDumpEncoder creates an encoder, which writes records in this format.
+func (f DumpFormat) NewDumpEncoder(w io.Writer) (DumpEncoder, error) {
+	switch f {
+	case DumpFormatNDJSON:
+		return json.NewEncoder(w), nil
+	case DumpFormatGob:
+		return gob.NewEncoder(w), nil
+	}
+
+	return nil, ErrInvalidDumpFormat
+}
+
+//NewDumpDecoder creates a decoder, which reads records in this format.
+func (f DumpFormat) NewDumpDecoder(r io.Reader) (DumpDecoder, error) {
+	switch f {
+	case DumpFormatNDJSON:
+		return json.NewDecoder(r), nil
+	case DumpFormatGob:
+		return gob.NewDecoder(r), nil
+	}
+
+	return nil, ErrInvalidDumpFormat
+}
+
+//Export returns the configurations and values of given realm as events. All
+//realms are exported, if realm is empty.
+func (s *Storage) Export(realm string) []*Event {
+	s.MutexLock.RLock()
+	defer s.MutexLock.RUnlock()
+
+	return s.export(realm)
+}
+
+//Unlike Export, this variant does not lock the storage. It returns the
+//configurations and values of given realm, or of all realms if realm is
+//empty, as events. Configurations come first, so they apply to the values,
+//when they are imported.
+func (s *Storage) export(realm string) []*Event {
+	events := make([]*Event, 0)
+	for realmName, config := range s.RealmConfigs {
+		if len(realm) == 0 || realmName == realm {
+			events = append(events, &Event{Type: EventTypeConfigure, Realm: realmName, Config: config})
+		}
+	}
+
+	for realmName, values := range s.Data {
+		if len(realm) > 0 && realmName != realm {
+			continue
+		}
+
+		for key, value := range values {
+			events = append(events, &Event{Type: EventTypeSet, Realm: realmName, Key: key, Value: value})
+		}
+	}
+
+	return events
+}
+
+//Import loads the configurations, values and locks of a dump using given
+//mode. With ImportModeReplace all values and configurations of given realm, or
+//of all realms if realm is empty, are deleted first. The values are written in
+//a single transaction, so nothing is changed if the import fails.
+func (s *Storage) Import(dump []*Event, realm string, mode ImportMode) error {
+	s.MutexLock.Lock()
+	defer s.MutexLock.Unlock()
+
+	replaced := func(realmName string) bool {
+		return mode == ImportModeReplace && (len(realm) == 0 || realmName == realm)
+	}
+
+	// configurations are needed to check the values, but are only kept and
+	// published, if the transaction is committed
+	configs := make(map[string]*RealmConfig, len(s.RealmConfigs))
+	for realmName, config := range s.RealmConfigs {
+		configs[realmName] = config
+		if replaced(realmName) {
+			delete(s.RealmConfigs, realmName)
+		}
+	}
+
+	imported := make([]*Event, 0)
+	for _, event := range dump {
+		if event.Type != EventTypeConfigure {
+			continue
+		}
+
+		if _, ok := s.RealmConfigs[event.Realm]; ok && mode == ImportModeMissing {
+			continue
+		}
+
+		s.RealmConfigs[event.Realm] = event.Config
+		imported = append(imported, event)
+	}
+
+	tx := s.newTransaction()
+	for realmName, values := range s.Data {
+		if replaced(realmName) {
+			for key := range values {
+				tx.Delete(realmName, key)
+			}
+		}
+	}
+
+	for _, event := range dump {
+		if event.Type != EventTypeSet {
+			continue
+		}
+
+		if ok, _ := tx.Get(event.Realm, event.Key); ok && mode == ImportModeMissing {
+			continue
+		}
+
+		if err := tx.Set(event.Realm, event.Key, event.Value); err != nil {
+			s.RealmConfigs = configs
+			return err
+		}
+	}
+
+	if err := tx.commit(); err != nil {
+		s.RealmConfigs = configs
+		return err
+	}
+
+	for realmName := range configs {
+		if _, ok := s.RealmConfigs[realmName]; !ok {
+			s.unconfigureRealm(realmName)
+		}
+	}
+
+	for _, event := range imported {
+		s.CreateRealm(event.Realm)
+		s.buildIndex(event.Realm)
+		s.notifyRealm(EventTypeConfigure, event.Realm, event.Config)
+	}
+
+	s.importLocks(dump, mode)
+
+	return nil
+}
+
+//importLocks stores the locks of a dump and raises the last fencing token to
+//the tokens of the dump, so tokens never decrease. Locks, which are acquired
+//already, are kept with ImportModeMissing.
+func (s *Storage) importLocks(dump []*Event, mode ImportMode) {
+	now := time.Now().UTC()
+	for _, event := range dump {
+		switch event.Type {
+		case EventTypeLock:
+			if _, ok := s.activeLock(event.Key, now); ok && mode == ImportModeMissing {
+				continue
+			}
+
+			s.storeLock(event.Lock)
+		case EventTypeToken:
+			if event.Token > s.LastToken {
+				s.LastToken = event.Token
+				s.Events.Publish(&Event{
+					Type:  EventTypeToken,
+					Topic: "/",
+					Token: s.LastToken,
+				})
+			}
+		}
+	}
+}
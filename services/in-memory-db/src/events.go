@@ -0,0 +1,196 @@
+/*
+events.go
+Implements a simple publish/subscribe event bus. It is used to notify
+subscribers about changes in the storage (keyspace notifications) as well as
+to deliver messages published to named channels.
+
+###################################################################################
+
+MIT License
+
+Copyright (c) 2020 Bruno Hautzenberger
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+package main
+
+import (
+	"log"
+	"path"
+	"sync"
+)
+
+//EventType defines the type of an Event
+type EventType string
+
+const (
+	EventTypeSet       EventType = "set"
+	EventTypeDelete              = "delete"
+	EventTypeExpire              = "expire"
+	EventTypeEvict               = "evict"
+	EventTypeConfigure           = "configure"
+	EventTypeDrop                = "drop"
+	EventTypeMessage             = "message"
+)
+
+//subscriptionBufferSize is the number of events buffered for a single
+//subscription, before slow subscribers are disconnected.
+const subscriptionBufferSize = 1024
+
+//Event holds all information about a change of a value, realm or lock or a
+//message published to a channel. Topic is either "realm/key", "realm/" for
+//realms, "/" for locks and counters or the channel name.
+type Event struct {
+	Type    EventType
+	Topic   string
+	Realm   string
+	Key     string
+	Value   *Value
+	Config  *RealmConfig
+	Lock    *Lock
+	Message string
+	Token   uint64
+	Version uint64
+}
+
+//ToEventMessageType transforms an Event to an EventMessageType that can be
+//converted to json and served via the api.
+func (e *Event) ToEventMessageType() EventMessageType {
+	msg := EventMessageType{
+		Type:    e.Type,
+		Realm:   e.Realm,
+		Key:     e.Key,
+		Config:  e.Config,
+		Message: e.Message,
+	}
+
+	if e.Type == EventTypeMessage {
+		msg.Channel = e.Topic
+	}
+
+	if e.Value != nil {
+		value := e.Value.ToValueMessageType()
+		msg.Value = &value
+	}
+
+	return msg
+}
+
+//Subscription receives all events with topics matching its pattern.
+//Overflow is closed, if an event could not be delivered, because the
+//subscriber did not keep up, or if the event bus was closed. Subscribers have
+//to stop reading events then, so they do not miss events silently.
+type Subscription struct {
+	Pattern      string
+	Events       chan *Event
+	Overflow     chan struct{}
+	overflowOnce sync.Once
+}
+
+//EventBus delivers published events to all matching subscriptions.
+type EventBus struct {
+	Subscriptions map[*Subscription]bool
+	MutexLock     sync.RWMutex
+	closed        bool
+}
+
+//Initialize creates an empty set of subscriptions.
+func (b *EventBus) Initialize() {
+	b.Subscriptions = make(map[*Subscription]bool)
+}
+
+//ValidatePattern checks if given pattern is a valid topic pattern. Patterns
+//use the syntax of path.Match, so "myrealm/*" matches all keys of a realm.
+func ValidatePattern(pattern string) error {
+	_, err := path.Match(pattern, "")
+	return err
+}
+
+//Subscribe creates a new subscription for all topics matching given pattern.
+func (b *EventBus) Subscribe(pattern string) *Subscription {
+	b.MutexLock.Lock()
+	defer b.MutexLock.Unlock()
+
+	subscription := &Subscription{
+		Pattern:  pattern,
+		Events:   make(chan *Event, subscriptionBufferSize),
+		Overflow: make(chan struct{}),
+	}
+	b.Subscriptions[subscription] = true
+
+	if b.closed {
+		subscription.close()
+	}
+
+	return subscription
+}
+
+//Unsubscribe removes a subscription, so it does not receive any more events.
+func (b *EventBus) Unsubscribe(subscription *Subscription) {
+	b.MutexLock.Lock()
+	defer b.MutexLock.Unlock()
+
+	delete(b.Subscriptions, subscription)
+}
+
+//Publish delivers an event to all subscriptions matching its topic and returns
+//the number of subscriptions that received it. Publish never blocks, instead
+//subscriptions that do not keep up are marked as overflowed.
+func (b *EventBus) Publish(event *Event) int {
+	b.MutexLock.RLock()
+	defer b.MutexLock.RUnlock()
+
+	received := 0
+	for subscription := range b.Subscriptions {
+		if ok, _ := path.Match(subscription.Pattern, event.Topic); !ok {
+			continue
+		}
+
+		select {
+		case subscription.Events <- event:
+			received++
+		default:
+			subscription.overflowOnce.Do(func() {
+				log.Printf("Subscriber of %v does not keep up and is disconnected\n", subscription.Pattern)
+				close(subscription.Overflow)
+			})
+		}
+	}
+
+	return received
+}
+
+//Close disconnects all subscribers, so streams end on shutdown. Subscriptions
+//created afterwards are disconnected right away.
+func (b *EventBus) Close() {
+	b.MutexLock.Lock()
+	defer b.MutexLock.Unlock()
+
+	b.closed = true
+	for subscription := range b.Subscriptions {
+		subscription.close()
+	}
+}
+
+//close closes Overflow, if it is not closed yet.
+func (s *Subscription) close() {
+	s.overflowOnce.Do(func() {
+		close(s.Overflow)
+	})
+}
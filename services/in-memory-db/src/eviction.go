@@ -0,0 +1,224 @@
+/*
+eviction.go
+Implements the approximate memory accounting of stored values and the eviction
+policies used to free memory, if the configured memory limit is reached.
+Like redis it does not keep exact LRU/LFU bookkeeping, but samples a few keys
+and evicts the best candidate among them.
+
+###################################################################################
+
+MIT License
+
+Copyright (c) 2020 Bruno Hautzenberger
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+package main
+
+import (
+	"errors"
+)
+
+//EvictionPolicy defines which values are evicted, if the memory limit is
+//reached.
+type EvictionPolicy string
+
+const (
+	EvictionPolicyLRU         EvictionPolicy = "lru"
+	EvictionPolicyLFU                        = "lfu"
+	EvictionPolicyVolatileTTL                = "volatile-ttl"
+	EvictionPolicyNoEviction                 = "noeviction"
+)
+
+//evictionSamples is the number of keys that are sampled to find a value
+//to evict.
+const evictionSamples = 5
+
+//entryOverhead is the approximate number of bytes used by maps, pointers and
+//timers for every stored value in addition to its realm, key and value.
+const entryOverhead = 160
+
+//ErrOutOfMemory is returned, if a value can't be stored, because the memory
+//limit is reached and no value can be evicted.
+var ErrOutOfMemory = errors.New("Memory limit reached")
+
+//IsValid checks if this is a known eviction policy.
+func (p EvictionPolicy) IsValid() bool {
+	switch p {
+	case EvictionPolicyLRU, EvictionPolicyLFU, EvictionPolicyVolatileTTL, EvictionPolicyNoEviction:
+		return true
+	}
+
+	return false
+}
+
+//entrySize returns the approximate number of bytes used to store a value.
+func entrySize(realmName string, key string, value *Value) int64 {
+	return int64(len(realmName) + len(key) + len(value.Value) + len(value.ContentType) + entryOverhead)
+}
+
+//evictionCandidate is a sampled value, that could be evicted.
+type evictionCandidate struct {
+	Realm string
+	Key   string
+	Value *Value
+}
+
+//isBetterVictim checks if candidate a should rather be evicted than
+//candidate b using given policy.
+func (a evictionCandidate) isBetterVictim(b evictionCandidate, policy EvictionPolicy) bool {
+	switch policy {
+	case EvictionPolicyLFU:
+		if a.Value.AccessCount() != b.Value.AccessCount() {
+			return a.Value.AccessCount() < b.Value.AccessCount()
+		}
+	case EvictionPolicyVolatileTTL:
+		return a.Value.ExpiresAt.Before(b.Value.ExpiresAt)
+	}
+
+	return a.Value.LastAccess().Before(b.Value.LastAccess())
+}
+
+//sampleEvictionCandidates samples a few values of given realm, or of all
+//realms if realmName is empty, except the values for which except returns
+//true, because they are about to be written. Realms configured not to evict
+//any values are skipped. Map iteration order in go is random, so this is a
+//cheap random sample.
+func (s *Storage) sampleEvictionCandidates(realmName string, except func(realmName string, key string) bool) []evictionCandidate {
+	candidates := make([]evictionCandidate, 0, evictionSamples)
+
+	realms := s.Data
+	if len(realmName) > 0 {
+		realms = map[string]map[string]*Value{realmName: s.Data[realmName]}
+	}
+
+	if len(realms) == 0 {
+		return candidates
+	}
+
+	samplesPerRealm := evictionSamples/len(realms) + 1
+	for name, realm := range realms {
+		if config, ok := s.RealmConfigs[name]; ok && len(realmName) == 0 && config.EvictionPolicy == EvictionPolicyNoEviction {
+			continue
+		}
+
+		samples := 0
+		for key, value := range realm {
+			if except(name, key) {
+				continue
+			}
+
+			candidates = append(candidates, evictionCandidate{name, key, value})
+			samples++
+
+			if samples == samplesPerRealm || len(candidates) == evictionSamples {
+				break
+			}
+		}
+
+		if len(candidates) == evictionSamples {
+			break
+		}
+	}
+
+	return candidates
+}
+
+//evictionPlan collects the values, that have to be evicted to reserve keys
+//and memory for a write. Values are only evicted, once all reservations of
+//the write succeeded, so a failing write never evicts any values.
+type evictionPlan struct {
+	storage     *Storage
+	except      func(realmName string, key string) bool
+	victims     map[string]bool
+	candidates  []evictionCandidate
+	freedMemory int64
+	freedKeys   map[string]int
+}
+
+//newEvictionPlan creates an empty evictionPlan. Values for which except
+//returns true are never evicted, because they are about to be replaced.
+func (s *Storage) newEvictionPlan(except func(realmName string, key string) bool) *evictionPlan {
+	return &evictionPlan{
+		storage:    s,
+		except:     except,
+		victims:    make(map[string]bool),
+		candidates: make([]evictionCandidate, 0),
+		freedKeys:  make(map[string]int),
+	}
+}
+
+//isExcepted checks if a value must not be evicted, because it is about to be
+//replaced or already planned to be evicted.
+func (p *evictionPlan) isExcepted(realmName string, key string) bool {
+	return p.except(realmName, key) || p.victims[realmName+"/"+key]
+}
+
+//add plans to evict the best value to evict of given realm, or of all realms
+//if realmName is empty, according to given eviction policy. It returns false,
+//if there was no value to evict.
+func (p *evictionPlan) add(policy EvictionPolicy, realmName string) bool {
+	candidates := p.storage.sampleEvictionCandidates(realmName, p.isExcepted)
+	if len(candidates) == 0 {
+		return false
+	}
+
+	victim := candidates[0]
+	for _, candidate := range candidates[1:] {
+		if candidate.isBetterVictim(victim, policy) {
+			victim = candidate
+		}
+	}
+
+	p.victims[victim.Realm+"/"+victim.Key] = true
+	p.candidates = append(p.candidates, victim)
+	p.freedMemory += victim.Value.size
+	p.freedKeys[victim.Realm]++
+
+	return true
+}
+
+//reserveMemory makes sure, that given number of additional bytes can be
+//stored without exceeding the memory limit, by planning to evict values
+//according to the eviction policy.
+func (p *evictionPlan) reserveMemory(size int64) error {
+	s := p.storage
+	if s.Config.MaxMemory <= 0 {
+		return nil
+	}
+
+	for s.UsedMemory-p.freedMemory+size > s.Config.MaxMemory {
+		if s.Config.EvictionPolicy == EvictionPolicyNoEviction || !p.add(s.Config.EvictionPolicy, "") {
+			s.Stats.RejectedWrites++
+			return ErrOutOfMemory
+		}
+	}
+
+	return nil
+}
+
+//evict deletes all planned values.
+func (p *evictionPlan) evict() {
+	s := p.storage
+	for _, victim := range p.candidates {
+		s.delete(victim.Realm, victim.Key)
+		s.Stats.Evictions++
+		s.notify(EventTypeEvict, victim.Realm, victim.Key, nil)
+	}
+}
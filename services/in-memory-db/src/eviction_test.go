@@ -0,0 +1,190 @@
+/*
+eviction_test.go
+Tests of memory limits, key limits and eviction plans.
+
+###################################################################################
+
+MIT License
+
+Copyright (c) 2020 Bruno Hautzenberger
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+package main
+
+import (
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+//setLastAccess sets the last access of a stored value, so tests don't depend
+//on the resolution of the clock.
+func setLastAccess(t *testing.T, s *Storage, realmName string, key string, lastAccess int64) {
+	t.Helper()
+
+	ok, value := s.get(realmName, key)
+	if !ok {
+		t.Fatalf("%v/%v is missing", realmName, key)
+	}
+	atomic.StoreInt64(&value.lastAccess, lastAccess)
+}
+
+func TestEvictLeastRecentlyUsed(t *testing.T) {
+	s := newTestStorage(t, StorageConfig{EvictionPolicy: EvictionPolicyLRU})
+	mustSet(t, s, "r", "a", "1")
+	mustSet(t, s, "r", "b", "2")
+	mustSet(t, s, "r", "c", "3")
+	s.Config.MaxMemory = s.UsedMemory
+	setLastAccess(t, s, "r", "a", 3)
+	setLastAccess(t, s, "r", "b", 1)
+	setLastAccess(t, s, "r", "c", 2)
+
+	mustSet(t, s, "r", "d", "4")
+
+	expectValue(t, s, "r", "b", "")
+	expectValue(t, s, "r", "a", "1")
+	expectValue(t, s, "r", "c", "3")
+	expectValue(t, s, "r", "d", "4")
+	if s.Stats.Evictions != 1 {
+		t.Errorf("Counted %v evictions, expected 1", s.Stats.Evictions)
+	}
+}
+
+func TestEvictRealmKeys(t *testing.T) {
+	s := newTestStorage(t, StorageConfig{})
+	if err := s.SetRealmConfig("r", RealmConfig{MaxKeys: 2, EvictionPolicy: EvictionPolicyLRU}); err != nil {
+		t.Fatal(err)
+	}
+	mustSet(t, s, "r", "a", "1")
+	mustSet(t, s, "r", "b", "2")
+	mustSet(t, s, "other", "a", "1")
+	setLastAccess(t, s, "r", "a", 1)
+	setLastAccess(t, s, "r", "b", 2)
+	setLastAccess(t, s, "other", "a", 0)
+
+	mustSet(t, s, "r", "c", "3")
+
+	expectValue(t, s, "r", "a", "")
+	expectValue(t, s, "r", "b", "2")
+	expectValue(t, s, "r", "c", "3")
+	expectValue(t, s, "other", "a", "1")
+}
+
+func TestFailedWriteDoesNotEvict(t *testing.T) {
+	s := newTestStorage(t, StorageConfig{EvictionPolicy: EvictionPolicyLRU})
+	mustSet(t, s, "r", "a", "1")
+	mustSet(t, s, "r", "b", "2")
+	s.Config.MaxMemory = s.UsedMemory
+
+	// even evicting both values would not free enough memory
+	err := s.Set("r", "c", NewValue(strings.Repeat("c", int(s.Config.MaxMemory)), 60))
+	if err != ErrOutOfMemory {
+		t.Errorf("Set returned %v, expected %v", err, ErrOutOfMemory)
+	}
+
+	expectValue(t, s, "r", "a", "1")
+	expectValue(t, s, "r", "b", "2")
+	if s.Stats.Evictions != 0 || s.Stats.RejectedWrites != 1 {
+		t.Errorf("Counted %v evictions and %v rejected writes, expected 0 and 1", s.Stats.Evictions, s.Stats.RejectedWrites)
+	}
+}
+
+func TestNoEvictionRejectsWrites(t *testing.T) {
+	s := newTestStorage(t, StorageConfig{EvictionPolicy: EvictionPolicyNoEviction})
+	mustSet(t, s, "r", "a", "1")
+	s.Config.MaxMemory = s.UsedMemory
+
+	if err := s.Set("r", "b", NewValue("2", 60)); err != ErrOutOfMemory {
+		t.Errorf("Set returned %v, expected %v", err, ErrOutOfMemory)
+	}
+
+	// replacing a value of the same size needs no additional memory
+	if err := s.Set("r", "a", NewValue("3", 60)); err != nil {
+		t.Errorf("Replacing a value failed: %v", err)
+	}
+}
+
+func TestTransactionEvictsOnlyOtherValues(t *testing.T) {
+	s := newTestStorage(t, StorageConfig{EvictionPolicy: EvictionPolicyLRU})
+	mustSet(t, s, "r", "a", "1")
+	mustSet(t, s, "r", "b", "2")
+	s.Config.MaxMemory = s.UsedMemory
+	setLastAccess(t, s, "r", "a", 1)
+	setLastAccess(t, s, "r", "b", 2)
+
+	// a is written by the transaction, so b is evicted, although a was used
+	// less recently
+	err := s.Transaction(func(tx *Transaction) error {
+		tx.Set("r", "a", NewValue("3", 60))
+		return tx.Set("r", "c", NewValue("4", 60))
+	})
+	if err != nil {
+		t.Fatalf("Transaction failed: %v", err)
+	}
+	expectValue(t, s, "r", "a", "3")
+	expectValue(t, s, "r", "b", "")
+	expectValue(t, s, "r", "c", "4")
+
+	// three values never fit, so the transaction fails without evicting
+	err = s.Transaction(func(tx *Transaction) error {
+		tx.Set("r", "d", NewValue("5", 60))
+		tx.Set("r", "e", NewValue("6", 60))
+		return tx.Set("r", "f", NewValue("7", 60))
+	})
+	if err != ErrOutOfMemory {
+		t.Errorf("Transaction returned %v, expected %v", err, ErrOutOfMemory)
+	}
+	expectValue(t, s, "r", "a", "3")
+	expectValue(t, s, "r", "c", "4")
+	if s.Stats.Evictions != 1 {
+		t.Errorf("Counted %v evictions, expected 1", s.Stats.Evictions)
+	}
+}
+
+func TestEvictionPlanSkipsPlannedVictims(t *testing.T) {
+	s := newTestStorage(t, StorageConfig{EvictionPolicy: EvictionPolicyLRU})
+	mustSet(t, s, "r", "a", "1")
+	mustSet(t, s, "r", "b", "2")
+	mustSet(t, s, "r", "c", "3")
+	size := s.UsedMemory / 3
+	s.Config.MaxMemory = s.UsedMemory
+
+	plan := s.newEvictionPlan(func(realmName string, key string) bool {
+		return key == "c"
+	})
+	if err := plan.reserveMemory(2 * size); err != nil {
+		t.Fatalf("Reserving memory of two values failed: %v", err)
+	}
+	if len(plan.candidates) != 2 || plan.freedMemory != 2*size {
+		t.Errorf("Planned to evict %v values freeing %v bytes, expected 2 values freeing %v bytes", len(plan.candidates), plan.freedMemory, 2*size)
+	}
+	if err := plan.reserveMemory(3 * size); err != ErrOutOfMemory {
+		t.Errorf("Reserving memory of three values returned %v, expected %v", err, ErrOutOfMemory)
+	}
+
+	// nothing is evicted, until the plan is carried out
+	if len(s.Data["r"]) != 3 {
+		t.Fatalf("Realm has %v values before evicting, expected 3", len(s.Data["r"]))
+	}
+	plan.evict()
+	expectValue(t, s, "r", "a", "")
+	expectValue(t, s, "r", "b", "")
+	expectValue(t, s, "r", "c", "3")
+}
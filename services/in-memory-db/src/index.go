@@ -0,0 +1,367 @@
+/*
+index.go
+Implements secondary indexes on fields of JSON values. Indexes are declared
+in the realm configuration and maintained whenever values are stored,
+deleted, expired or evicted.
+
+###################################################################################
+
+MIT License
+
+Copyright (c) 2020 Bruno Hautzenberger
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"sort"
+	"strings"
+)
+
+//ErrFieldNotIndexed is returned, if a query uses a field, which is not
+//indexed in the realm.
+var ErrFieldNotIndexed = errors.New("Field is not indexed")
+
+//IndexKind defines the type of an indexed value. Values of different kinds
+//are ordered by their kind.
+type IndexKind int
+
+const (
+	IndexKindBool IndexKind = iota
+	IndexKindNumber
+	IndexKindString
+)
+
+//IndexValue is a single indexed JSON value. Only booleans, numbers and
+//strings are indexed.
+type IndexValue struct {
+	Kind   IndexKind `json:"t"`
+	Bool   bool      `json:"b,omitempty"`
+	Number float64   `json:"n,omitempty"`
+	String string    `json:"s,omitempty"`
+}
+
+//IndexValueFromJSON creates an IndexValue from a decoded JSON value. It
+//returns false, if the value can't be indexed.
+func IndexValueFromJSON(value interface{}) (IndexValue, bool) {
+	switch v := value.(type) {
+	case bool:
+		return IndexValue{Kind: IndexKindBool, Bool: v}, true
+	case float64:
+		return IndexValue{Kind: IndexKindNumber, Number: v}, true
+	case string:
+		return IndexValue{Kind: IndexKindString, String: v}, true
+	}
+
+	return IndexValue{}, false
+}
+
+//ParseIndexValue parses a value given in a query. JSON literals like 42,
+//true or "42" are parsed as JSON, everything else is used as string.
+func ParseIndexValue(param string) IndexValue {
+	var decoded interface{}
+	if err := json.Unmarshal([]byte(param), &decoded); err == nil {
+		if value, ok := IndexValueFromJSON(decoded); ok {
+			return value
+		}
+	}
+
+	return IndexValue{Kind: IndexKindString, String: param}
+}
+
+//Compare returns -1, 0 or 1, if this value is less than, equal to or greater
+//than the other value.
+func (v IndexValue) Compare(other IndexValue) int {
+	switch {
+	case v.Kind != other.Kind:
+		return compareOrdered(v.Kind < other.Kind, v.Kind > other.Kind)
+	case v.Kind == IndexKindBool:
+		return compareOrdered(!v.Bool && other.Bool, v.Bool && !other.Bool)
+	case v.Kind == IndexKindNumber:
+		return compareOrdered(v.Number < other.Number, v.Number > other.Number)
+	}
+
+	return strings.Compare(v.String, other.String)
+}
+
+//compareOrdered returns the result of a comparison.
+func compareOrdered(less bool, greater bool) int {
+	if less {
+		return -1
+	}
+
+	if greater {
+		return 1
+	}
+
+	return 0
+}
+
+//indexEntry is a key with its indexed value. Entries are ordered by their
+//values and then by their keys.
+type indexEntry struct {
+	Value IndexValue `json:"v"`
+	Key   string     `json:"k"`
+}
+
+//less checks if this entry is ordered before the other entry.
+func (e indexEntry) less(other indexEntry) bool {
+	if c := e.Value.Compare(other.Value); c != 0 {
+		return c < 0
+	}
+
+	return e.Key < other.Key
+}
+
+//fieldIndex is the index of a single field. Entries are kept sorted, so
+//equality and range lookups are binary searches.
+type fieldIndex struct {
+	Entries []indexEntry
+	Keys    map[string]IndexValue
+}
+
+//search returns the position of the first entry, which is not ordered
+//before given entry.
+func (f *fieldIndex) search(entry indexEntry) int {
+	return sort.Search(len(f.Entries), func(i int) bool {
+		return !f.Entries[i].less(entry)
+	})
+}
+
+//searchKind returns the position of the first entry, whose value is not of a
+//kind ordered before given kind.
+func (f *fieldIndex) searchKind(kind IndexKind) int {
+	return sort.Search(len(f.Entries), func(i int) bool {
+		return f.Entries[i].Value.Kind >= kind
+	})
+}
+
+//add indexes the value of a key.
+func (f *fieldIndex) add(key string, value IndexValue) {
+	entry := indexEntry{Value: value, Key: key}
+	i := f.search(entry)
+
+	f.Entries = append(f.Entries, indexEntry{})
+	copy(f.Entries[i+1:], f.Entries[i:])
+	f.Entries[i] = entry
+	f.Keys[key] = value
+}
+
+//remove removes a key from the index, if it was indexed.
+func (f *fieldIndex) remove(key string) {
+	value, ok := f.Keys[key]
+	if !ok {
+		return
+	}
+
+	i := f.search(indexEntry{Value: value, Key: key})
+	f.Entries = append(f.Entries[:i], f.Entries[i+1:]...)
+	delete(f.Keys, key)
+}
+
+//realmIndex holds the indexes of all indexed fields of a realm.
+type realmIndex map[string]*fieldIndex
+
+//extractField returns the value of a field given as dot separated path of a
+//decoded JSON object.
+func extractField(document interface{}, field string) (interface{}, bool) {
+	for _, name := range strings.Split(field, ".") {
+		object, ok := document.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+
+		document, ok = object[name]
+		if !ok {
+			return nil, false
+		}
+	}
+
+	return document, true
+}
+
+//buildIndex creates the indexes of a realm declared in its configuration
+//from all of its values. Indexes of unchanged fields are kept.
+func (s *Storage) buildIndex(realmName string) {
+	config, ok := s.RealmConfigs[realmName]
+	if !ok || len(config.Indexes) == 0 {
+		delete(s.Indexes, realmName)
+		return
+	}
+
+	current := s.Indexes[realmName]
+	index := make(realmIndex, len(config.Indexes))
+	missing := false
+	for _, field := range config.Indexes {
+		if fi, ok := current[field]; ok {
+			index[field] = fi
+		} else {
+			index[field] = &fieldIndex{Keys: make(map[string]IndexValue)}
+			missing = true
+		}
+	}
+	s.Indexes[realmName] = index
+
+	if !missing {
+		return
+	}
+
+	_, realm := s.GetRealm(realmName)
+	for key, value := range realm {
+		s.indexValue(realmName, key, value)
+	}
+}
+
+//indexValue updates all indexes of the realm for a stored value. Values,
+//which are no JSON objects, and fields, which are missing or no scalars, are
+//not indexed.
+func (s *Storage) indexValue(realmName string, key string, value *Value) {
+	index, ok := s.Indexes[realmName]
+	if !ok {
+		return
+	}
+
+	var document interface{}
+	if err := json.Unmarshal([]byte(value.Value), &document); err != nil {
+		document = nil
+	}
+
+	for field, fi := range index {
+		fi.remove(key)
+
+		if raw, ok := extractField(document, field); ok {
+			if indexed, ok := IndexValueFromJSON(raw); ok {
+				fi.add(key, indexed)
+			}
+		}
+	}
+}
+
+//unindexValue removes a deleted value from all indexes of the realm.
+func (s *Storage) unindexValue(realmName string, key string) {
+	for _, fi := range s.Indexes[realmName] {
+		fi.remove(key)
+	}
+}
+
+//Query defines a lookup of values by an indexed field. Min and Max are
+//inclusive, nil means that there is no limit. Equality lookups use the same
+//value for both. Both have to be of the same kind and only values of that
+//kind are found, so min=5 does not find strings.
+type Query struct {
+	Field  string
+	Min    *IndexValue
+	Max    *IndexValue
+	Cursor string
+	Count  int
+}
+
+//QueryResult is a single value found by a query.
+type QueryResult struct {
+	Key   string
+	Value *Value
+}
+
+//encodeQueryCursor creates the cursor of a query, that continues after given
+//entry.
+func encodeQueryCursor(entry indexEntry) string {
+	data, _ := json.Marshal(entry)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+//decodeQueryCursor returns the entry a query continues after.
+func decodeQueryCursor(cursor string) (indexEntry, error) {
+	entry := indexEntry{}
+
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return entry, err
+	}
+
+	err = json.Unmarshal(data, &entry)
+	return entry, err
+}
+
+//Query returns up to Count values of a realm, whose indexed field is within
+//the range of the query, ordered by the field and their keys. It also returns
+//the cursor of the next page, which is empty if there are no more values.
+func (s *Storage) Query(realmName string, query Query) ([]QueryResult, string, error) {
+	s.MutexLock.RLock()
+	defer s.MutexLock.RUnlock()
+
+	fi, ok := s.Indexes[realmName][query.Field]
+	if !ok {
+		return nil, "", ErrFieldNotIndexed
+	}
+
+	start := 0
+	if query.Min != nil {
+		start = fi.search(indexEntry{Value: *query.Min})
+	} else if query.Max != nil {
+		start = fi.searchKind(query.Max.Kind)
+	}
+
+	// cursors are never trusted to be within the range of the query, they
+	// may be forged or come from a different query
+	if len(query.Cursor) > 0 {
+		after, err := decodeQueryCursor(query.Cursor)
+		if err != nil {
+			return nil, "", err
+		}
+		next := fi.search(after)
+		if next < len(fi.Entries) && fi.Entries[next] == after {
+			next++
+		}
+		if next > start {
+			start = next
+		}
+	}
+
+	bound := query.Min
+	if bound == nil {
+		bound = query.Max
+	}
+
+	inRange := func(i int) bool {
+		if i >= len(fi.Entries) {
+			return false
+		}
+
+		entry := fi.Entries[i]
+		return (bound == nil || entry.Value.Kind == bound.Kind) && (query.Max == nil || entry.Value.Compare(*query.Max) <= 0)
+	}
+
+	results := make([]QueryResult, 0)
+	i := start
+	for ; inRange(i) && len(results) < query.Count; i++ {
+		entry := fi.Entries[i]
+		results = append(results, QueryResult{Key: entry.Key, Value: s.Data[realmName][entry.Key]})
+	}
+
+	next := ""
+	if inRange(i) && len(results) > 0 {
+		next = encodeQueryCursor(fi.Entries[i-1])
+	}
+
+	return results, next, nil
+}
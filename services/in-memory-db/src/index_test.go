@@ -0,0 +1,181 @@
+/*
+index_test.go
+Tests of secondary indexes and queries with cursors.
+
+###################################################################################
+
+MIT License
+
+Copyright (c) 2020 Bruno Hautzenberger
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+//newIndexedStorage creates a storage with realm "r" indexing field "n" of
+//the values k0 to k9, whose field is their number, and of the values "s" and
+//"b", whose field is a string and a boolean.
+func newIndexedStorage(t *testing.T) *Storage {
+	t.Helper()
+
+	s := newTestStorage(t, StorageConfig{})
+	if err := s.SetRealmConfig("r", RealmConfig{Indexes: []string{"n"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 10; i++ {
+		mustSet(t, s, "r", fmt.Sprintf("k%v", i), fmt.Sprintf(`{"n":%v}`, i))
+	}
+	mustSet(t, s, "r", "s", `{"n":"a"}`)
+	mustSet(t, s, "r", "b", `{"n":true}`)
+	mustSet(t, s, "r", "none", `"no object"`)
+
+	return s
+}
+
+//queryKeys runs a query and returns the keys it found.
+func queryKeys(t *testing.T, s *Storage, query Query) ([]string, string) {
+	t.Helper()
+
+	results, next, err := s.Query("r", query)
+	if err != nil {
+		t.Fatalf("Query(%+v) failed: %v", query, err)
+	}
+
+	keys := make([]string, 0, len(results))
+	for _, result := range results {
+		keys = append(keys, result.Key)
+	}
+
+	return keys, next
+}
+
+//indexValue returns a pointer to a parsed index value.
+func indexValue(param string) *IndexValue {
+	value := ParseIndexValue(param)
+	return &value
+}
+
+func TestQueryPages(t *testing.T) {
+	s := newIndexedStorage(t)
+	query := Query{Field: "n", Min: indexValue("3"), Max: indexValue("7"), Count: 2}
+
+	pages := [][]string{}
+	for {
+		keys, next := queryKeys(t, s, query)
+		pages = append(pages, keys)
+		if len(next) == 0 {
+			break
+		}
+		query.Cursor = next
+	}
+
+	expected := [][]string{{"k3", "k4"}, {"k5", "k6"}, {"k7"}}
+	if !reflect.DeepEqual(pages, expected) {
+		t.Errorf("Pages are %v, expected %v", pages, expected)
+	}
+}
+
+func TestQueryLastPageHasNoCursor(t *testing.T) {
+	s := newIndexedStorage(t)
+
+	keys, next := queryKeys(t, s, Query{Field: "n", Min: indexValue("8"), Max: indexValue("9"), Count: 2})
+	if !reflect.DeepEqual(keys, []string{"k8", "k9"}) || len(next) > 0 {
+		t.Errorf("Query returned %v with cursor %q, expected [k8 k9] without cursor", keys, next)
+	}
+}
+
+func TestQueryOnlyFindsKindOfBounds(t *testing.T) {
+	s := newIndexedStorage(t)
+
+	tests := []struct {
+		name     string
+		query    Query
+		expected []string
+	}{
+		{"min number", Query{Field: "n", Min: indexValue("8")}, []string{"k8", "k9"}},
+		{"max number", Query{Field: "n", Max: indexValue("1")}, []string{"k0", "k1"}},
+		{"max string", Query{Field: "n", Max: indexValue("z")}, []string{"s"}},
+		{"min string", Query{Field: "n", Min: indexValue("")}, []string{"s"}},
+		{"equal bool", Query{Field: "n", Min: indexValue("true"), Max: indexValue("true")}, []string{"b"}},
+		{"no bounds", Query{Field: "n"}, []string{"b", "k0", "k1", "k2", "k3", "k4", "k5", "k6", "k7", "k8", "k9", "s"}},
+	}
+
+	for _, test := range tests {
+		test.query.Count = 100
+		keys, _ := queryKeys(t, s, test.query)
+		if !reflect.DeepEqual(keys, test.expected) {
+			t.Errorf("%v: Query returned %v, expected %v", test.name, keys, test.expected)
+		}
+	}
+}
+
+func TestQueryCursorOutsideRange(t *testing.T) {
+	s := newIndexedStorage(t)
+	below := encodeQueryCursor(indexEntry{Value: *indexValue("0"), Key: "k0"})
+	above := encodeQueryCursor(indexEntry{Value: *indexValue("9"), Key: "k9"})
+	otherKind := encodeQueryCursor(indexEntry{Value: *indexValue("true"), Key: "b"})
+
+	tests := []struct {
+		name     string
+		cursor   string
+		expected []string
+	}{
+		{"below min", below, []string{"k3", "k4", "k5"}},
+		{"other kind", otherKind, []string{"k3", "k4", "k5"}},
+		{"above max", above, []string{}},
+	}
+
+	for _, test := range tests {
+		query := Query{Field: "n", Min: indexValue("3"), Max: indexValue("5"), Cursor: test.cursor, Count: 100}
+		keys, next := queryKeys(t, s, query)
+		if !reflect.DeepEqual(keys, test.expected) || len(next) > 0 {
+			t.Errorf("%v: Query returned %v with cursor %q, expected %v", test.name, keys, next, test.expected)
+		}
+	}
+}
+
+func TestQueryInvalidCursor(t *testing.T) {
+	s := newIndexedStorage(t)
+
+	if _, _, err := s.Query("r", Query{Field: "n", Cursor: "not base64!", Count: 1}); err == nil {
+		t.Error("Query with invalid cursor succeeded")
+	}
+}
+
+func TestQueryFollowsChanges(t *testing.T) {
+	s := newIndexedStorage(t)
+	mustSet(t, s, "r", "k3", `{"n":30}`)
+	s.Delete("r", "k4")
+
+	keys, _ := queryKeys(t, s, Query{Field: "n", Min: indexValue("3"), Max: indexValue("5"), Count: 100})
+	if !reflect.DeepEqual(keys, []string{"k5"}) {
+		t.Errorf("Query returned %v, expected [k5]", keys)
+	}
+
+	if _, _, err := s.Query("r", Query{Field: "unknown", Count: 1}); err != ErrFieldNotIndexed {
+		t.Errorf("Query of unknown field returned %v, expected %v", err, ErrFieldNotIndexed)
+	}
+}
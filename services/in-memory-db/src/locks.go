@@ -0,0 +1,283 @@
+/*
+locks.go
+Implements named locks with leases and fencing tokens, which can be used by
+services to coordinate mutual exclusive work. Expired leases are released
+automatically using the same timer based mechanism as expiring values.
+
+###################################################################################
+
+MIT License
+
+Copyright (c) 2020 Bruno Hautzenberger
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+package main
+
+import (
+	"log"
+	"time"
+)
+
+//LockManagerInterface defines the interface for managing named locks.
+type LockManagerInterface interface {
+	Initialize(storage StorageInterface)
+	Get(name string) (bool, *Lock)
+	Acquire(name string, holder string, lease time.Duration) (bool, *Lock)
+	Renew(name string, holder string, token uint64, lease time.Duration) (bool, *Lock)
+	Release(name string, holder string, token uint64) bool
+}
+
+//Lock holds all information about an acquired lock. Token is the fencing
+//token, which increases with every acquisition, so resources protected by
+//a lock can reject requests of holders whose lease already expired.
+type Lock struct {
+	Name      string
+	Holder    string
+	Token     uint64
+	ExpiresAt time.Time
+	timer     *time.Timer
+}
+
+//ToLockMessageType transforms a Lock instance to a LockMessageType that can
+//be converted to json and served via the api.
+func (l *Lock) ToLockMessageType() LockMessageType {
+	return LockMessageType{
+		Name:      l.Name,
+		Holder:    l.Holder,
+		Token:     l.Token,
+		ExpiresIn: (int)(l.ExpiresAt.Sub(time.Now().UTC()).Seconds()),
+	}
+}
+
+//isHeldBy checks if this lock is held by given holder using given token.
+func (l *Lock) isHeldBy(holder string, token uint64) bool {
+	return l.Holder == holder && l.Token == token
+}
+
+//copy returns a copy of this lock without its timer, which can be read after
+//the storage is unlocked.
+func (l *Lock) copy() *Lock {
+	if l == nil {
+		return nil
+	}
+
+	return &Lock{
+		Name:      l.Name,
+		Holder:    l.Holder,
+		Token:     l.Token,
+		ExpiresAt: l.ExpiresAt,
+	}
+}
+
+//LockManager implements LockManagerInterface. Locks are kept by the storage
+//together with the fencing tokens, so they are replicated and saved in
+//snapshots like values.
+type LockManager struct {
+	Storage StorageInterface
+}
+
+//Initialize sets the storage, which holds all currently acquired locks.
+func (m *LockManager) Initialize(storage StorageInterface) {
+	m.Storage = storage
+}
+
+//Get returns a copy of the lock with given name, if it is currently acquired.
+func (m *LockManager) Get(name string) (bool, *Lock) {
+	return m.Storage.GetLock(name)
+}
+
+//Acquire acquires the lock with given name for given holder, if it is not
+//held by anyone else. It returns false and the current lock, if it is already
+//acquired. The returned locks are copies, which are safe to read.
+func (m *LockManager) Acquire(name string, holder string, lease time.Duration) (bool, *Lock) {
+	return m.Storage.AcquireLock(name, holder, lease)
+}
+
+//Renew extends the lease of a lock. Only the current holder, identified by
+//holder and token, is allowed to renew it.
+func (m *LockManager) Renew(name string, holder string, token uint64, lease time.Duration) (bool, *Lock) {
+	return m.Storage.RenewLock(name, holder, token, lease)
+}
+
+//Release releases a lock. Only the current holder, identified by holder and
+//token, is allowed to release it.
+func (m *LockManager) Release(name string, holder string, token uint64) bool {
+	return m.Storage.ReleaseLock(name, holder, token)
+}
+
+//GetLock returns a copy of the lock with given name, if it is currently
+//acquired.
+func (s *Storage) GetLock(name string) (bool, *Lock) {
+	s.MutexLock.RLock()
+	defer s.MutexLock.RUnlock()
+
+	lock, ok := s.activeLock(name, time.Now().UTC())
+	return ok, lock.copy()
+}
+
+//activeLock returns the lock with given name, if it is acquired and its lease
+//did not expire. Expired leases are treated as released, even if the timer,
+//that deletes the lock, did not fire yet.
+func (s *Storage) activeLock(name string, now time.Time) (*Lock, bool) {
+	lock, ok := s.Locks[name]
+	if !ok || !lock.ExpiresAt.After(now) {
+		return nil, false
+	}
+
+	return lock, true
+}
+
+//ExportLocks returns all acquired locks and the last fencing token as events,
+//which can be imported by other nodes.
+func (s *Storage) ExportLocks() []*Event {
+	s.MutexLock.RLock()
+	defer s.MutexLock.RUnlock()
+
+	events := make([]*Event, 0)
+	now := time.Now().UTC()
+	for name := range s.Locks {
+		if lock, ok := s.activeLock(name, now); ok {
+			events = append(events, &Event{Type: EventTypeLock, Key: name, Lock: lock.copy()})
+		}
+	}
+
+	return append(events, &Event{Type: EventTypeToken, Token: s.LastToken})
+}
+
+//AcquireLock acquires the lock with given name for given holder with the
+//next fencing token, if it is not held by anyone else. It returns false and
+//a copy of the current lock, if it is already acquired.
+func (s *Storage) AcquireLock(name string, holder string, lease time.Duration) (bool, *Lock) {
+	s.MutexLock.Lock()
+	defer s.MutexLock.Unlock()
+
+	now := time.Now().UTC()
+	if current, ok := s.activeLock(name, now); ok {
+		return false, current.copy()
+	}
+
+	lock := &Lock{
+		Name:      name,
+		Holder:    holder,
+		Token:     s.LastToken + 1,
+		ExpiresAt: now.Add(lease),
+	}
+	s.storeLock(lock)
+
+	log.Printf("Lock %v acquired by %v with token %v\n", name, holder, lock.Token)
+
+	return true, lock.copy()
+}
+
+//RenewLock extends the lease of a lock, if it is held by given holder with
+//given token and its lease did not expire yet.
+func (s *Storage) RenewLock(name string, holder string, token uint64, lease time.Duration) (bool, *Lock) {
+	s.MutexLock.Lock()
+	defer s.MutexLock.Unlock()
+
+	now := time.Now().UTC()
+	current, ok := s.activeLock(name, now)
+	if !ok || !current.isHeldBy(holder, token) {
+		return false, current.copy()
+	}
+
+	lock := current.copy()
+	lock.ExpiresAt = now.Add(lease)
+	s.storeLock(lock)
+
+	return true, lock.copy()
+}
+
+//ReleaseLock releases a lock, if it is held by given holder with given token.
+func (s *Storage) ReleaseLock(name string, holder string, token uint64) bool {
+	s.MutexLock.Lock()
+	defer s.MutexLock.Unlock()
+
+	lock, ok := s.Locks[name]
+	if !ok || !lock.isHeldBy(holder, token) {
+		return false
+	}
+
+	s.deleteLock(name)
+	log.Printf("Lock %v released by %v\n", name, holder)
+
+	return true
+}
+
+//storeLock stores a lock without locking the storage, replaces the timer of
+//the current lock and starts the timer, that deletes the lock after its
+//lease expired. The last fencing token is raised to the token of the lock.
+func (s *Storage) storeLock(lock *Lock) {
+	if current, ok := s.Locks[lock.Name]; ok {
+		current.timer.Stop()
+	}
+
+	if lock.Token > s.LastToken {
+		s.LastToken = lock.Token
+	}
+
+	s.Locks[lock.Name] = lock
+	lock.timer = time.AfterFunc(lock.ExpiresAt.Sub(time.Now().UTC()), func() {
+		s.expireLock(lock)
+	})
+
+	s.Events.Publish(&Event{
+		Type:  EventTypeLock,
+		Topic: "/",
+		Key:   lock.Name,
+		Lock:  lock.copy(),
+	})
+}
+
+//deleteLock deletes a lock without locking the storage.
+func (s *Storage) deleteLock(name string) {
+	lock, ok := s.Locks[name]
+	if !ok {
+		return
+	}
+
+	lock.timer.Stop()
+	delete(s.Locks, name)
+
+	s.Events.Publish(&Event{
+		Type:  EventTypeUnlock,
+		Topic: "/",
+		Key:   name,
+	})
+}
+
+//stopLocks stops the timers of all locks without locking the storage.
+func (s *Storage) stopLocks() {
+	for _, lock := range s.Locks {
+		lock.timer.Stop()
+	}
+}
+
+//expireLock deletes the given lock after its lease expired, but only if it
+//was not released, renewed or acquired again in the meantime.
+func (s *Storage) expireLock(lock *Lock) {
+	s.MutexLock.Lock()
+	defer s.MutexLock.Unlock()
+
+	if current, ok := s.Locks[lock.Name]; ok && current == lock {
+		s.deleteLock(lock.Name)
+		log.Printf("Lock %v of %v expired\n", lock.Name, lock.Holder)
+	}
+}
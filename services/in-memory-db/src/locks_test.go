@@ -0,0 +1,151 @@
+/*
+locks_test.go
+Tests of named locks, their leases and fencing tokens.
+
+###################################################################################
+
+MIT License
+
+Copyright (c) 2020 Bruno Hautzenberger
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAcquireLock(t *testing.T) {
+	s := newTestStorage(t, StorageConfig{})
+
+	ok, lock := s.AcquireLock("backup", "a", time.Minute)
+	if !ok || lock.Holder != "a" || lock.Token != 1 {
+		t.Fatalf("AcquireLock = %v, %+v, expected lock of a with token 1", ok, lock)
+	}
+
+	ok, lock = s.AcquireLock("backup", "b", time.Minute)
+	if ok || lock.Holder != "a" {
+		t.Errorf("AcquireLock of held lock = %v, %+v, expected conflict with a", ok, lock)
+	}
+
+	ok, lock = s.AcquireLock("other", "b", time.Minute)
+	if !ok || lock.Token != 2 {
+		t.Errorf("AcquireLock of other lock = %v, %+v, expected token 2", ok, lock)
+	}
+}
+
+func TestAcquireExpiredLock(t *testing.T) {
+	s := newTestStorage(t, StorageConfig{})
+	s.AcquireLock("backup", "a", time.Minute)
+
+	// the lease expired, but the timer releasing the lock did not fire yet
+	s.Locks["backup"].ExpiresAt = time.Now().UTC().Add(-time.Second)
+
+	if ok, _ := s.GetLock("backup"); ok {
+		t.Errorf("GetLock returned an expired lock")
+	}
+
+	ok, lock := s.AcquireLock("backup", "b", time.Minute)
+	if !ok || lock.Holder != "b" || lock.Token != 2 {
+		t.Fatalf("AcquireLock of expired lock = %v, %+v, expected lock of b with token 2", ok, lock)
+	}
+
+	if ok, _ := s.RenewLock("backup", "a", 1, time.Minute); ok {
+		t.Errorf("RenewLock succeeded for the holder of the expired lease")
+	}
+}
+
+func TestLockExpires(t *testing.T) {
+	s := newTestStorage(t, StorageConfig{})
+	s.AcquireLock("backup", "a", 10*time.Millisecond)
+
+	time.Sleep(50 * time.Millisecond)
+
+	s.MutexLock.RLock()
+	_, ok := s.Locks["backup"]
+	s.MutexLock.RUnlock()
+	if ok {
+		t.Errorf("lock was not released after its lease expired")
+	}
+}
+
+func TestRenewAndReleaseLock(t *testing.T) {
+	s := newTestStorage(t, StorageConfig{})
+	_, lock := s.AcquireLock("backup", "a", time.Second)
+
+	if ok, _ := s.RenewLock("backup", "b", lock.Token, time.Minute); ok {
+		t.Errorf("RenewLock succeeded for another holder")
+	}
+
+	if ok, _ := s.RenewLock("backup", "a", lock.Token+1, time.Minute); ok {
+		t.Errorf("RenewLock succeeded with another token")
+	}
+
+	ok, renewed := s.RenewLock("backup", "a", lock.Token, time.Minute)
+	if !ok || renewed.Token != lock.Token || !renewed.ExpiresAt.After(lock.ExpiresAt) {
+		t.Errorf("RenewLock = %v, %+v, expected the lease to be extended", ok, renewed)
+	}
+
+	if s.ReleaseLock("backup", "b", lock.Token) {
+		t.Errorf("ReleaseLock succeeded for another holder")
+	}
+
+	if !s.ReleaseLock("backup", "a", lock.Token) {
+		t.Errorf("ReleaseLock failed for the holder")
+	}
+
+	if ok, _ := s.GetLock("backup"); ok {
+		t.Errorf("lock is still acquired after it was released")
+	}
+
+	ok, lock = s.AcquireLock("backup", "b", time.Minute)
+	if !ok || lock.Token != 2 {
+		t.Errorf("AcquireLock after release = %v, %+v, expected token 2", ok, lock)
+	}
+}
+
+func TestLocksAreReplaced(t *testing.T) {
+	s := newTestStorage(t, StorageConfig{})
+	s.AcquireLock("backup", "a", time.Minute)
+	s.AcquireLock("cleanup", "b", time.Minute)
+	s.ReleaseLock("cleanup", "b", 2)
+
+	s.MutexLock.RLock()
+	state := s.exportState()
+	s.MutexLock.RUnlock()
+
+	replica := newTestStorage(t, StorageConfig{})
+	replica.AcquireLock("stale", "c", time.Minute)
+	replica.Replace(state)
+
+	if ok, lock := replica.GetLock("backup"); !ok || lock.Holder != "a" || lock.Token != 1 {
+		t.Errorf("GetLock = %v, %+v, expected lock of a with token 1", ok, lock)
+	}
+
+	if ok, _ := replica.GetLock("stale"); ok {
+		t.Errorf("lock of the replaced storage is still acquired")
+	}
+
+	ok, lock := replica.AcquireLock("cleanup", "c", time.Minute)
+	if !ok || lock.Token != 3 {
+		t.Errorf("AcquireLock = %v, %+v, expected token 3", ok, lock)
+	}
+}
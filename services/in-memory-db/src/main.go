@@ -51,33 +51,207 @@ SOFTWARE.
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"sync"
+	"syscall"
 
 	"github.com/gorilla/mux"
 )
 
 var storage StorageInterface = &Storage{}
+var locks LockManagerInterface = &LockManager{}
+var channels *EventBus = &EventBus{}
+var access AccessInterface = &Access{}
+var replication ReplicationInterface = &Replication{}
+var cluster ClusterInterface = &Cluster{}
 var api *API = &API{}
+var grpcServer *GRPCServer = &GRPCServer{}
+var persistence PersistenceConfig
 
-//init initializes storage and api
-func init() {
-	storage.Initialize()
-	api.Initialize(storage)
+//setup initializes storage, lock manager, channels, access control,
+//replication, cluster, api and gRPC api and loads the last snapshot, if
+//persistence is configured. It is called by main instead of init, so tests
+//of this package don't depend on the env vars of the service.
+func setup() {
+	config, err := StorageConfigFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	accessConfig, err := AccessConfigFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	clusterConfig, err := ClusterConfigFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	persistence, err = PersistenceConfigFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	storage.Initialize(config)
+	if len(persistence.SnapshotPath) > 0 {
+		if err := storage.LoadSnapshot(persistence.SnapshotPath, persistence.SnapshotFormat); err != nil {
+			log.Fatal(err)
+		}
+	}
+	locks.Initialize(storage)
+	channels.Initialize()
+	access.Initialize(accessConfig)
+	replication.Initialize(ReplicationConfigFromEnv(), storage)
+	if err := cluster.Initialize(clusterConfig, storage); err != nil {
+		log.Fatal(err)
+	}
+	api.Initialize(storage, locks, channels, access, replication, cluster)
+	grpcServer.Initialize(storage, access, replication, cluster)
 }
 
 //main is the main entrypoint of the service. It routes all API methods
-//and starts the server on PORT specified in env vars.
+//of the versioned api under /v1 and of the legacy api and starts the server on
+//PORT specified in env vars. The gRPC api is served on GRPC_PORT, if it is set.
+//The service shuts down gracefully on SIGINT and SIGTERM.
 func main() {
+	setup()
+
+	if port := os.Getenv("GRPC_PORT"); len(port) > 0 {
+		go func() {
+			if err := grpcServer.Serve(port); err != nil {
+				log.Fatal(err)
+			}
+		}()
+	}
+
 	r := mux.NewRouter()
+	r.Use(access.Middleware)
+
+	// versioned api, where realms and keys can't collide with other routes
+	v1 := r.PathPrefix("/v1").Subrouter()
+	v1.HandleFunc("/realms", api.Realms).Methods("GET")
+	v1.HandleFunc("/realms/{realm}", api.GetRealmConfig).Methods("GET")
+	v1.HandleFunc("/realms/{realm}", api.SetRealmConfig).Methods("PUT")
+	v1.HandleFunc("/realms/{realm}", api.DeleteRealm).Methods("DELETE")
+	v1.HandleFunc("/realms/{realm}/keys", api.Keys).Methods("GET")
+	v1.HandleFunc("/realms/{realm}/keys/{key}", api.Get).Methods("GET")
+	v1.HandleFunc("/realms/{realm}/keys/{key}", api.Set).Methods("PUT", "POST")
+	v1.HandleFunc("/realms/{realm}/keys/{key}", api.Delete).Methods("DELETE")
+	v1.HandleFunc("/realms/{realm}/keys/{key}/cas", api.CompareAndSwap).Methods("POST")
+	v1.HandleFunc("/realms/{realm}/query", api.Query).Methods("GET")
+	v1.HandleFunc("/realms/{realm}/ratelimits/{key}", api.RateLimit).Methods("POST")
+	route(v1)
+
+	// legacy api, which serves the original routes and aliases of newer
+	// methods, whose routes can't shadow a realm or key of the original routes
 	r.HandleFunc("/{realm}/keys", api.Keys).Methods("GET")
 	r.HandleFunc("/realms", api.Realms).Methods("GET")
+	r.HandleFunc("/ratelimit/{realm}/{key}", api.RateLimit).Methods("POST")
+	r.HandleFunc("/{realm}/{key}/cas", api.CompareAndSwap).Methods("POST")
+	r.HandleFunc("/memory", api.Memory).Methods("GET")
+	r.HandleFunc("/mget", api.MGet).Methods("POST")
+	r.HandleFunc("/mset", api.MSet).Methods("POST")
+	r.HandleFunc("/mdel", api.MDelete).Methods("POST")
+	r.HandleFunc("/transaction", api.Transaction).Methods("POST")
+	r.HandleFunc("/scan", api.Scan).Methods("GET")
+	r.HandleFunc("/events", api.Watch).Methods("GET")
+	r.HandleFunc("/replication", api.ReplicationStatus).Methods("GET")
+	r.HandleFunc("/export", api.Export).Methods("GET")
+	r.HandleFunc("/import", api.Import).Methods("POST")
+	r.HandleFunc("/info", api.Info).Methods("GET")
+	r.HandleFunc("/metrics", api.Metrics).Methods("GET")
+	r.HandleFunc("/cluster", api.ClusterStatus).Methods("GET")
+	r.HandleFunc("/cluster", api.SetClusterNodes).Methods("PUT")
+	r.HandleFunc("/script", api.Script).Methods("POST")
 	r.HandleFunc("/{realm}/{key}", api.Get).Methods("GET")
 	r.HandleFunc("/{realm}/{key}", api.Set).Methods("POST")
 	r.HandleFunc("/{realm}/{key}", api.Delete).Methods("DELETE")
 
 	// Bind to a port and pass our router in
-	log.Fatal(http.ListenAndServe(fmt.Sprintf(":%v", os.Getenv("PORT")), r))
+	server := &http.Server{
+		Addr:    fmt.Sprintf(":%v", os.Getenv("PORT")),
+		Handler: r,
+	}
+	go func() {
+		if err := server.ListenAndServe(); err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	log.Printf("Received %v, shutting down\n", <-signals)
+
+	shutdown(server)
+}
+
+//shutdown stops accepting connections and waits up to SHUTDOWN_TIMEOUT for
+//in-flight requests of the RESTful and the gRPC api. Then it stops the
+//expiration timers and writes the final snapshot, if persistence is
+//configured.
+func shutdown(server *http.Server) {
+	ctx, cancel := context.WithTimeout(context.Background(), persistence.ShutdownTimeout)
+	defer cancel()
+
+	// streams never end by themselves, so subscribers are disconnected first
+	storage.Disconnect()
+	channels.Close()
+
+	wg := sync.WaitGroup{}
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		if err := server.Shutdown(ctx); err != nil {
+			log.Printf("Requests were still in-flight on shutdown: %v\n", err)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		grpcServer.Shutdown(ctx)
+	}()
+	wg.Wait()
+
+	storage.Close()
+
+	if len(persistence.SnapshotPath) > 0 {
+		if err := storage.SaveSnapshot(persistence.SnapshotPath, persistence.SnapshotFormat); err != nil {
+			log.Fatalf("Failed to save snapshot: %v", err)
+		}
+	}
+
+	log.Println("Shut down")
+}
+
+//route routes all API methods of the versioned api, which are not scoped to
+//a realm.
+func route(r *mux.Router) {
+	r.HandleFunc("/scan", api.Scan).Methods("GET")
+	r.HandleFunc("/memory", api.Memory).Methods("GET")
+	r.HandleFunc("/info", api.Info).Methods("GET")
+	r.HandleFunc("/metrics", api.Metrics).Methods("GET")
+	r.HandleFunc("/mget", api.MGet).Methods("POST")
+	r.HandleFunc("/mset", api.MSet).Methods("POST")
+	r.HandleFunc("/mdel", api.MDelete).Methods("POST")
+	r.HandleFunc("/transaction", api.Transaction).Methods("POST")
+	r.HandleFunc("/script", api.Script).Methods("POST")
+	r.HandleFunc("/export", api.Export).Methods("GET")
+	r.HandleFunc("/import", api.Import).Methods("POST")
+	r.HandleFunc("/cluster", api.ClusterStatus).Methods("GET")
+	r.HandleFunc("/cluster", api.SetClusterNodes).Methods("PUT")
+	r.HandleFunc("/replication", api.ReplicationStatus).Methods("GET")
+	r.HandleFunc("/replication/stream", api.ReplicationStream).Methods("GET")
+	r.HandleFunc("/replication/promote", api.Promote).Methods("POST")
+	r.HandleFunc("/events", api.Watch).Methods("GET")
+	r.HandleFunc("/channels/{channel}", api.Subscribe).Methods("GET")
+	r.HandleFunc("/channels/{channel}", api.Publish).Methods("POST")
+	r.HandleFunc("/locks/{name}", api.GetLock).Methods("GET")
+	r.HandleFunc("/locks/{name}", api.AcquireLock).Methods("POST")
+	r.HandleFunc("/locks/{name}/renew", api.RenewLock).Methods("POST")
+	r.HandleFunc("/locks/{name}/release", api.ReleaseLock).Methods("POST")
 }
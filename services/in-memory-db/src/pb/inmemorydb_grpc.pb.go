@@ -0,0 +1,401 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.2.0
+// - protoc             (unknown)
+// source: inmemorydb.proto
+
+package pb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+// InMemoryDBClient is the client API for InMemoryDB service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type InMemoryDBClient interface {
+	// Get returns the value of a key.
+	Get(ctx context.Context, in *GetRequest, opts ...grpc.CallOption) (*Value, error)
+	// Set stores a value and returns it with its new version.
+	Set(ctx context.Context, in *SetRequest, opts ...grpc.CallOption) (*Value, error)
+	// Delete deletes a key.
+	Delete(ctx context.Context, in *DeleteRequest, opts ...grpc.CallOption) (*DeleteResponse, error)
+	// Keys lists all keys of a realm.
+	Keys(ctx context.Context, in *KeysRequest, opts ...grpc.CallOption) (*KeysResponse, error)
+	// Realms lists all realms.
+	Realms(ctx context.Context, in *RealmsRequest, opts ...grpc.CallOption) (*RealmsResponse, error)
+	// TTL returns the remaining time to live of a key.
+	TTL(ctx context.Context, in *TTLRequest, opts ...grpc.CallOption) (*TTLResponse, error)
+	// Expire changes the time to live of a key without changing its value.
+	Expire(ctx context.Context, in *ExpireRequest, opts ...grpc.CallOption) (*Value, error)
+	// Watch streams changes of all keys matching the realm and key patterns.
+	Watch(ctx context.Context, in *WatchRequest, opts ...grpc.CallOption) (InMemoryDB_WatchClient, error)
+}
+
+type inMemoryDBClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewInMemoryDBClient(cc grpc.ClientConnInterface) InMemoryDBClient {
+	return &inMemoryDBClient{cc}
+}
+
+func (c *inMemoryDBClient) Get(ctx context.Context, in *GetRequest, opts ...grpc.CallOption) (*Value, error) {
+	out := new(Value)
+	err := c.cc.Invoke(ctx, "/inmemorydb.v1.InMemoryDB/Get", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *inMemoryDBClient) Set(ctx context.Context, in *SetRequest, opts ...grpc.CallOption) (*Value, error) {
+	out := new(Value)
+	err := c.cc.Invoke(ctx, "/inmemorydb.v1.InMemoryDB/Set", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *inMemoryDBClient) Delete(ctx context.Context, in *DeleteRequest, opts ...grpc.CallOption) (*DeleteResponse, error) {
+	out := new(DeleteResponse)
+	err := c.cc.Invoke(ctx, "/inmemorydb.v1.InMemoryDB/Delete", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *inMemoryDBClient) Keys(ctx context.Context, in *KeysRequest, opts ...grpc.CallOption) (*KeysResponse, error) {
+	out := new(KeysResponse)
+	err := c.cc.Invoke(ctx, "/inmemorydb.v1.InMemoryDB/Keys", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *inMemoryDBClient) Realms(ctx context.Context, in *RealmsRequest, opts ...grpc.CallOption) (*RealmsResponse, error) {
+	out := new(RealmsResponse)
+	err := c.cc.Invoke(ctx, "/inmemorydb.v1.InMemoryDB/Realms", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *inMemoryDBClient) TTL(ctx context.Context, in *TTLRequest, opts ...grpc.CallOption) (*TTLResponse, error) {
+	out := new(TTLResponse)
+	err := c.cc.Invoke(ctx, "/inmemorydb.v1.InMemoryDB/TTL", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *inMemoryDBClient) Expire(ctx context.Context, in *ExpireRequest, opts ...grpc.CallOption) (*Value, error) {
+	out := new(Value)
+	err := c.cc.Invoke(ctx, "/inmemorydb.v1.InMemoryDB/Expire", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *inMemoryDBClient) Watch(ctx context.Context, in *WatchRequest, opts ...grpc.CallOption) (InMemoryDB_WatchClient, error) {
+	stream, err := c.cc.NewStream(ctx, &InMemoryDB_ServiceDesc.Streams[0], "/inmemorydb.v1.InMemoryDB/Watch", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &inMemoryDBWatchClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type InMemoryDB_WatchClient interface {
+	Recv() (*Event, error)
+	grpc.ClientStream
+}
+
+type inMemoryDBWatchClient struct {
+	grpc.ClientStream
+}
+
+func (x *inMemoryDBWatchClient) Recv() (*Event, error) {
+	m := new(Event)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// InMemoryDBServer is the server API for InMemoryDB service.
+// All implementations must embed UnimplementedInMemoryDBServer
+// for forward compatibility
+type InMemoryDBServer interface {
+	// Get returns the value of a key.
+	Get(context.Context, *GetRequest) (*Value, error)
+	// Set stores a value and returns it with its new version.
+	Set(context.Context, *SetRequest) (*Value, error)
+	// Delete deletes a key.
+	Delete(context.Context, *DeleteRequest) (*DeleteResponse, error)
+	// Keys lists all keys of a realm.
+	Keys(context.Context, *KeysRequest) (*KeysResponse, error)
+	// Realms lists all realms.
+	Realms(context.Context, *RealmsRequest) (*RealmsResponse, error)
+	// TTL returns the remaining time to live of a key.
+	TTL(context.Context, *TTLRequest) (*TTLResponse, error)
+	// Expire changes the time to live of a key without changing its value.
+	Expire(context.Context, *ExpireRequest) (*Value, error)
+	// Watch streams changes of all keys matching the realm and key patterns.
+	Watch(*WatchRequest, InMemoryDB_WatchServer) error
+	mustEmbedUnimplementedInMemoryDBServer()
+}
+
+// UnimplementedInMemoryDBServer must be embedded to have forward compatible implementations.
+type UnimplementedInMemoryDBServer struct {
+}
+
+func (UnimplementedInMemoryDBServer) Get(context.Context, *GetRequest) (*Value, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Get not implemented")
+}
+func (UnimplementedInMemoryDBServer) Set(context.Context, *SetRequest) (*Value, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Set not implemented")
+}
+func (UnimplementedInMemoryDBServer) Delete(context.Context, *DeleteRequest) (*DeleteResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Delete not implemented")
+}
+func (UnimplementedInMemoryDBServer) Keys(context.Context, *KeysRequest) (*KeysResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Keys not implemented")
+}
+func (UnimplementedInMemoryDBServer) Realms(context.Context, *RealmsRequest) (*RealmsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Realms not implemented")
+}
+func (UnimplementedInMemoryDBServer) TTL(context.Context, *TTLRequest) (*TTLResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method TTL not implemented")
+}
+func (UnimplementedInMemoryDBServer) Expire(context.Context, *ExpireRequest) (*Value, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Expire not implemented")
+}
+func (UnimplementedInMemoryDBServer) Watch(*WatchRequest, InMemoryDB_WatchServer) error {
+	return status.Errorf(codes.Unimplemented, "method Watch not implemented")
+}
+func (UnimplementedInMemoryDBServer) mustEmbedUnimplementedInMemoryDBServer() {}
+
+// UnsafeInMemoryDBServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to InMemoryDBServer will
+// result in compilation errors.
+type UnsafeInMemoryDBServer interface {
+	mustEmbedUnimplementedInMemoryDBServer()
+}
+
+func RegisterInMemoryDBServer(s grpc.ServiceRegistrar, srv InMemoryDBServer) {
+	s.RegisterService(&InMemoryDB_ServiceDesc, srv)
+}
+
+func _InMemoryDB_Get_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(InMemoryDBServer).Get(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/inmemorydb.v1.InMemoryDB/Get",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(InMemoryDBServer).Get(ctx, req.(*GetRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _InMemoryDB_Set_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SetRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(InMemoryDBServer).Set(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/inmemorydb.v1.InMemoryDB/Set",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(InMemoryDBServer).Set(ctx, req.(*SetRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _InMemoryDB_Delete_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeleteRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(InMemoryDBServer).Delete(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/inmemorydb.v1.InMemoryDB/Delete",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(InMemoryDBServer).Delete(ctx, req.(*DeleteRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _InMemoryDB_Keys_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(KeysRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(InMemoryDBServer).Keys(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/inmemorydb.v1.InMemoryDB/Keys",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(InMemoryDBServer).Keys(ctx, req.(*KeysRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _InMemoryDB_Realms_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RealmsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(InMemoryDBServer).Realms(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/inmemorydb.v1.InMemoryDB/Realms",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(InMemoryDBServer).Realms(ctx, req.(*RealmsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _InMemoryDB_TTL_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(TTLRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(InMemoryDBServer).TTL(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/inmemorydb.v1.InMemoryDB/TTL",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(InMemoryDBServer).TTL(ctx, req.(*TTLRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _InMemoryDB_Expire_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ExpireRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(InMemoryDBServer).Expire(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/inmemorydb.v1.InMemoryDB/Expire",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(InMemoryDBServer).Expire(ctx, req.(*ExpireRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _InMemoryDB_Watch_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(InMemoryDBServer).Watch(m, &inMemoryDBWatchServer{stream})
+}
+
+type InMemoryDB_WatchServer interface {
+	Send(*Event) error
+	grpc.ServerStream
+}
+
+type inMemoryDBWatchServer struct {
+	grpc.ServerStream
+}
+
+func (x *inMemoryDBWatchServer) Send(m *Event) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+// InMemoryDB_ServiceDesc is the grpc.ServiceDesc for InMemoryDB service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var InMemoryDB_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "inmemorydb.v1.InMemoryDB",
+	HandlerType: (*InMemoryDBServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Get",
+			Handler:    _InMemoryDB_Get_Handler,
+		},
+		{
+			MethodName: "Set",
+			Handler:    _InMemoryDB_Set_Handler,
+		},
+		{
+			MethodName: "Delete",
+			Handler:    _InMemoryDB_Delete_Handler,
+		},
+		{
+			MethodName: "Keys",
+			Handler:    _InMemoryDB_Keys_Handler,
+		},
+		{
+			MethodName: "Realms",
+			Handler:    _InMemoryDB_Realms_Handler,
+		},
+		{
+			MethodName: "TTL",
+			Handler:    _InMemoryDB_TTL_Handler,
+		},
+		{
+			MethodName: "Expire",
+			Handler:    _InMemoryDB_Expire_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Watch",
+			Handler:       _InMemoryDB_Watch_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "inmemorydb.proto",
+}
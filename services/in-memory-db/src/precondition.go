@@ -0,0 +1,112 @@
+/*
+precondition.go
+Implements conditional writes based on the If-Match and If-None-Match headers
+and the versions (ETags) of stored values.
+
+###################################################################################
+
+MIT License
+
+Copyright (c) 2020 Bruno Hautzenberger
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+//Precondition holds the entity tags of the If-Match and If-None-Match headers
+//of a request, which have to be met by the stored value before it may be
+//replaced.
+type Precondition struct {
+	IfMatch     []string
+	IfNoneMatch []string
+}
+
+//PreconditionFromRequest reads the If-Match and If-None-Match headers of
+//the given request.
+func PreconditionFromRequest(r *http.Request) Precondition {
+	return Precondition{
+		IfMatch:     parseETags(r.Header.Get("If-Match")),
+		IfNoneMatch: parseETags(r.Header.Get("If-None-Match")),
+	}
+}
+
+//PreconditionFromVersion creates a precondition, which requires the stored
+//value to have given version. Version 0 requires, that there is no value.
+func PreconditionFromVersion(version uint64) Precondition {
+	if version == 0 {
+		return Precondition{IfNoneMatch: []string{"*"}}
+	}
+
+	return Precondition{IfMatch: []string{(&Value{Version: version}).ETag()}}
+}
+
+//parseETags splits a comma separated list of entity tags and removes the weak
+//validator prefix, because versions are always compared strongly.
+func parseETags(header string) []string {
+	etags := make([]string, 0)
+
+	for _, etag := range strings.Split(header, ",") {
+		etag = strings.TrimPrefix(strings.TrimSpace(etag), "W/")
+		if len(etag) > 0 {
+			etags = append(etags, etag)
+		}
+	}
+
+	return etags
+}
+
+//matchETag checks if the given Value matches one of the given entity tags.
+//A nil Value never matches, "*" matches every existing Value.
+func matchETag(etags []string, value *Value) bool {
+	if value == nil {
+		return false
+	}
+
+	for _, etag := range etags {
+		if etag == "*" || etag == value.ETag() {
+			return true
+		}
+	}
+
+	return false
+}
+
+//IsEmpty returns true if the request did not define any precondition.
+func (p Precondition) IsEmpty() bool {
+	return len(p.IfMatch) == 0 && len(p.IfNoneMatch) == 0
+}
+
+//Check returns true if the given currently stored Value, which is nil if
+//there is none, meets this precondition.
+func (p Precondition) Check(current *Value) bool {
+	if len(p.IfMatch) > 0 && !matchETag(p.IfMatch, current) {
+		return false
+	}
+
+	if len(p.IfNoneMatch) > 0 && matchETag(p.IfNoneMatch, current) {
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,227 @@
+/*
+ratelimit.go
+Implements rate limiting using token buckets and sliding windows. The state
+of a rate limit is stored as value, so it expires using the same TTL
+machinery and is updated atomically in a transaction.
+
+###################################################################################
+
+MIT License
+
+Copyright (c) 2020 Bruno Hautzenberger
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"time"
+)
+
+//ErrInvalidRateLimit is returned, if the parameters of a rate limit are
+//invalid.
+var ErrInvalidRateLimit = errors.New("Invalid rate limit")
+
+//ErrNoRateLimitState is returned, if the stored value of a rate limit is no
+//state of given algorithm.
+var ErrNoRateLimitState = errors.New("Value is no state of the rate limit algorithm")
+
+//RateLimitAlgorithm defines how requests are counted.
+type RateLimitAlgorithm string
+
+const (
+	//RateLimitTokenBucket allows bursts of Limit requests. The bucket is
+	//refilled continuously, so it is full again after Window.
+	RateLimitTokenBucket RateLimitAlgorithm = "token-bucket"
+
+	//RateLimitSlidingWindow allows Limit requests within any Window. The
+	//requests of the previous window are weighted by the overlap with the
+	//sliding window, so the count is approximate.
+	RateLimitSlidingWindow RateLimitAlgorithm = "sliding-window"
+)
+
+//RateLimit defines a rate limit of Limit requests per Window. Every request
+//consumes Cost requests of the limit.
+type RateLimit struct {
+	Algorithm RateLimitAlgorithm
+	Limit     int64
+	Window    time.Duration
+	Cost      int64
+}
+
+//RateLimitResult is the result of a single request. Reset is the time until
+//the full limit is available again, RetryAfter the time until a denied
+//request would be allowed.
+type RateLimitResult struct {
+	Allowed    bool
+	Limit      int64
+	Remaining  int64
+	Reset      time.Duration
+	RetryAfter time.Duration
+}
+
+//tokenBucketState is the stored state of a token bucket. Updated is given in
+//unix nanoseconds.
+type tokenBucketState struct {
+	Tokens  float64 `json:"tokens"`
+	Updated int64   `json:"updated"`
+}
+
+//slidingWindowState is the stored state of a sliding window. Start is the
+//start of the current window in unix nanoseconds.
+type slidingWindowState struct {
+	Start    int64 `json:"start"`
+	Current  int64 `json:"current"`
+	Previous int64 `json:"previous"`
+}
+
+//Validate checks if this is a valid rate limit.
+func (l *RateLimit) Validate() error {
+	if l.Algorithm != RateLimitTokenBucket && l.Algorithm != RateLimitSlidingWindow {
+		return ErrInvalidRateLimit
+	}
+
+	if l.Limit <= 0 || l.Window <= 0 || l.Cost <= 0 || l.Cost > l.Limit {
+		return ErrInvalidRateLimit
+	}
+
+	return nil
+}
+
+//Apply counts a single request to the rate limit stored in given realm and
+//key. The state is only written, if the request is allowed.
+func (l *RateLimit) Apply(tx *Transaction, realm string, key string, now time.Time) (RateLimitResult, error) {
+	if err := l.Validate(); err != nil {
+		return RateLimitResult{}, err
+	}
+
+	_, current := tx.Get(realm, key)
+
+	if l.Algorithm == RateLimitTokenBucket {
+		return l.applyTokenBucket(tx, realm, key, current, now)
+	}
+
+	return l.applySlidingWindow(tx, realm, key, current, now)
+}
+
+//applyTokenBucket refills the bucket for the time since its last update and
+//takes Cost tokens out of it, if there are enough.
+func (l *RateLimit) applyTokenBucket(tx *Transaction, realm string, key string, current *Value, now time.Time) (RateLimitResult, error) {
+	state := tokenBucketState{Tokens: float64(l.Limit), Updated: now.UnixNano()}
+	if current != nil {
+		if err := json.Unmarshal([]byte(current.Value), &state); err != nil {
+			return RateLimitResult{}, ErrNoRateLimitState
+		}
+	}
+
+	// tokens per nanosecond
+	rate := float64(l.Limit) / float64(l.Window)
+	elapsed := float64(now.UnixNano() - state.Updated)
+	if elapsed > 0 {
+		state.Tokens = math.Min(float64(l.Limit), state.Tokens+elapsed*rate)
+	}
+	state.Updated = now.UnixNano()
+
+	result := RateLimitResult{Limit: l.Limit}
+	if state.Tokens >= float64(l.Cost) {
+		state.Tokens -= float64(l.Cost)
+		result.Allowed = true
+	} else {
+		result.RetryAfter = time.Duration(math.Ceil((float64(l.Cost) - state.Tokens) / rate))
+	}
+
+	result.Remaining = int64(math.Floor(state.Tokens))
+	result.Reset = time.Duration(math.Ceil((float64(l.Limit) - state.Tokens) / rate))
+
+	if !result.Allowed {
+		return result, nil
+	}
+
+	// a full bucket needs no state, so it expires as soon as it is full
+	return result, l.store(tx, realm, key, state, now.Add(result.Reset))
+}
+
+//applySlidingWindow counts Cost requests in the current window, if the
+//weighted count of the current and the previous window allows it.
+func (l *RateLimit) applySlidingWindow(tx *Transaction, realm string, key string, current *Value, now time.Time) (RateLimitResult, error) {
+	window := int64(l.Window)
+	start := now.UnixNano() / window * window
+
+	state := slidingWindowState{Start: start}
+	if current != nil {
+		if err := json.Unmarshal([]byte(current.Value), &state); err != nil {
+			return RateLimitResult{}, ErrNoRateLimitState
+		}
+	}
+
+	switch state.Start {
+	case start:
+	case start - window:
+		state = slidingWindowState{Start: start, Previous: state.Current}
+	default:
+		state = slidingWindowState{Start: start}
+	}
+
+	elapsed := now.UnixNano() - start
+	weight := 1 - float64(elapsed)/float64(window)
+	count := float64(state.Previous)*weight + float64(state.Current)
+
+	result := RateLimitResult{Limit: l.Limit}
+	if count+float64(l.Cost) <= float64(l.Limit) {
+		state.Current += l.Cost
+		count += float64(l.Cost)
+		result.Allowed = true
+	} else {
+		// the previous window has to weigh less, or the next window must start
+		allowed := float64(l.Limit - l.Cost - state.Current)
+		if allowed >= 0 && state.Previous > 0 {
+			result.RetryAfter = time.Duration(math.Ceil(float64(window)*(1-allowed/float64(state.Previous)))) - time.Duration(elapsed)
+		} else {
+			result.RetryAfter = time.Duration(window - elapsed)
+		}
+	}
+
+	result.Remaining = int64(math.Max(0, math.Floor(float64(l.Limit)-count)))
+	result.Reset = time.Duration(window - elapsed)
+	if state.Current > 0 {
+		result.Reset += l.Window
+	}
+
+	if !result.Allowed {
+		return result, nil
+	}
+
+	return result, l.store(tx, realm, key, state, now.Add(result.Reset))
+}
+
+//store writes the state of the rate limit, which expires at given time.
+func (l *RateLimit) store(tx *Transaction, realm string, key string, state interface{}, expiresAt time.Time) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	return tx.Set(realm, key, &Value{
+		Value:     string(data),
+		ExpiresAt: expiresAt,
+	})
+}
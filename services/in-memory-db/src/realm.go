@@ -0,0 +1,224 @@
+/*
+realm.go
+Implements the configuration of realms. Realms are created implicitly when a
+value is stored, but they can also be created explicitly with a configuration,
+that defines default and maximum TTLs as well as quotas for their keys and
+values.
+
+###################################################################################
+
+MIT License
+
+Copyright (c) 2020 Bruno Hautzenberger
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+package main
+
+import (
+	"errors"
+	"strings"
+	"time"
+)
+
+//ErrValueTooLarge is returned, if a value exceeds the maximum value size of
+//its realm.
+var ErrValueTooLarge = errors.New("Value exceeds the maximum value size of the realm")
+
+//ErrTTLTooLong is returned, if a value expires later than the maximum TTL of
+//its realm allows.
+var ErrTTLTooLong = errors.New("Expiration exceeds the maximum TTL of the realm")
+
+//ErrRealmKeyLimit is returned, if the maximum number of keys of a realm is
+//reached and no key can be evicted.
+var ErrRealmKeyLimit = errors.New("Maximum number of keys of the realm reached")
+
+//ErrInvalidRealmConfig is returned, if a realm configuration is invalid.
+var ErrInvalidRealmConfig = errors.New("Invalid realm configuration")
+
+//RealmConfig holds the configuration of a realm. TTLs are given in seconds.
+//0 means, that there is no limit or default. DefaultTTL is used for values,
+//which are stored without expiration. EvictionPolicy defines what happens, if
+//MaxKeys is reached. Realms using EvictionPolicyNoEviction are also never
+//evicted to free memory. Indexes are dot separated paths of fields of JSON
+//values, which can be queried.
+type RealmConfig struct {
+	DefaultTTL     int            `json:"default-ttl"`
+	MaxTTL         int            `json:"max-ttl"`
+	MaxKeys        int            `json:"max-keys"`
+	MaxValueSize   int            `json:"max-value-size"`
+	EvictionPolicy EvictionPolicy `json:"eviction-policy,omitempty"`
+	Indexes        []string       `json:"indexes,omitempty"`
+}
+
+//Validate checks if this is a valid realm configuration.
+func (c *RealmConfig) Validate() error {
+	if c.DefaultTTL < 0 || c.MaxTTL < 0 || c.MaxKeys < 0 || c.MaxValueSize < 0 {
+		return ErrInvalidRealmConfig
+	}
+
+	if c.MaxTTL > 0 && c.DefaultTTL > c.MaxTTL {
+		return ErrInvalidRealmConfig
+	}
+
+	if len(c.EvictionPolicy) > 0 && !c.EvictionPolicy.IsValid() {
+		return ErrInvalidRealmConfig
+	}
+
+	fields := make(map[string]bool, len(c.Indexes))
+	for _, field := range c.Indexes {
+		if fields[field] || len(field) == 0 || strings.HasPrefix(field, ".") || strings.HasSuffix(field, ".") || strings.Contains(field, "..") {
+			return ErrInvalidRealmConfig
+		}
+		fields[field] = true
+	}
+
+	return nil
+}
+
+//evictionPolicy returns the eviction policy used, if the maximum number of
+//keys is reached.
+func (c *RealmConfig) evictionPolicy() EvictionPolicy {
+	if len(c.EvictionPolicy) == 0 {
+		return EvictionPolicyNoEviction
+	}
+
+	return c.EvictionPolicy
+}
+
+//GetRealmConfig returns the configuration of a realm. It returns false, if the
+//realm neither exists nor is configured.
+func (s *Storage) GetRealmConfig(realmName string) (bool, RealmConfig) {
+	s.MutexLock.RLock()
+	defer s.MutexLock.RUnlock()
+
+	if config, ok := s.RealmConfigs[realmName]; ok {
+		return true, *config
+	}
+
+	_, ok := s.Data[realmName]
+	return ok, RealmConfig{}
+}
+
+//SetRealmConfig creates a realm, if it does not exist yet, and sets its
+//configuration. Stored values are not changed, the limits only apply to
+//values stored from now on.
+func (s *Storage) SetRealmConfig(realmName string, config RealmConfig) error {
+	if err := config.Validate(); err != nil {
+		return err
+	}
+
+	s.MutexLock.Lock()
+	defer s.MutexLock.Unlock()
+
+	s.configureRealm(realmName, config)
+
+	return nil
+}
+
+//configureRealm sets the configuration of a realm without locking the
+//storage.
+func (s *Storage) configureRealm(realmName string, config RealmConfig) {
+	s.RealmConfigs[realmName] = &config
+	s.CreateRealm(realmName)
+	s.buildIndex(realmName)
+	s.notifyRealm(EventTypeConfigure, realmName, &config)
+}
+
+//unconfigureRealm resets a realm to the defaults without deleting its values
+//and without locking the storage.
+func (s *Storage) unconfigureRealm(realmName string) {
+	delete(s.RealmConfigs, realmName)
+	s.buildIndex(realmName)
+	s.CleanEmptyRealm(realmName)
+	s.notifyRealm(EventTypeConfigure, realmName, nil)
+}
+
+//DeleteRealm deletes a realm, all of its values and its configuration.
+//It returns false, if the realm did not exist.
+func (s *Storage) DeleteRealm(realmName string) bool {
+	s.MutexLock.Lock()
+	defer s.MutexLock.Unlock()
+
+	return s.dropRealm(realmName)
+}
+
+//dropRealm deletes a realm without locking the storage.
+func (s *Storage) dropRealm(realmName string) bool {
+	_, configured := s.RealmConfigs[realmName]
+	delete(s.RealmConfigs, realmName)
+	s.buildIndex(realmName)
+
+	ok, realm := s.GetRealm(realmName)
+	for key := range realm {
+		s.delete(realmName, key)
+		s.notify(EventTypeDelete, realmName, key, nil)
+	}
+	s.CleanEmptyRealm(realmName)
+
+	if ok || configured {
+		s.notifyRealm(EventTypeDrop, realmName, nil)
+	}
+
+	return ok || configured
+}
+
+//prepareValue applies the default TTL of the realm to a Value without
+//expiration and checks the limits of the realm.
+func (s *Storage) prepareValue(realmName string, value *Value) error {
+	config, ok := s.RealmConfigs[realmName]
+	if !ok {
+		config = &RealmConfig{}
+	}
+
+	if value.ExpiresAt.IsZero() {
+		value.ExpiresAt = time.Now().UTC().Add(time.Duration(config.DefaultTTL) * time.Second)
+	}
+
+	if config.MaxValueSize > 0 && len(value.Value) > config.MaxValueSize {
+		return ErrValueTooLarge
+	}
+
+	maxExpiresAt := time.Now().UTC().Add(time.Duration(config.MaxTTL) * time.Second)
+	if config.MaxTTL > 0 && value.ExpiresAt.After(maxExpiresAt) {
+		return ErrTTLTooLong
+	}
+
+	return nil
+}
+
+//reserveRealmKeys makes sure, that given number of keys can be added to a
+//realm without exceeding its maximum number of keys, by planning to evict
+//keys according to the eviction policy of the realm.
+func (p *evictionPlan) reserveRealmKeys(realmName string, keys int) error {
+	s := p.storage
+	config, ok := s.RealmConfigs[realmName]
+	if !ok || config.MaxKeys == 0 {
+		return nil
+	}
+
+	for len(s.Data[realmName])-p.freedKeys[realmName]+keys > config.MaxKeys {
+		if config.evictionPolicy() == EvictionPolicyNoEviction || !p.add(config.evictionPolicy(), realmName) {
+			s.Stats.RejectedWrites++
+			return ErrRealmKeyLimit
+		}
+	}
+
+	return nil
+}
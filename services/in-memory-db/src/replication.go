@@ -0,0 +1,464 @@
+/*
+replication.go
+Implements primary/replica replication. A replica streams a full snapshot of
+the primary and all following changes, which are published by the storage as
+events anyway. Replicas serve reads, reject writes and can be promoted to
+become a primary themselves.
+
+###################################################################################
+
+MIT License
+
+Copyright (c) 2020 Bruno Hautzenberger
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	//EventTypeSynced marks the end of the snapshot in the replication stream.
+	EventTypeSynced EventType = "synced"
+
+	//EventTypePing is sent to idle replication streams, so proxies do not
+	//close the connection.
+	EventTypePing EventType = "ping"
+
+	//EventTypeToken carries the last fencing token of locks as version.
+	EventTypeToken EventType = "token"
+
+	//EventTypeVersion carries the last version assigned to a value, so
+	//versions of deleted or expired values are never assigned again.
+	EventTypeVersion EventType = "version"
+
+	//EventTypeLock carries a lock, which was acquired or renewed. The name is
+	//sent as key and the fencing token as version.
+	EventTypeLock EventType = "lock"
+
+	//EventTypeUnlock carries the name of a lock, which was released or
+	//expired, as key.
+	EventTypeUnlock EventType = "unlock"
+
+	ReplicationRolePrimary = "primary"
+	ReplicationRoleReplica = "replica"
+
+	//maxReconnectDelay is the maximum delay between two attempts of a replica
+	//to connect to its primary.
+	maxReconnectDelay = 30 * time.Second
+)
+
+//ToReplicationMessageType transforms an Event to a ReplicationMessageType,
+//that can be sent to replicas.
+func (e *Event) ToReplicationMessageType() ReplicationMessageType {
+	msg := ReplicationMessageType{
+		Type:   e.Type,
+		Realm:  e.Realm,
+		Key:    e.Key,
+		Config: e.Config,
+	}
+
+	if e.Value != nil {
+		msg.Value, msg.Encoding = encodeValue(e.Value.Value)
+		msg.ContentType = e.Value.ContentType
+		msg.ExpiresInMs = e.Value.ExpiresAt.Sub(time.Now().UTC()).Nanoseconds() / int64(time.Millisecond)
+		msg.Version = e.Value.Version
+	}
+
+	if e.Type == EventTypeToken {
+		msg.Version = e.Token
+	}
+
+	if e.Type == EventTypeVersion {
+		msg.Version = e.Version
+	}
+
+	if e.Lock != nil {
+		msg.Holder = e.Lock.Holder
+		msg.Version = e.Lock.Token
+		msg.ExpiresInMs = e.Lock.ExpiresAt.Sub(time.Now().UTC()).Nanoseconds() / int64(time.Millisecond)
+	}
+
+	return msg
+}
+
+//isReplicationOnly checks if this event only keeps replicas and snapshots in
+//sync, so it is no keyspace notification.
+func (e *Event) isReplicationOnly() bool {
+	switch e.Type {
+	case EventTypeToken, EventTypeVersion, EventTypeLock, EventTypeUnlock:
+		return true
+	}
+
+	return false
+}
+
+//EventFromReplicationMessageType creates the Event of an operation received
+//from the primary.
+func EventFromReplicationMessageType(msg ReplicationMessageType) (*Event, error) {
+	event := &Event{
+		Type:   msg.Type,
+		Realm:  msg.Realm,
+		Key:    msg.Key,
+		Config: msg.Config,
+	}
+
+	if msg.Type == EventTypeSet {
+		value, err := decodeValue(msg.Value, msg.Encoding)
+		if err != nil {
+			return nil, err
+		}
+
+		event.Value = &Value{
+			Value:       value,
+			ContentType: msg.ContentType,
+			ExpiresAt:   time.Now().UTC().Add(time.Duration(msg.ExpiresInMs) * time.Millisecond),
+			Version:     msg.Version,
+		}
+	}
+
+	if msg.Type == EventTypeToken {
+		event.Token = msg.Version
+	}
+
+	if msg.Type == EventTypeVersion {
+		event.Version = msg.Version
+	}
+
+	if msg.Type == EventTypeLock {
+		event.Lock = &Lock{
+			Name:      msg.Key,
+			Holder:    msg.Holder,
+			Token:     msg.Version,
+			ExpiresAt: time.Now().UTC().Add(time.Duration(msg.ExpiresInMs) * time.Millisecond),
+		}
+	}
+
+	return event, nil
+}
+
+//Snapshot returns the configurations of all realms, all values and locks,
+//the last version and the last fencing token as events and subscribes to all
+//following changes at the same time, so no change is missed between the
+//snapshot and the subscription.
+func (s *Storage) Snapshot() ([]*Event, *Subscription) {
+	s.MutexLock.RLock()
+	defer s.MutexLock.RUnlock()
+
+	return s.exportState(), s.Events.Subscribe("*/*")
+}
+
+//exportState returns all realms and values like export, all locks and the
+//last version and fencing token, which are needed to restore the storage
+//from a snapshot.
+func (s *Storage) exportState() []*Event {
+	events := s.export("")
+	for name, lock := range s.Locks {
+		events = append(events, &Event{Type: EventTypeLock, Key: name, Lock: lock.copy()})
+	}
+
+	return append(events,
+		&Event{Type: EventTypeVersion, Version: s.LastVersion},
+		&Event{Type: EventTypeToken, Token: s.LastToken})
+}
+
+//Replace deletes all realms, values and locks and replaces them with the
+//given snapshot.
+func (s *Storage) Replace(snapshot []*Event) {
+	s.MutexLock.Lock()
+	defer s.MutexLock.Unlock()
+
+	for _, realm := range s.Data {
+		for _, value := range realm {
+			s.stopExpiration(value)
+		}
+	}
+	s.stopLocks()
+
+	s.Data = make(map[string]map[string]*Value)
+	s.RealmConfigs = make(map[string]*RealmConfig)
+	s.Indexes = make(map[string]realmIndex)
+	s.Locks = make(map[string]*Lock)
+	s.RealmNames = nil
+	s.KeyNames = make(map[string]*sortedNames)
+	s.UsedMemory = 0
+
+	for _, event := range snapshot {
+		s.apply(event)
+	}
+}
+
+//Apply applies a single change received from the primary.
+func (s *Storage) Apply(event *Event) {
+	s.MutexLock.Lock()
+	defer s.MutexLock.Unlock()
+
+	s.apply(event)
+}
+
+//apply applies a single change without locking the storage. Values are
+//stored as they are, because the primary already applied all limits and
+//evictions.
+func (s *Storage) apply(event *Event) {
+	switch event.Type {
+	case EventTypeSet:
+		_, current := s.get(event.Realm, event.Key)
+		s.store(event.Realm, event.Key, event.Value, current)
+		if event.Value.Version > s.LastVersion {
+			s.LastVersion = event.Value.Version
+		}
+	case EventTypeDelete, EventTypeExpire, EventTypeEvict:
+		if s.delete(event.Realm, event.Key) {
+			s.notify(event.Type, event.Realm, event.Key, nil)
+		}
+	case EventTypeConfigure:
+		if event.Config != nil {
+			s.configureRealm(event.Realm, *event.Config)
+		} else {
+			s.unconfigureRealm(event.Realm)
+		}
+	case EventTypeDrop:
+		s.dropRealm(event.Realm)
+	case EventTypeToken:
+		if event.Token > s.LastToken {
+			s.LastToken = event.Token
+		}
+	case EventTypeVersion:
+		if event.Version > s.LastVersion {
+			s.LastVersion = event.Version
+		}
+	case EventTypeLock:
+		s.storeLock(event.Lock)
+	case EventTypeUnlock:
+		s.deleteLock(event.Key)
+	}
+}
+
+//ReplicationInterface defines the interface for the replication of this
+//instance.
+type ReplicationInterface interface {
+	Initialize(config ReplicationConfig, storage StorageInterface)
+	IsReplica() bool
+	Promote() bool
+	Status() ReplicationStatusMessageType
+	ReplicaConnected()
+	ReplicaDisconnected()
+}
+
+//Replication implements ReplicationInterface. If a primary is configured, it
+//follows the primary in a go routine, until it is promoted.
+type Replication struct {
+	Config    ReplicationConfig
+	Storage   StorageInterface
+	Client    *http.Client
+	Replica   bool
+	Connected bool
+	Synced    bool
+	Replicas  int64
+	MutexLock sync.RWMutex
+	cancel    context.CancelFunc
+}
+
+//Initialize sets the configuration and starts to follow the primary, if one
+//is configured.
+func (r *Replication) Initialize(config ReplicationConfig, storage StorageInterface) {
+	r.Config = config
+	r.Storage = storage
+	r.Client = &http.Client{}
+
+	if len(config.PrimaryURL) == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	r.Replica = true
+	r.cancel = cancel
+
+	go r.follow(ctx)
+}
+
+//IsReplica returns true, if this instance is a replica.
+func (r *Replication) IsReplica() bool {
+	r.MutexLock.RLock()
+	defer r.MutexLock.RUnlock()
+
+	return r.Replica
+}
+
+//Promote stops following the primary and turns this replica into a primary.
+//It returns false, if this instance is not a replica.
+func (r *Replication) Promote() bool {
+	r.MutexLock.Lock()
+	defer r.MutexLock.Unlock()
+
+	if !r.Replica {
+		return false
+	}
+
+	r.cancel()
+	r.Replica = false
+	r.Connected = false
+	log.Printf("Promoted to primary\n")
+
+	return true
+}
+
+//Status returns the replication status of this instance.
+func (r *Replication) Status() ReplicationStatusMessageType {
+	r.MutexLock.RLock()
+	defer r.MutexLock.RUnlock()
+
+	status := ReplicationStatusMessageType{
+		Role:     ReplicationRolePrimary,
+		Replicas: atomic.LoadInt64(&r.Replicas),
+	}
+
+	if r.Replica {
+		status.Role = ReplicationRoleReplica
+		status.Primary = r.Config.PrimaryURL
+		status.Connected = r.Connected
+		status.Synced = r.Synced
+	}
+
+	return status
+}
+
+//ReplicaConnected counts a replica, that started to stream from this
+//instance.
+func (r *Replication) ReplicaConnected() {
+	atomic.AddInt64(&r.Replicas, 1)
+}
+
+//ReplicaDisconnected counts a replica, that stopped to stream from this
+//instance.
+func (r *Replication) ReplicaDisconnected() {
+	atomic.AddInt64(&r.Replicas, -1)
+}
+
+//setState sets the connection state of this replica.
+func (r *Replication) setState(connected bool, synced bool) {
+	r.MutexLock.Lock()
+	defer r.MutexLock.Unlock()
+
+	r.Connected = connected
+	r.Synced = synced
+}
+
+//follow streams the primary until the context is canceled. It reconnects
+//with an increasing delay, if the stream ends.
+func (r *Replication) follow(ctx context.Context) {
+	delay := time.Second
+
+	for {
+		err := r.stream(ctx)
+		if r.Status().Synced {
+			delay = time.Second
+		}
+		r.setState(false, false)
+
+		if ctx.Err() != nil {
+			return
+		}
+
+		log.Printf("Replication from %v interrupted: %v. Reconnecting in %v\n", r.Config.PrimaryURL, err, delay)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+
+		delay *= 2
+		if delay > maxReconnectDelay {
+			delay = maxReconnectDelay
+		}
+	}
+}
+
+//stream connects to the primary, replaces all data with its snapshot and
+//applies all following changes, until the stream ends.
+func (r *Replication) stream(ctx context.Context) error {
+	url := fmt.Sprintf("%v/v1/replication/stream", strings.TrimSuffix(r.Config.PrimaryURL, "/"))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+
+	if len(r.Config.Token) > 0 {
+		req.Header.Set("Authorization", "Bearer "+r.Config.Token)
+	}
+
+	resp, err := r.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Primary responded with status %v", resp.StatusCode)
+	}
+
+	r.setState(true, false)
+	log.Printf("Connected to primary %v\n", r.Config.PrimaryURL)
+
+	snapshot := make([]*Event, 0)
+	synced := false
+	decoder := json.NewDecoder(resp.Body)
+
+	for {
+		msg := ReplicationMessageType{}
+		if err := decoder.Decode(&msg); err != nil {
+			return err
+		}
+
+		if msg.Type == EventTypePing {
+			continue
+		}
+
+		if !synced && msg.Type == EventTypeSynced {
+			r.Storage.Replace(snapshot)
+			snapshot = nil
+			synced = true
+			r.setState(true, true)
+			log.Printf("Synced with primary %v\n", r.Config.PrimaryURL)
+			continue
+		}
+
+		event, err := EventFromReplicationMessageType(msg)
+		if err != nil {
+			return err
+		}
+
+		if !synced {
+			snapshot = append(snapshot, event)
+		} else if r.IsReplica() {
+			r.Storage.Apply(event)
+		}
+	}
+}
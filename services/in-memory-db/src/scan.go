@@ -0,0 +1,205 @@
+/*
+scan.go
+Implements cursor based scanning of keys and realms. Scans return the keys in
+sorted order and use the last returned key as cursor, so every key that exists
+during the whole scan is returned exactly once, no matter how many keys are
+added or expire in the meantime.
+
+###################################################################################
+
+MIT License
+
+Copyright (c) 2020 Bruno Hautzenberger
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+package main
+
+import (
+	"encoding/base64"
+	"path"
+	"sort"
+	"time"
+)
+
+//scanWorkFactor limits the number of names a single scan examines to this
+//multiple of its count, so scans for rare matches don't block the storage
+//for long. Such scans return fewer names than count.
+const scanWorkFactor = 10
+
+//ScanFilter holds all filters of a scan. Match is a pattern using the syntax
+//of path.Match. Type is a pattern of the same syntax, which filters keys by
+//the media type of their values, e.g. "image/*". MinTTL and MaxTTL filter
+//keys by their remaining time to live, 0 means that there is no limit.
+type ScanFilter struct {
+	Match  string
+	Type   string
+	MinTTL time.Duration
+	MaxTTL time.Duration
+}
+
+//matches checks if given name and value, which is nil for realms, match this
+//filter.
+func (f ScanFilter) matches(name string, value *Value, now time.Time) bool {
+	if len(f.Match) > 0 {
+		if ok, _ := path.Match(f.Match, name); !ok {
+			return false
+		}
+	}
+
+	if value != nil {
+		if len(f.Type) > 0 {
+			if ok, _ := path.Match(f.Type, value.MediaType()); !ok {
+				return false
+			}
+		}
+
+		ttl := value.ExpiresAt.Sub(now)
+		if f.MinTTL > 0 && ttl < f.MinTTL {
+			return false
+		}
+
+		if f.MaxTTL > 0 && ttl > f.MaxTTL {
+			return false
+		}
+	}
+
+	return true
+}
+
+//EncodeCursor creates the cursor for a scan, that continues after given name.
+func EncodeCursor(name string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(name))
+}
+
+//DecodeCursor returns the name a scan continues after.
+func DecodeCursor(cursor string) (string, error) {
+	name, err := base64.RawURLEncoding.DecodeString(cursor)
+	return string(name), err
+}
+
+//sortedNames is a sorted list of realm or key names, which is kept up to date
+//on every write, so scans continue after their cursor using a binary search
+//instead of walking all names. Adding and removing a name moves all
+//following names, so both are linear in the number of names. This is a
+//single memmove, but creating keys gets noticeably slower in realms with
+//millions of keys.
+type sortedNames []string
+
+//search returns the position of the first name, which is not less than
+//given name.
+func (n sortedNames) search(name string) int {
+	return sort.SearchStrings(n, name)
+}
+
+//add inserts a name, if it is not in the list yet.
+func (n *sortedNames) add(name string) {
+	i := n.search(name)
+	if i < len(*n) && (*n)[i] == name {
+		return
+	}
+
+	*n = append(*n, "")
+	copy((*n)[i+1:], (*n)[i:])
+	(*n)[i] = name
+}
+
+//remove removes a name, if it is in the list.
+func (n *sortedNames) remove(name string) {
+	i := n.search(name)
+	if i < len(*n) && (*n)[i] == name {
+		*n = append((*n)[:i], (*n)[i+1:]...)
+	}
+}
+
+//scanPage collects up to count names greater than after, that are accepted
+//by given function. It examines at most scanWorkFactor times count names, so
+//the page might contain fewer names. It returns the names and the cursor of
+//the next page, which continues after the last examined name and is empty if
+//there are no more names.
+func scanPage(names sortedNames, after string, count int, accept func(name string) bool) ([]string, string) {
+	page := make([]string, 0, count)
+	if count <= 0 {
+		return page, ""
+	}
+
+	i := names.search(after)
+	if i < len(names) && names[i] == after {
+		i++
+	}
+
+	for examined := 0; i < len(names) && len(page) < count && examined < count*scanWorkFactor; i++ {
+		examined++
+		if accept(names[i]) {
+			page = append(page, names[i])
+		}
+	}
+
+	if i == len(names) {
+		return page, ""
+	}
+
+	return page, EncodeCursor(names[i-1])
+}
+
+//Scan returns up to count keys of a realm, which follow the key encoded in
+//given cursor and match given filter, as well as the cursor of the next page.
+//Count is a hint, see scanPage. The cursor is empty, if there are no more
+//keys.
+func (s *Storage) Scan(realmName string, cursor string, count int, filter ScanFilter) ([]string, string, error) {
+	after, err := DecodeCursor(cursor)
+	if err != nil {
+		return nil, "", err
+	}
+
+	s.MutexLock.RLock()
+	defer s.MutexLock.RUnlock()
+
+	ok, realm := s.GetRealm(realmName)
+	if !ok {
+		return make([]string, 0), "", nil
+	}
+
+	now := time.Now().UTC()
+	keys, next := scanPage(*s.KeyNames[realmName], after, count, func(key string) bool {
+		return filter.matches(key, realm[key], now)
+	})
+
+	return keys, next, nil
+}
+
+//ScanRealms returns up to count realm names, which follow the realm encoded
+//in given cursor and match given filter, as well as the cursor of the next
+//page. The cursor is empty, if there are no more realms.
+func (s *Storage) ScanRealms(cursor string, count int, filter ScanFilter) ([]string, string, error) {
+	after, err := DecodeCursor(cursor)
+	if err != nil {
+		return nil, "", err
+	}
+
+	s.MutexLock.RLock()
+	defer s.MutexLock.RUnlock()
+
+	now := time.Now().UTC()
+	realms, next := scanPage(s.RealmNames, after, count, func(realmName string) bool {
+		return filter.matches(realmName, nil, now)
+	})
+
+	return realms, next, nil
+}
@@ -0,0 +1,174 @@
+/*
+scan_test.go
+Tests of cursor based scans of keys and realms.
+
+###################################################################################
+
+MIT License
+
+Copyright (c) 2020 Bruno Hautzenberger
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+//scanAll scans all keys of a realm page by page and returns all keys and the
+//number of pages.
+func scanAll(t *testing.T, s *Storage, realmName string, count int, filter ScanFilter) ([]string, int) {
+	t.Helper()
+
+	keys := []string{}
+	cursor := ""
+	for pages := 1; ; pages++ {
+		page, next, err := s.Scan(realmName, cursor, count, filter)
+		if err != nil {
+			t.Fatalf("Scan failed: %v", err)
+		}
+		if len(page) > count {
+			t.Fatalf("Scan returned %v keys, expected at most %v", len(page), count)
+		}
+
+		keys = append(keys, page...)
+		if len(next) == 0 {
+			return keys, pages
+		}
+		cursor = next
+	}
+}
+
+func TestScanPages(t *testing.T) {
+	s := newTestStorage(t, StorageConfig{})
+	for _, key := range []string{"e", "a", "d", "b", "c"} {
+		mustSet(t, s, "r", key, key)
+	}
+
+	keys, pages := scanAll(t, s, "r", 2, ScanFilter{})
+	if !reflect.DeepEqual(keys, []string{"a", "b", "c", "d", "e"}) || pages != 3 {
+		t.Errorf("Scanned %v in %v pages, expected [a b c d e] in 3 pages", keys, pages)
+	}
+
+	if keys, next, _ := s.Scan("missing", "", 10, ScanFilter{}); len(keys) > 0 || len(next) > 0 {
+		t.Errorf("Scan of missing realm returned %v with cursor %q", keys, next)
+	}
+}
+
+func TestScanWhileKeysChange(t *testing.T) {
+	s := newTestStorage(t, StorageConfig{})
+	for _, key := range []string{"a", "b", "c", "d"} {
+		mustSet(t, s, "r", key, key)
+	}
+
+	page, next, _ := s.Scan("r", "", 2, ScanFilter{})
+	if !reflect.DeepEqual(page, []string{"a", "b"}) {
+		t.Fatalf("First page is %v, expected [a b]", page)
+	}
+
+	// the cursor continues after b, even if b and c are deleted and keys
+	// are added before and after it
+	s.Delete("r", "b")
+	s.Delete("r", "c")
+	mustSet(t, s, "r", "0", "0")
+	mustSet(t, s, "r", "bb", "bb")
+
+	page, next, _ = s.Scan("r", next, 10, ScanFilter{})
+	if !reflect.DeepEqual(page, []string{"bb", "d"}) || len(next) > 0 {
+		t.Errorf("Second page is %v with cursor %q, expected [bb d] without cursor", page, next)
+	}
+}
+
+func TestScanFilters(t *testing.T) {
+	s := newTestStorage(t, StorageConfig{})
+	mustSet(t, s, "r", "session-1", "1")
+	mustSet(t, s, "r", "session-2", "2")
+	mustSet(t, s, "r", "user-1", "3")
+
+	short := NewValue("4", 10)
+	short.ContentType = "image/png"
+	s.Set("r", "session-short", short)
+
+	json := NewValue("{}", 30)
+	json.ContentType = "Application/JSON; charset=utf-8"
+	s.Set("r", "user-json", json)
+
+	tests := []struct {
+		name     string
+		filter   ScanFilter
+		expected []string
+	}{
+		{"match", ScanFilter{Match: "session-*"}, []string{"session-1", "session-2", "session-short"}},
+		{"min ttl", ScanFilter{MinTTL: 40 * time.Second}, []string{"session-1", "session-2", "user-1"}},
+		{"max ttl", ScanFilter{MaxTTL: 40 * time.Second}, []string{"session-short", "user-json"}},
+		{"type pattern", ScanFilter{Type: "image/*"}, []string{"session-short"}},
+		{"type with parameters", ScanFilter{Type: "application/json"}, []string{"user-json"}},
+		{"without type", ScanFilter{Type: "application/octet-stream"}, []string{"session-1", "session-2", "user-1"}},
+		{"match and type", ScanFilter{Match: "user-*", Type: "application/*"}, []string{"user-1", "user-json"}},
+	}
+
+	for _, test := range tests {
+		keys, _ := scanAll(t, s, "r", 2, test.filter)
+		if !reflect.DeepEqual(keys, test.expected) {
+			t.Errorf("%v: Scanned %v, expected %v", test.name, keys, test.expected)
+		}
+	}
+}
+
+func TestScanLimitsExaminedKeys(t *testing.T) {
+	s := newTestStorage(t, StorageConfig{})
+	for i := 0; i < 100; i++ {
+		mustSet(t, s, "r", fmt.Sprintf("k%03d", i), "v")
+	}
+	mustSet(t, s, "r", "match", "v")
+
+	// only the last key matches, so the first pages are empty
+	page, next, _ := s.Scan("r", "", 2, ScanFilter{Match: "m*"})
+	if len(page) > 0 || next != EncodeCursor("k019") {
+		t.Errorf("First page is %v with cursor %q, expected an empty page continuing after k019", page, next)
+	}
+
+	keys, pages := scanAll(t, s, "r", 2, ScanFilter{Match: "m*"})
+	if !reflect.DeepEqual(keys, []string{"match"}) || pages != 6 {
+		t.Errorf("Scanned %v in %v pages, expected [match] in 6 pages", keys, pages)
+	}
+}
+
+func TestScanRealms(t *testing.T) {
+	s := newTestStorage(t, StorageConfig{})
+	mustSet(t, s, "b", "k", "v")
+	mustSet(t, s, "a", "k", "v")
+	if err := s.SetRealmConfig("c", RealmConfig{}); err != nil {
+		t.Fatal(err)
+	}
+	s.Delete("b", "k")
+
+	realms, next, err := s.ScanRealms("", 10, ScanFilter{})
+	if err != nil || !reflect.DeepEqual(realms, []string{"a", "c"}) || len(next) > 0 {
+		t.Errorf("ScanRealms returned %v with cursor %q and error %v, expected [a c]", realms, next, err)
+	}
+
+	if _, _, err := s.ScanRealms("not base64!", 10, ScanFilter{}); err == nil {
+		t.Error("ScanRealms with invalid cursor succeeded")
+	}
+}
@@ -0,0 +1,941 @@
+/*
+script.go
+Implements a small sandboxed scripting language. Scripts run atomically in a
+transaction, can only access the keys declared with them and are limited in
+the number of steps, the time they run and the memory they allocate.
+Embeddable languages like Starlark or expr were not used, because scripts run
+while the storage is locked and those have no way to bound the memory a
+script allocates, and their current versions need a newer go than this
+service is built with.
+
+###################################################################################
+
+MIT License
+
+Copyright (c) 2020 Bruno Hautzenberger
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+const (
+	//maxScriptLength is the maximum length of a script in bytes.
+	maxScriptLength = 16 * 1024
+
+	//maxScriptKeys is the maximum number of keys a script can access.
+	maxScriptKeys = 100
+
+	//maxScriptSteps is the maximum number of expressions and statements a
+	//script may evaluate.
+	maxScriptSteps = 100000
+
+	//maxScriptDuration is the maximum time a script may run, while the
+	//storage is locked.
+	maxScriptDuration = 100 * time.Millisecond
+
+	//maxScriptStringLength is the maximum length of a string a script may
+	//create in bytes.
+	maxScriptStringLength = 1024 * 1024
+
+	//maxScriptMemory is the maximum number of bytes of all strings a script
+	//may create.
+	maxScriptMemory = 64 * 1024 * 1024
+)
+
+//scriptError creates the error of a script, that failed while it was run.
+func scriptError(format string, args ...interface{}) error {
+	return ErrorMessage{fmt.Sprintf(format, args...), http.StatusUnprocessableEntity, ErrorCodeScriptError}
+}
+
+//scriptToken is a single token of a script.
+type scriptToken struct {
+	Kind  string
+	Value string
+	Line  int
+}
+
+const (
+	tokenIdent  = "identifier"
+	tokenNumber = "number"
+	tokenString = "string"
+	tokenOp     = "operator"
+	tokenEOF    = "end of script"
+)
+
+//scriptOperators are all operators and punctuation, longest first.
+var scriptOperators = []string{"==", "!=", "<=", ">=", "&&", "||", "+", "-", "*", "/", "%", "<", ">", "!", "=", "(", ")", "{", "}", ",", ";"}
+
+//tokenize splits a script into tokens.
+func tokenize(script string) ([]scriptToken, error) {
+	tokens := make([]scriptToken, 0)
+	line := 1
+	runes := []rune(script)
+
+	for i := 0; i < len(runes); {
+		c := runes[i]
+		switch {
+		case c == '\n':
+			line++
+			i++
+		case unicode.IsSpace(c):
+			i++
+		case c == '#':
+			for i < len(runes) && runes[i] != '\n' {
+				i++
+			}
+		case unicode.IsLetter(c) || c == '_':
+			start := i
+			for i < len(runes) && (unicode.IsLetter(runes[i]) || unicode.IsDigit(runes[i]) || runes[i] == '_') {
+				i++
+			}
+			tokens = append(tokens, scriptToken{tokenIdent, string(runes[start:i]), line})
+		case unicode.IsDigit(c):
+			start := i
+			for i < len(runes) && (unicode.IsDigit(runes[i]) || runes[i] == '.') {
+				i++
+			}
+			tokens = append(tokens, scriptToken{tokenNumber, string(runes[start:i]), line})
+		case c == '"':
+			value := strings.Builder{}
+			i++
+			for ; i < len(runes) && runes[i] != '"'; i++ {
+				if runes[i] == '\n' {
+					return nil, fmt.Errorf("Unterminated string in line %v", line)
+				}
+				if runes[i] == '\\' && i+1 < len(runes) {
+					i++
+					switch runes[i] {
+					case 'n':
+						value.WriteRune('\n')
+					case 't':
+						value.WriteRune('\t')
+					default:
+						value.WriteRune(runes[i])
+					}
+					continue
+				}
+				value.WriteRune(runes[i])
+			}
+			if i >= len(runes) {
+				return nil, fmt.Errorf("Unterminated string in line %v", line)
+			}
+			i++
+			tokens = append(tokens, scriptToken{tokenString, value.String(), line})
+		default:
+			op := ""
+			for _, candidate := range scriptOperators {
+				if strings.HasPrefix(string(runes[i:]), candidate) {
+					op = candidate
+					break
+				}
+			}
+			if len(op) == 0 {
+				return nil, fmt.Errorf("Unexpected character %q in line %v", c, line)
+			}
+			i += len(op)
+			tokens = append(tokens, scriptToken{tokenOp, op, line})
+		}
+	}
+
+	return append(tokens, scriptToken{tokenEOF, "", line}), nil
+}
+
+//scriptExpr is an expression of a script.
+type scriptExpr interface {
+	eval(vm *scriptVM) (interface{}, error)
+}
+
+//scriptStmt is a statement of a script.
+type scriptStmt interface {
+	exec(vm *scriptVM) error
+}
+
+type literalExpr struct{ Value interface{} }
+type identExpr struct{ Name string }
+type unaryExpr struct {
+	Op string
+	X  scriptExpr
+}
+type binaryExpr struct {
+	Op   string
+	L, R scriptExpr
+}
+type callExpr struct {
+	Name string
+	Args []scriptExpr
+	Line int
+}
+
+type letStmt struct {
+	Name    string
+	Value   scriptExpr
+	Declare bool
+	Line    int
+}
+type ifStmt struct {
+	Cond scriptExpr
+	Then []scriptStmt
+	Else []scriptStmt
+}
+type whileStmt struct {
+	Cond scriptExpr
+	Body []scriptStmt
+}
+type returnStmt struct{ Value scriptExpr }
+type exprStmt struct{ X scriptExpr }
+
+//scriptParser parses tokens into statements using recursive descent.
+type scriptParser struct {
+	tokens []scriptToken
+	pos    int
+}
+
+//ParseScript parses a script into a list of statements.
+func ParseScript(script string) ([]scriptStmt, error) {
+	if len(script) > maxScriptLength {
+		return nil, fmt.Errorf("Script exceeds %v bytes", maxScriptLength)
+	}
+
+	tokens, err := tokenize(script)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &scriptParser{tokens: tokens}
+	return p.block(tokenEOF)
+}
+
+func (p *scriptParser) peek() scriptToken {
+	return p.tokens[p.pos]
+}
+
+func (p *scriptParser) next() scriptToken {
+	token := p.tokens[p.pos]
+	if token.Kind != tokenEOF {
+		p.pos++
+	}
+	return token
+}
+
+//is checks if the next token is given operator or keyword.
+func (p *scriptParser) is(value string) bool {
+	token := p.peek()
+	return (token.Kind == tokenOp || token.Kind == tokenIdent) && token.Value == value
+}
+
+func (p *scriptParser) expect(value string) error {
+	if !p.is(value) {
+		token := p.peek()
+		return fmt.Errorf("Expected %v but found %v %q in line %v", value, token.Kind, token.Value, token.Line)
+	}
+	p.next()
+	return nil
+}
+
+//block parses statements until given end token, which is either the end of
+//the script or a closing brace.
+func (p *scriptParser) block(end string) ([]scriptStmt, error) {
+	stmts := make([]scriptStmt, 0)
+	for {
+		if p.is(";") {
+			p.next()
+			continue
+		}
+
+		if (end == tokenEOF && p.peek().Kind == tokenEOF) || (end != tokenEOF && p.is(end)) {
+			return stmts, nil
+		}
+
+		if p.peek().Kind == tokenEOF {
+			return nil, fmt.Errorf("Expected %v but found end of script", end)
+		}
+
+		stmt, err := p.statement()
+		if err != nil {
+			return nil, err
+		}
+		stmts = append(stmts, stmt)
+	}
+}
+
+//body parses a block of statements in braces.
+func (p *scriptParser) body() ([]scriptStmt, error) {
+	if err := p.expect("{"); err != nil {
+		return nil, err
+	}
+
+	stmts, err := p.block("}")
+	if err != nil {
+		return nil, err
+	}
+
+	return stmts, p.expect("}")
+}
+
+func (p *scriptParser) statement() (scriptStmt, error) {
+	token := p.peek()
+
+	switch {
+	case p.is("let"):
+		p.next()
+		return p.assignment(true)
+	case p.is("if"):
+		return p.ifStatement()
+	case p.is("while"):
+		p.next()
+		cond, err := p.expression()
+		if err != nil {
+			return nil, err
+		}
+		body, err := p.body()
+		return &whileStmt{cond, body}, err
+	case p.is("return"):
+		p.next()
+		if p.is(";") || p.is("}") || p.peek().Kind == tokenEOF {
+			return &returnStmt{}, nil
+		}
+		value, err := p.expression()
+		return &returnStmt{value}, err
+	case token.Kind == tokenIdent && p.tokens[p.pos+1].Kind == tokenOp && p.tokens[p.pos+1].Value == "=":
+		return p.assignment(false)
+	}
+
+	x, err := p.expression()
+	return &exprStmt{x}, err
+}
+
+func (p *scriptParser) assignment(declare bool) (scriptStmt, error) {
+	name := p.next()
+	if name.Kind != tokenIdent {
+		return nil, fmt.Errorf("Expected variable name in line %v", name.Line)
+	}
+
+	if err := p.expect("="); err != nil {
+		return nil, err
+	}
+
+	value, err := p.expression()
+	return &letStmt{name.Value, value, declare, name.Line}, err
+}
+
+func (p *scriptParser) ifStatement() (scriptStmt, error) {
+	p.next()
+	cond, err := p.expression()
+	if err != nil {
+		return nil, err
+	}
+
+	then, err := p.body()
+	if err != nil {
+		return nil, err
+	}
+
+	stmt := &ifStmt{Cond: cond, Then: then}
+	if p.is("else") {
+		p.next()
+		if p.is("if") {
+			elseIf, err := p.ifStatement()
+			stmt.Else = []scriptStmt{elseIf}
+			return stmt, err
+		}
+		stmt.Else, err = p.body()
+	}
+
+	return stmt, err
+}
+
+//binaryLevels are the binary operators ordered by increasing precedence.
+var binaryLevels = [][]string{
+	{"||"},
+	{"&&"},
+	{"==", "!="},
+	{"<", "<=", ">", ">="},
+	{"+", "-"},
+	{"*", "/", "%"},
+}
+
+func (p *scriptParser) expression() (scriptExpr, error) {
+	return p.binary(0)
+}
+
+func (p *scriptParser) binary(level int) (scriptExpr, error) {
+	if level == len(binaryLevels) {
+		return p.unary()
+	}
+
+	left, err := p.binary(level + 1)
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		op := ""
+		for _, candidate := range binaryLevels[level] {
+			if p.peek().Kind == tokenOp && p.peek().Value == candidate {
+				op = candidate
+			}
+		}
+		if len(op) == 0 {
+			return left, nil
+		}
+		p.next()
+
+		right, err := p.binary(level + 1)
+		if err != nil {
+			return nil, err
+		}
+		left = &binaryExpr{op, left, right}
+	}
+}
+
+func (p *scriptParser) unary() (scriptExpr, error) {
+	if p.peek().Kind == tokenOp && (p.peek().Value == "-" || p.peek().Value == "!") {
+		op := p.next().Value
+		x, err := p.unary()
+		return &unaryExpr{op, x}, err
+	}
+
+	return p.primary()
+}
+
+func (p *scriptParser) primary() (scriptExpr, error) {
+	token := p.next()
+
+	switch token.Kind {
+	case tokenNumber:
+		n, err := strconv.ParseFloat(token.Value, 64)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid number %v in line %v", token.Value, token.Line)
+		}
+		return &literalExpr{n}, nil
+	case tokenString:
+		return &literalExpr{token.Value}, nil
+	case tokenIdent:
+		switch token.Value {
+		case "true":
+			return &literalExpr{true}, nil
+		case "false":
+			return &literalExpr{false}, nil
+		case "nil":
+			return &literalExpr{nil}, nil
+		}
+
+		if !p.is("(") {
+			return &identExpr{token.Value}, nil
+		}
+		p.next()
+
+		call := &callExpr{Name: token.Value, Line: token.Line}
+		for !p.is(")") {
+			arg, err := p.expression()
+			if err != nil {
+				return nil, err
+			}
+			call.Args = append(call.Args, arg)
+
+			if !p.is(",") {
+				break
+			}
+			p.next()
+		}
+		return call, p.expect(")")
+	case tokenOp:
+		if token.Value == "(" {
+			x, err := p.expression()
+			if err != nil {
+				return nil, err
+			}
+			return x, p.expect(")")
+		}
+	}
+
+	return nil, fmt.Errorf("Unexpected %v %q in line %v", token.Kind, token.Value, token.Line)
+}
+
+//scriptVM runs a parsed script in a transaction.
+type scriptVM struct {
+	tx       *Transaction
+	keys     []BatchEntryMessageType
+	args     []string
+	vars     map[string]interface{}
+	steps    int
+	memory   int
+	deadline time.Time
+	returned bool
+	result   interface{}
+}
+
+//RunScript runs a parsed script in given transaction. The script can only
+//access the given keys by their index and the given arguments. It returns
+//the value returned by the script.
+func RunScript(tx *Transaction, stmts []scriptStmt, keys []BatchEntryMessageType, args []string) (interface{}, error) {
+	vm := &scriptVM{
+		tx:       tx,
+		keys:     keys,
+		args:     args,
+		vars:     make(map[string]interface{}),
+		deadline: time.Now().Add(maxScriptDuration),
+	}
+
+	if err := vm.run(stmts); err != nil {
+		return nil, err
+	}
+
+	return vm.result, nil
+}
+
+//step counts a single step and checks the limits of the script.
+func (vm *scriptVM) step() error {
+	vm.steps++
+	if vm.steps > maxScriptSteps {
+		return ErrorMessage{fmt.Sprintf("Script exceeded %v steps", maxScriptSteps), http.StatusUnprocessableEntity, ErrorCodeScriptLimit}
+	}
+
+	if vm.steps%1000 == 0 && time.Now().After(vm.deadline) {
+		return ErrorMessage{fmt.Sprintf("Script exceeded %v", maxScriptDuration), http.StatusUnprocessableEntity, ErrorCodeScriptLimit}
+	}
+
+	return nil
+}
+
+//alloc counts a string of given length created by the script and checks the
+//memory limits of the script, before the string is created.
+func (vm *scriptVM) alloc(length int) error {
+	if length > maxScriptStringLength {
+		return ErrorMessage{fmt.Sprintf("Script exceeded %v bytes per string", maxScriptStringLength), http.StatusUnprocessableEntity, ErrorCodeScriptLimit}
+	}
+
+	vm.memory += length
+	if vm.memory > maxScriptMemory {
+		return ErrorMessage{fmt.Sprintf("Script exceeded %v bytes of memory", maxScriptMemory), http.StatusUnprocessableEntity, ErrorCodeScriptLimit}
+	}
+
+	return nil
+}
+
+func (vm *scriptVM) run(stmts []scriptStmt) error {
+	for _, stmt := range stmts {
+		if err := vm.step(); err != nil {
+			return err
+		}
+		if err := stmt.exec(vm); err != nil {
+			return err
+		}
+		if vm.returned {
+			return nil
+		}
+	}
+
+	return nil
+}
+
+func (s *letStmt) exec(vm *scriptVM) error {
+	if _, ok := vm.vars[s.Name]; !ok && !s.Declare {
+		return scriptError("Undefined variable %v in line %v", s.Name, s.Line)
+	}
+
+	value, err := s.Value.eval(vm)
+	vm.vars[s.Name] = value
+	return err
+}
+
+func (s *ifStmt) exec(vm *scriptVM) error {
+	cond, err := s.Cond.eval(vm)
+	if err != nil {
+		return err
+	}
+
+	if truthy(cond) {
+		return vm.run(s.Then)
+	}
+	return vm.run(s.Else)
+}
+
+func (s *whileStmt) exec(vm *scriptVM) error {
+	for {
+		if err := vm.step(); err != nil {
+			return err
+		}
+
+		cond, err := s.Cond.eval(vm)
+		if err != nil || !truthy(cond) {
+			return err
+		}
+
+		if err := vm.run(s.Body); err != nil || vm.returned {
+			return err
+		}
+	}
+}
+
+func (s *returnStmt) exec(vm *scriptVM) error {
+	vm.returned = true
+	if s.Value == nil {
+		return nil
+	}
+
+	value, err := s.Value.eval(vm)
+	vm.result = value
+	return err
+}
+
+func (s *exprStmt) exec(vm *scriptVM) error {
+	_, err := s.X.eval(vm)
+	return err
+}
+
+func (e *literalExpr) eval(vm *scriptVM) (interface{}, error) {
+	return e.Value, vm.step()
+}
+
+func (e *identExpr) eval(vm *scriptVM) (interface{}, error) {
+	value, ok := vm.vars[e.Name]
+	if !ok {
+		return nil, scriptError("Undefined variable %v", e.Name)
+	}
+	return value, vm.step()
+}
+
+func (e *unaryExpr) eval(vm *scriptVM) (interface{}, error) {
+	if err := vm.step(); err != nil {
+		return nil, err
+	}
+
+	x, err := e.X.eval(vm)
+	if err != nil {
+		return nil, err
+	}
+
+	if e.Op == "!" {
+		return !truthy(x), nil
+	}
+
+	n, ok := x.(float64)
+	if !ok {
+		return nil, scriptError("Operator - needs a number, not %v", typeName(x))
+	}
+	return -n, nil
+}
+
+func (e *binaryExpr) eval(vm *scriptVM) (interface{}, error) {
+	if err := vm.step(); err != nil {
+		return nil, err
+	}
+
+	l, err := e.L.eval(vm)
+	if err != nil {
+		return nil, err
+	}
+
+	// logical operators short-circuit
+	if e.Op == "&&" && !truthy(l) {
+		return false, nil
+	}
+	if e.Op == "||" && truthy(l) {
+		return true, nil
+	}
+
+	r, err := e.R.eval(vm)
+	if err != nil {
+		return nil, err
+	}
+
+	switch e.Op {
+	case "&&", "||":
+		return truthy(r), nil
+	case "==":
+		return l == r, nil
+	case "!=":
+		return l != r, nil
+	}
+
+	if e.Op == "+" {
+		_, lok := l.(string)
+		_, rok := r.(string)
+		if lok || rok {
+			ls, rs := toString(l), toString(r)
+			if err := vm.alloc(len(ls) + len(rs)); err != nil {
+				return nil, err
+			}
+			return ls + rs, nil
+		}
+	}
+
+	if ls, ok := l.(string); ok {
+		if rs, ok := r.(string); ok {
+			switch e.Op {
+			case "<":
+				return ls < rs, nil
+			case "<=":
+				return ls <= rs, nil
+			case ">":
+				return ls > rs, nil
+			case ">=":
+				return ls >= rs, nil
+			}
+		}
+	}
+
+	ln, lok := l.(float64)
+	rn, rok := r.(float64)
+	if !lok || !rok {
+		return nil, scriptError("Operator %v can't be used with %v and %v", e.Op, typeName(l), typeName(r))
+	}
+
+	switch e.Op {
+	case "+":
+		return ln + rn, nil
+	case "-":
+		return ln - rn, nil
+	case "*":
+		return ln * rn, nil
+	case "/", "%":
+		if rn == 0 {
+			return nil, scriptError("Division by zero")
+		}
+		if e.Op == "/" {
+			return ln / rn, nil
+		}
+		return math.Mod(ln, rn), nil
+	case "<":
+		return ln < rn, nil
+	case "<=":
+		return ln <= rn, nil
+	case ">":
+		return ln > rn, nil
+	}
+	return ln >= rn, nil
+}
+
+func (e *callExpr) eval(vm *scriptVM) (interface{}, error) {
+	if err := vm.step(); err != nil {
+		return nil, err
+	}
+
+	args := make([]interface{}, 0, len(e.Args))
+	for _, arg := range e.Args {
+		value, err := arg.eval(vm)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, value)
+	}
+
+	builtin, ok := scriptBuiltins[e.Name]
+	if !ok {
+		return nil, scriptError("Unknown function %v in line %v", e.Name, e.Line)
+	}
+
+	if len(args) < builtin.MinArgs || len(args) > builtin.MaxArgs {
+		return nil, scriptError("Wrong number of arguments for %v in line %v", e.Name, e.Line)
+	}
+
+	return builtin.Fn(vm, args)
+}
+
+//key returns the realm and key declared with given index.
+func (vm *scriptVM) key(index interface{}) (BatchEntryMessageType, error) {
+	// the range is checked before the conversion, which overflows otherwise
+	n, ok := index.(float64)
+	if !ok || n != math.Trunc(n) || n < 0 || n >= float64(len(vm.keys)) {
+		return BatchEntryMessageType{}, scriptError("Invalid key index %v", toString(index))
+	}
+
+	return vm.keys[int(n)], nil
+}
+
+//value loads the value of the key with given index.
+func (vm *scriptVM) value(index interface{}) (*Value, error) {
+	key, err := vm.key(index)
+	if err != nil {
+		return nil, err
+	}
+
+	_, value := vm.tx.Get(key.Realm, key.Key)
+	return value, nil
+}
+
+//scriptBuiltin is a function, that can be called by scripts.
+type scriptBuiltin struct {
+	MinArgs int
+	MaxArgs int
+	Fn      func(vm *scriptVM, args []interface{}) (interface{}, error)
+}
+
+//scriptBuiltins are all functions, that can be called by scripts. Keys are
+//passed as index of the declared keys.
+var scriptBuiltins map[string]scriptBuiltin
+
+func init() {
+	scriptBuiltins = map[string]scriptBuiltin{
+		"get": {1, 1, func(vm *scriptVM, args []interface{}) (interface{}, error) {
+			value, err := vm.value(args[0])
+			if err != nil || value == nil {
+				return nil, err
+			}
+			return value.Value, nil
+		}},
+		"exists": {1, 1, func(vm *scriptVM, args []interface{}) (interface{}, error) {
+			value, err := vm.value(args[0])
+			return value != nil, err
+		}},
+		"ttl": {1, 1, func(vm *scriptVM, args []interface{}) (interface{}, error) {
+			value, err := vm.value(args[0])
+			if err != nil || value == nil {
+				return nil, err
+			}
+			return math.Floor(value.ExpiresAt.Sub(time.Now().UTC()).Seconds()), nil
+		}},
+		"version": {1, 1, func(vm *scriptVM, args []interface{}) (interface{}, error) {
+			value, err := vm.value(args[0])
+			if err != nil || value == nil {
+				return nil, err
+			}
+			return float64(value.Version), nil
+		}},
+		"set": {2, 3, func(vm *scriptVM, args []interface{}) (interface{}, error) {
+			key, err := vm.key(args[0])
+			if err != nil {
+				return nil, err
+			}
+
+			value := &Value{Value: toString(args[1])}
+			if len(args) == 3 {
+				// ttl is validated like expires-in of SET, after it was checked
+				// to be an integer, which can be converted without overflow
+				ttl, ok := args[2].(float64)
+				if !ok || ttl != math.Trunc(ttl) || math.Abs(ttl) > math.MaxInt32 {
+					return nil, scriptError("Invalid ttl %v", toString(args[2]))
+				}
+
+				v := Validator{}
+				v.ExpiresIn("ttl", int(ttl))
+				if err := v.Err(); err != nil {
+					return nil, scriptError("Invalid ttl %v: %v", toString(args[2]), v.Fields[0].Message)
+				}
+
+				// like with SET, ttl 0 uses the default TTL of the realm
+				value = NewValue(value.Value, int(ttl))
+			} else if _, current := vm.tx.Get(key.Realm, key.Key); current != nil {
+				// keep the expiration of the current value
+				value.ExpiresAt = current.ExpiresAt
+			}
+
+			return nil, vm.tx.Set(key.Realm, key.Key, value)
+		}},
+		"del": {1, 1, func(vm *scriptVM, args []interface{}) (interface{}, error) {
+			key, err := vm.key(args[0])
+			if err != nil {
+				return nil, err
+			}
+			return vm.tx.Delete(key.Realm, key.Key), nil
+		}},
+		"arg": {1, 1, func(vm *scriptVM, args []interface{}) (interface{}, error) {
+			n, ok := args[0].(float64)
+			if !ok || n != math.Trunc(n) || n < 0 || n >= float64(len(vm.args)) {
+				return nil, scriptError("Invalid argument index %v", toString(args[0]))
+			}
+			return vm.args[int(n)], nil
+		}},
+		"len": {1, 1, func(vm *scriptVM, args []interface{}) (interface{}, error) {
+			s, ok := args[0].(string)
+			if !ok {
+				return nil, scriptError("len needs a string, not %v", typeName(args[0]))
+			}
+			return float64(len(s)), nil
+		}},
+		"num": {1, 1, func(vm *scriptVM, args []interface{}) (interface{}, error) {
+			switch v := args[0].(type) {
+			case float64:
+				return v, nil
+			case string:
+				n, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+				if err != nil {
+					return nil, scriptError("%q is no number", v)
+				}
+				return n, nil
+			case nil:
+				return 0.0, nil
+			}
+			return nil, scriptError("num can't convert %v", typeName(args[0]))
+		}},
+		"str": {1, 1, func(vm *scriptVM, args []interface{}) (interface{}, error) {
+			if s, ok := args[0].(string); ok {
+				return s, nil
+			}
+
+			s := toString(args[0])
+			return s, vm.alloc(len(s))
+		}},
+		"fail": {1, 1, func(vm *scriptVM, args []interface{}) (interface{}, error) {
+			return nil, ErrorMessage{toString(args[0]), http.StatusConflict, ErrorCodeScriptFailed}
+		}},
+	}
+}
+
+//truthy checks if a value counts as true. nil, false, 0 and "" are false.
+func truthy(value interface{}) bool {
+	switch v := value.(type) {
+	case nil:
+		return false
+	case bool:
+		return v
+	case float64:
+		return v != 0
+	case string:
+		return len(v) > 0
+	}
+	return true
+}
+
+//toString converts a value to a string. Whole numbers have no decimals.
+func toString(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	return fmt.Sprintf("%v", value)
+}
+
+//typeName returns the name of the type of a value used in errors.
+func typeName(value interface{}) string {
+	switch value.(type) {
+	case nil:
+		return "nil"
+	case bool:
+		return "bool"
+	case float64:
+		return "number"
+	}
+	return "string"
+}
@@ -0,0 +1,227 @@
+/*
+script_test.go
+Tests of the parser and interpreter of scripts.
+
+###################################################################################
+
+MIT License
+
+Copyright (c) 2020 Bruno Hautzenberger
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+//runTestScript parses and runs a script with the keys "k0", "k1", ... of
+//realm "scripts" and given arguments in a transaction of given storage.
+func runTestScript(t *testing.T, s *Storage, script string, keys int, args ...string) (interface{}, error) {
+	t.Helper()
+
+	stmts, err := ParseScript(script)
+	if err != nil {
+		t.Fatalf("ParseScript(%q) failed: %v", script, err)
+	}
+
+	entries := make([]BatchEntryMessageType, 0, keys)
+	for i := 0; i < keys; i++ {
+		entries = append(entries, BatchEntryMessageType{Realm: "scripts", Key: "k" + string(rune('0'+i))})
+	}
+
+	var result interface{}
+	err = s.Transaction(func(tx *Transaction) error {
+		var err error
+		result, err = RunScript(tx, stmts, entries, args)
+		return err
+	})
+
+	return result, err
+}
+
+//expectErrorCode fails the test, if err is no ErrorMessage with given code.
+func expectErrorCode(t *testing.T, err error, code ErrorCode) {
+	t.Helper()
+
+	msg, ok := err.(ErrorMessage)
+	if !ok || msg.Code != code {
+		t.Errorf("error is %v, expected code %v", err, code)
+	}
+}
+
+func TestParseScript(t *testing.T) {
+	valid := []string{
+		"",
+		"return 1",
+		"let x = 1; x = x + 2\nreturn x",
+		"# comment\nif x > 1 { return 1 } else if x < 0 { return 2 } else { return 3 }",
+		"while i < 10 { i = i + 1 }",
+		"return \"a \\\"quoted\\\" string\\n\"",
+		"return !(1 == 2) && -1 < 0 || false",
+		"set(0, get(0) + 1, 60)",
+	}
+	for _, script := range valid {
+		if _, err := ParseScript(script); err != nil {
+			t.Errorf("ParseScript(%q) failed: %v", script, err)
+		}
+	}
+
+	invalid := []string{
+		"return \"unterminated",
+		"let = 1",
+		"let x 1",
+		"if x { return 1",
+		"return (1 + 2",
+		"1 +",
+		"x = = 1",
+		"$",
+		strings.Repeat("x", maxScriptLength+1),
+	}
+	for _, script := range invalid {
+		if _, err := ParseScript(script); err == nil {
+			t.Errorf("ParseScript(%.40q) succeeded, expected an error", script)
+		}
+	}
+}
+
+func TestRunScriptExpressions(t *testing.T) {
+	s := newTestStorage(t, StorageConfig{})
+
+	tests := []struct {
+		script   string
+		expected interface{}
+	}{
+		{"return 1 + 2 * 3", 7.0},
+		{"return (1 + 2) * 3", 9.0},
+		{"return 7 % 4 - 10 / 4", 0.5},
+		{"return \"a\" + 1", "a1"},
+		{"return 1 < 2 && \"\" || nil", false},
+		{"return !0 == true", true},
+		{"let x = 1; x = x + 1; return x", 2.0},
+		{"let i = 0; while i < 10 { i = i + 1 }; return i", 10.0},
+		{"let x = 5; if x > 10 { return \"big\" } else if x > 1 { return \"medium\" } else { return \"small\" }", "medium"},
+		{"return num(arg(0)) + len(arg(1))", 45.0},
+		{"return str(42) + str(nil)", "42"},
+		{"let x = 1", nil},
+	}
+
+	for _, test := range tests {
+		result, err := runTestScript(t, s, test.script, 0, "42", "abc")
+		if err != nil {
+			t.Errorf("%q failed: %v", test.script, err)
+			continue
+		}
+
+		if result != test.expected {
+			t.Errorf("%q returned %#v, expected %#v", test.script, result, test.expected)
+		}
+	}
+}
+
+func TestRunScriptErrors(t *testing.T) {
+	s := newTestStorage(t, StorageConfig{})
+
+	tests := []struct {
+		script string
+		code   ErrorCode
+	}{
+		{"return x", ErrorCodeScriptError},
+		{"x = 1", ErrorCodeScriptError},
+		{"return 1 / 0", ErrorCodeScriptError},
+		{"return -\"a\"", ErrorCodeScriptError},
+		{"return unknown()", ErrorCodeScriptError},
+		{"return len()", ErrorCodeScriptError},
+		{"return get(1)", ErrorCodeScriptError},
+		{"return get(0.5)", ErrorCodeScriptError},
+		{"return arg(5)", ErrorCodeScriptError},
+		{"return num(\"abc\")", ErrorCodeScriptError},
+		{"fail(\"stop\")", ErrorCodeScriptFailed},
+		{"while true { }", ErrorCodeScriptLimit},
+		{"let x = \"x\"; while true { x = x + x }", ErrorCodeScriptLimit},
+	}
+
+	for _, test := range tests {
+		_, err := runTestScript(t, s, test.script, 1)
+		expectErrorCode(t, err, test.code)
+	}
+}
+
+func TestRunScriptKeys(t *testing.T) {
+	s := newTestStorage(t, StorageConfig{})
+	mustSet(t, s, "scripts", "k0", "41")
+
+	result, err := runTestScript(t, s, "let n = num(get(0)) + 1; set(0, n); set(1, \"new\", 60); return n", 2)
+	if err != nil || result != 42.0 {
+		t.Fatalf("script = %v, %v, expected 42", result, err)
+	}
+
+	expectValue(t, s, "scripts", "k0", "42")
+	expectValue(t, s, "scripts", "k1", "new")
+
+	result, err = runTestScript(t, s, "let existed = del(0); return existed && !exists(0)", 1)
+	if err != nil || result != true {
+		t.Errorf("script = %v, %v, expected true", result, err)
+	}
+	expectValue(t, s, "scripts", "k0", "")
+}
+
+func TestRunScriptIsAllOrNothing(t *testing.T) {
+	s := newTestStorage(t, StorageConfig{})
+	mustSet(t, s, "scripts", "k0", "old")
+
+	_, err := runTestScript(t, s, "set(0, \"new\"); del(1); fail(\"abort\")", 2)
+	expectErrorCode(t, err, ErrorCodeScriptFailed)
+	expectValue(t, s, "scripts", "k0", "old")
+}
+
+func TestRunScriptTTL(t *testing.T) {
+	s := newTestStorage(t, StorageConfig{})
+	if err := s.SetRealmConfig("scripts", RealmConfig{DefaultTTL: 600}); err != nil {
+		t.Fatalf("SetRealmConfig failed: %v", err)
+	}
+
+	// set without ttl uses the default TTL of the realm for new keys and
+	// keeps the TTL of existing keys
+	if _, err := runTestScript(t, s, "set(0, \"a\"); set(1, \"b\", 60); return ttl(0)", 2); err != nil {
+		t.Fatalf("script failed: %v", err)
+	}
+	result, err := runTestScript(t, s, "set(1, \"c\"); return ttl(0) > 590 && ttl(1) <= 60 && ttl(1) > 50", 2)
+	if err != nil || result != true {
+		t.Errorf("TTLs are wrong: %v, %v", result, err)
+	}
+
+	// ttl 0 uses the default TTL like expires-in of SET
+	result, err = runTestScript(t, s, "set(1, \"d\", 0); return ttl(1) > 590", 2)
+	if err != nil || result != true {
+		t.Errorf("ttl 0 did not use the default TTL: %v, %v", result, err)
+	}
+
+	for _, ttl := range []string{"-1", "0.5", "99999999999999999999", "\"60\"", "315360001"} {
+		_, err := runTestScript(t, s, "set(0, \"x\", "+ttl+")", 1)
+		expectErrorCode(t, err, ErrorCodeScriptError)
+	}
+
+	if ok, value := s.Get("scripts", "k0"); !ok || value.Value != "a" || time.Until(value.ExpiresAt) < 590*time.Second {
+		t.Errorf("invalid ttls changed the value: %+v", value)
+	}
+}
@@ -0,0 +1,169 @@
+/*
+snapshot.go
+Implements snapshots of the storage, which are written to a file on shutdown
+and loaded again on startup. Snapshots are dumps of all realms.
+
+###################################################################################
+
+MIT License
+
+Copyright (c) 2020 Bruno Hautzenberger
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+package main
+
+import (
+	"io"
+	"log"
+	"os"
+	"time"
+)
+
+//SaveSnapshot writes the configurations and values of all realms, all locks,
+//the last version and the last fencing token to given file. The snapshot is
+//written to a temporary file first, which replaces the file once it is synced,
+//so an interrupted snapshot never replaces the last complete one.
+func (s *Storage) SaveSnapshot(path string, format DumpFormat) error {
+	s.MutexLock.RLock()
+	events := s.exportState()
+	s.MutexLock.RUnlock()
+
+	tmp := path + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+
+	written, err := writeSnapshot(file, format, events)
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+
+	log.Printf("Saved snapshot of %v realms and values to %v\n", written, path)
+	return nil
+}
+
+//writeSnapshot encodes all events, that are not expired yet, syncs the file
+//and returns the number of written events.
+func writeSnapshot(file *os.File, format DumpFormat, events []*Event) (int, error) {
+	encoder, err := format.NewDumpEncoder(file)
+	if err != nil {
+		return 0, err
+	}
+
+	written := 0
+	for _, event := range events {
+		msg := event.ToReplicationMessageType()
+		if (event.Type == EventTypeSet || event.Type == EventTypeLock) && msg.ExpiresInMs <= 0 {
+			continue
+		}
+
+		if err := encoder.Encode(msg); err != nil {
+			return written, err
+		}
+		written++
+	}
+
+	return written, file.Sync()
+}
+
+//LoadSnapshot replaces all realms, values and locks with the snapshot in
+//given file. The remaining TTL of the values and leases of the locks are
+//reduced by the time since the snapshot was written, so values and locks which
+//expired in the meantime are not loaded. Values keep their versions and the
+//last version is restored, so versions are never assigned twice. Nothing is
+//loaded, if the file does not exist.
+func (s *Storage) LoadSnapshot(path string, format DumpFormat) error {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	elapsed := time.Since(info.ModTime())
+
+	decoder, err := format.NewDumpDecoder(file)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	dump := make([]*Event, 0)
+	for {
+		msg := ReplicationMessageType{}
+		err := decoder.Decode(&msg)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		event, err := EventFromReplicationMessageType(msg)
+		if err != nil {
+			return err
+		}
+
+		switch event.Type {
+		case EventTypeConfigure:
+			if event.Config == nil {
+				continue
+			}
+		case EventTypeSet:
+			event.Value.ExpiresAt = event.Value.ExpiresAt.Add(-elapsed)
+			if !event.Value.ExpiresAt.After(now) {
+				continue
+			}
+		case EventTypeLock:
+			event.Lock.ExpiresAt = event.Lock.ExpiresAt.Add(-elapsed)
+			if !event.Lock.ExpiresAt.After(now) {
+				continue
+			}
+		case EventTypeToken, EventTypeVersion:
+			// counters are restored as they are
+		default:
+			continue
+		}
+
+		dump = append(dump, event)
+	}
+
+	s.Replace(dump)
+
+	log.Printf("Loaded snapshot of %v realms and values from %v\n", len(dump), path)
+	return nil
+}
@@ -0,0 +1,167 @@
+/*
+snapshot_test.go
+Tests of saving and loading snapshots.
+
+###################################################################################
+
+MIT License
+
+Copyright (c) 2020 Bruno Hautzenberger
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+//snapshotPath returns the path of a snapshot in a temporary directory, which
+//is removed once the test finished.
+func snapshotPath(t *testing.T) string {
+	t.Helper()
+
+	dir, err := ioutil.TempDir("", "in-memory-db-snapshot")
+	if err != nil {
+		t.Fatalf("TempDir failed: %v", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	return filepath.Join(dir, "snapshot")
+}
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	for _, format := range []DumpFormat{DumpFormatNDJSON, DumpFormatGob} {
+		t.Run(string(format), func(t *testing.T) {
+			path := snapshotPath(t)
+
+			s := newTestStorage(t, StorageConfig{})
+			if err := s.SetRealmConfig("r", RealmConfig{MaxKeys: 10, Indexes: []string{"n"}}); err != nil {
+				t.Fatalf("SetRealmConfig failed: %v", err)
+			}
+			alice := mustSet(t, s, "r", "alice", `{"n":30}`)
+			mustSet(t, s, "r", "bob", `{"n":40}`)
+			mustSet(t, s, "sessions", "deleted", "x")
+			s.Delete("sessions", "deleted")
+			s.AcquireLock("backup", "worker-1", time.Minute)
+			s.AcquireLock("released", "worker-2", time.Minute)
+			s.ReleaseLock("released", "worker-2", 2)
+
+			if err := s.SaveSnapshot(path, format); err != nil {
+				t.Fatalf("SaveSnapshot failed: %v", err)
+			}
+
+			loaded := newTestStorage(t, StorageConfig{})
+			mustSet(t, loaded, "stale", "key", "replaced by the snapshot")
+			if err := loaded.LoadSnapshot(path, format); err != nil {
+				t.Fatalf("LoadSnapshot failed: %v", err)
+			}
+
+			if ok, config := loaded.GetRealmConfig("r"); !ok || config.MaxKeys != 10 {
+				t.Errorf("GetRealmConfig = %v, %+v, expected max keys 10", ok, config)
+			}
+
+			ok, value := loaded.Get("r", "alice")
+			if !ok || value.Value != alice.Value || value.Version != alice.Version {
+				t.Errorf("Get = %v, %+v, expected %+v", ok, value, alice)
+			}
+			if d := value.ExpiresAt.Sub(alice.ExpiresAt); d < -time.Second || d > time.Second {
+				t.Errorf("value expires at %v, expected %v", value.ExpiresAt, alice.ExpiresAt)
+			}
+			expectValue(t, loaded, "r", "bob", `{"n":40}`)
+			expectValue(t, loaded, "stale", "key", "")
+
+			if loaded.LastVersion != s.LastVersion || loaded.LastToken != s.LastToken {
+				t.Errorf("counters are %v and %v, expected %v and %v", loaded.LastVersion, loaded.LastToken, s.LastVersion, s.LastToken)
+			}
+
+			if ok, lock := loaded.GetLock("backup"); !ok || lock.Holder != "worker-1" || lock.Token != 1 {
+				t.Errorf("GetLock = %v, %+v, expected lock of worker-1 with token 1", ok, lock)
+			}
+			if ok, _ := loaded.GetLock("released"); ok {
+				t.Errorf("released lock was loaded")
+			}
+
+			// indexes are built again from the loaded values
+			if keys, _ := queryKeys(t, loaded, Query{Field: "n", Count: 10}); len(keys) != 2 {
+				t.Errorf("index of loaded values has %v keys, expected 2", keys)
+			}
+		})
+	}
+}
+
+func TestSnapshotReducesTTL(t *testing.T) {
+	path := snapshotPath(t)
+
+	s := newTestStorage(t, StorageConfig{})
+	s.Set("sessions", "short", NewValue("x", 30))
+	s.Set("sessions", "long", NewValue("x", 3600))
+	s.AcquireLock("short", "worker", 30*time.Second)
+	s.AcquireLock("long", "worker", time.Hour)
+
+	if err := s.SaveSnapshot(path, DumpFormatNDJSON); err != nil {
+		t.Fatalf("SaveSnapshot failed: %v", err)
+	}
+
+	// the service was down for a minute since the snapshot was written
+	written := time.Now().Add(-time.Minute)
+	if err := os.Chtimes(path, written, written); err != nil {
+		t.Fatalf("Chtimes failed: %v", err)
+	}
+
+	loaded := newTestStorage(t, StorageConfig{})
+	if err := loaded.LoadSnapshot(path, DumpFormatNDJSON); err != nil {
+		t.Fatalf("LoadSnapshot failed: %v", err)
+	}
+
+	expectValue(t, loaded, "sessions", "short", "")
+	if ok, _ := loaded.GetLock("short"); ok {
+		t.Errorf("expired lock was loaded")
+	}
+
+	ok, value := loaded.Get("sessions", "long")
+	if remaining := time.Until(value.ExpiresAt); !ok || remaining > 3540*time.Second || remaining < 3530*time.Second {
+		t.Errorf("value expires in %v, expected 59 minutes", remaining)
+	}
+
+	ok, lock := loaded.GetLock("long")
+	if remaining := time.Until(lock.ExpiresAt); !ok || remaining > 59*time.Minute || remaining < 58*time.Minute {
+		t.Errorf("lock expires in %v, expected 59 minutes", remaining)
+	}
+
+	// tokens are never assigned twice, even if all locks expired
+	if _, lock := loaded.AcquireLock("short", "worker", time.Minute); lock.Token != 3 {
+		t.Errorf("token after loading is %v, expected 3", lock.Token)
+	}
+}
+
+func TestLoadMissingSnapshot(t *testing.T) {
+	s := newTestStorage(t, StorageConfig{})
+	mustSet(t, s, "sessions", "key", "kept")
+
+	if err := s.LoadSnapshot(snapshotPath(t), DumpFormatNDJSON); err != nil {
+		t.Fatalf("LoadSnapshot failed: %v", err)
+	}
+
+	expectValue(t, s, "sessions", "key", "kept")
+}
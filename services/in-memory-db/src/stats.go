@@ -0,0 +1,122 @@
+/*
+stats.go
+Implements operational stats of the storage. Counters are sampled
+periodically, so rates can be calculated over the last minute.
+
+###################################################################################
+
+MIT License
+
+Copyright (c) 2020 Bruno Hautzenberger
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+package main
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+const (
+	//statsSampleInterval is the interval in which counters are sampled.
+	statsSampleInterval = 10 * time.Second
+
+	//statsSampleCount is the number of samples kept to calculate rates.
+	statsSampleCount = 7
+)
+
+//statsSample holds the counters, which are reported as rates, at a point in
+//time.
+type statsSample struct {
+	Time        time.Time
+	Expirations uint64
+	Evictions   uint64
+}
+
+//sampleStats samples the counters of the storage periodically, until the
+//storage is closed.
+func (s *Storage) sampleStats() {
+	ticker := time.NewTicker(statsSampleInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case now := <-ticker.C:
+			s.MutexLock.Lock()
+			s.samples = append(s.samples, statsSample{
+				Time:        now.UTC(),
+				Expirations: s.Stats.Expirations,
+				Evictions:   s.Stats.Evictions,
+			})
+			if len(s.samples) > statsSampleCount {
+				s.samples = s.samples[len(s.samples)-statsSampleCount:]
+			}
+			s.MutexLock.Unlock()
+		}
+	}
+}
+
+//rate calculates the rate per second of a counter since the given sample.
+func rate(current uint64, sampled uint64, since time.Time, now time.Time) float64 {
+	elapsed := now.Sub(since).Seconds()
+	if elapsed <= 0 {
+		return 0
+	}
+
+	return float64(current-sampled) / elapsed
+}
+
+//Info returns the operational stats of the storage.
+func (s *Storage) Info() InfoMessageType {
+	s.MutexLock.RLock()
+	defer s.MutexLock.RUnlock()
+
+	now := time.Now().UTC()
+	info := InfoMessageType{
+		Uptime:         int64(now.Sub(s.StartedAt).Seconds()),
+		Realms:         len(s.Data),
+		KeysPerRealm:   make(map[string]int, len(s.Data)),
+		UsedMemory:     s.UsedMemory,
+		MaxMemory:      s.Config.MaxMemory,
+		Hits:           atomic.LoadUint64(&s.Stats.Hits),
+		Misses:         atomic.LoadUint64(&s.Stats.Misses),
+		Expirations:    s.Stats.Expirations,
+		Evictions:      s.Stats.Evictions,
+		RejectedWrites: s.Stats.RejectedWrites,
+		PendingTimers:  atomic.LoadInt64(&s.PendingTimers),
+	}
+
+	for realmName, realm := range s.Data {
+		info.KeysPerRealm[realmName] = len(realm)
+		info.Keys += len(realm)
+	}
+
+	if info.Hits+info.Misses > 0 {
+		info.HitRatio = float64(info.Hits) / float64(info.Hits+info.Misses)
+	}
+
+	oldest := s.samples[0]
+	info.ExpirationsPerSecond = rate(info.Expirations, oldest.Expirations, oldest.Time, now)
+	info.EvictionsPerSecond = rate(info.Evictions, oldest.Evictions, oldest.Time, now)
+
+	return info
+}
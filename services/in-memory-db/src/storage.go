@@ -31,28 +31,100 @@ package main
 
 import (
 	"log"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
 //StorageInterface defines the interface for the in-memory key/value storage.
 type StorageInterface interface {
-	Initialize()
+	Initialize(config StorageConfig)
 	Get(realmName string, key string) (bool, *Value)
-	Set(realmName string, key string, value *Value)
+	MGet(entries []BatchEntryMessageType) []*Value
+	Set(realmName string, key string, value *Value) error
+	SetIf(realmName string, key string, value *Value, precondition Precondition) (bool, *Value, error)
 	Delete(realmName string, key string) bool
 	Keys(realmName string) []string
 	Realms() []string
+	Scan(realmName string, cursor string, count int, filter ScanFilter) ([]string, string, error)
+	ScanRealms(cursor string, count int, filter ScanFilter) ([]string, string, error)
+	Memory() MemoryMessageType
+	Info() InfoMessageType
+	Transaction(fn func(tx *Transaction) error) error
+	GetRealmConfig(realmName string) (bool, RealmConfig)
+	SetRealmConfig(realmName string, config RealmConfig) error
+	DeleteRealm(realmName string) bool
+	Snapshot() ([]*Event, *Subscription)
+	Export(realm string) []*Event
+	Query(realm string, query Query) ([]QueryResult, string, error)
+	Import(dump []*Event, realm string, mode ImportMode) error
+	ExportLocks() []*Event
+	Replace(snapshot []*Event)
+	Apply(event *Event)
+	Subscribe(pattern string) *Subscription
+	Unsubscribe(subscription *Subscription)
+	Disconnect()
+	Close()
+	SaveSnapshot(path string, format DumpFormat) error
+	LoadSnapshot(path string, format DumpFormat) error
+	GetLock(name string) (bool, *Lock)
+	AcquireLock(name string, holder string, lease time.Duration) (bool, *Lock)
+	RenewLock(name string, holder string, token uint64, lease time.Duration) (bool, *Lock)
+	ReleaseLock(name string, holder string, token uint64) bool
+}
+
+//StorageStats holds counters of the storage. Hits and Misses are updated
+//atomically, because they are counted while the storage is only locked for
+//reading.
+type StorageStats struct {
+	Hits           uint64
+	Misses         uint64
+	Expirations    uint64
+	Evictions      uint64
+	RejectedWrites uint64
 }
 
 //Implements StorageInterface
 type Storage struct {
-	Data map[string]map[string]*Value
+	Data          map[string]map[string]*Value
+	RealmConfigs  map[string]*RealmConfig
+	Indexes       map[string]realmIndex
+	Locks         map[string]*Lock
+	RealmNames    sortedNames
+	KeyNames      map[string]*sortedNames
+	Config        StorageConfig
+	UsedMemory    int64
+	Stats         StorageStats
+	StartedAt     time.Time
+	PendingTimers int64
+	LastVersion   uint64
+	LastToken     uint64
+	MutexLock     sync.RWMutex
+	Events        *EventBus
+	samples       []statsSample
+	stop          chan struct{}
+	stopOnce      sync.Once
 }
 
 //Initialize creates an empty map[string]map[string]*Value (REALM->KEY->VALUE),
-//which will be used to save to store all the data.
-func (s *Storage) Initialize() {
+//which will be used to save to store all the data, the acquired locks, the
+//sorted names of realms and keys used by scans, and the event bus used for
+//keyspace notifications.
+func (s *Storage) Initialize(config StorageConfig) {
+	s.Config = config
 	s.Data = make(map[string]map[string]*Value)
+	s.RealmConfigs = make(map[string]*RealmConfig)
+	s.Indexes = make(map[string]realmIndex)
+	s.Locks = make(map[string]*Lock)
+	s.RealmNames = nil
+	s.KeyNames = make(map[string]*sortedNames)
+	s.Events = &EventBus{}
+	s.Events.Initialize()
+	s.StartedAt = time.Now().UTC()
+	s.samples = []statsSample{{Time: s.StartedAt}}
+	s.stop = make(chan struct{})
+
+	go s.sampleStats()
 }
 
 //GetRealm returns all data of an existing realm as map[string]*Value
@@ -72,16 +144,25 @@ func (s *Storage) CreateRealm(realm string) map[string]*Value {
 	}
 
 	s.Data[realm] = make(map[string]*Value)
+	s.RealmNames.add(realm)
+	s.KeyNames[realm] = &sortedNames{}
 
 	return s.Data[realm]
 }
 
 //CleanEmptyRealm removes all empty realms, because there is no need to
-//keep empty storage spaces.
+//keep empty storage spaces. Configured realms are kept until they are
+//deleted explicitly.
 func (s *Storage) CleanEmptyRealm(realmName string) {
+	if _, ok := s.RealmConfigs[realmName]; ok {
+		return
+	}
+
 	if realm, ok := s.Data[realmName]; ok {
 		if len(realm) == 0 {
 			delete(s.Data, realmName)
+			s.RealmNames.remove(realmName)
+			delete(s.KeyNames, realmName)
 		}
 	}
 }
@@ -90,6 +171,43 @@ func (s *Storage) CleanEmptyRealm(realmName string) {
 //First bool return value determines, if a Value with these identifiers
 //was found, if false Valiue will be nil.
 func (s *Storage) Get(realmName string, key string) (bool, *Value) {
+	s.MutexLock.RLock()
+	defer s.MutexLock.RUnlock()
+
+	ok, value := s.get(realmName, key)
+	if ok {
+		value.touch()
+		atomic.AddUint64(&s.Stats.Hits, 1)
+	} else {
+		atomic.AddUint64(&s.Stats.Misses, 1)
+	}
+
+	return ok, value
+}
+
+//MGet loads multiple values at the same point in time. The values are
+//returned in the order of the entries, values, which were not found, are nil.
+func (s *Storage) MGet(entries []BatchEntryMessageType) []*Value {
+	s.MutexLock.RLock()
+	defer s.MutexLock.RUnlock()
+
+	values := make([]*Value, 0, len(entries))
+	for _, entry := range entries {
+		ok, value := s.get(entry.Realm, entry.Key)
+		if ok {
+			value.touch()
+			atomic.AddUint64(&s.Stats.Hits, 1)
+		} else {
+			atomic.AddUint64(&s.Stats.Misses, 1)
+		}
+		values = append(values, value)
+	}
+
+	return values
+}
+
+//get loads a single Value without locking the storage.
+func (s *Storage) get(realmName string, key string) (bool, *Value) {
 	ok, realm := s.GetRealm(realmName)
 	if !ok {
 		return false, nil
@@ -104,37 +222,161 @@ func (s *Storage) Get(realmName string, key string) (bool, *Value) {
 
 //Set creates or replaces a Value, identified by given realm and key,
 //and deletes it, using a go routine that is delayed by given expiration
-//time.
-func (s *Storage) Set(realmName string, key string, value *Value) {
+//time. It returns ErrOutOfMemory, if the memory limit is reached and no
+//value could be evicted, or an error if the value violates the limits of its
+//realm.
+func (s *Storage) Set(realmName string, key string, value *Value) error {
+	s.MutexLock.Lock()
+	defer s.MutexLock.Unlock()
+
+	return s.set(realmName, key, value)
+}
+
+//SetIf works like Set, but only stores the Value if the currently stored
+//Value meets the given precondition. It returns false and the current Value,
+//which might be nil, if the precondition failed.
+func (s *Storage) SetIf(realmName string, key string, value *Value, precondition Precondition) (bool, *Value, error) {
+	s.MutexLock.Lock()
+	defer s.MutexLock.Unlock()
+
+	_, current := s.get(realmName, key)
+	if !precondition.Check(current) {
+		return false, current, nil
+	}
+
+	if err := s.set(realmName, key, value); err != nil {
+		return false, current, err
+	}
+
+	return true, value, nil
+}
+
+//set stores a Value without locking the storage. It assigns the next
+//version to the Value and replaces the expiration timer of a previously
+//stored Value.
+func (s *Storage) set(realmName string, key string, value *Value) error {
+	except := func(r string, k string) bool {
+		return r == realmName && k == key
+	}
+
+	if err := s.prepareValue(realmName, value); err != nil {
+		return err
+	}
+
+	_, current := s.get(realmName, key)
+
+	value.size = entrySize(realmName, key, value)
+	currentSize := int64(0)
+	plan := s.newEvictionPlan(except)
+	if current != nil {
+		currentSize = current.size
+	} else if err := plan.reserveRealmKeys(realmName, 1); err != nil {
+		return err
+	}
+
+	if err := plan.reserveMemory(value.size - currentSize); err != nil {
+		return err
+	}
+	plan.evict()
+
+	s.LastVersion++
+	value.Version = s.LastVersion
+	s.store(realmName, key, value, current)
+
+	return nil
+}
+
+//store puts a prepared and versioned Value into its realm, replaces the
+//current Value, which might be nil, and starts the timer, that deletes the
+//Value after it expired.
+func (s *Storage) store(realmName string, key string, value *Value, current *Value) {
 	ok, realm := s.GetRealm(realmName)
 	if !ok {
 		realm = s.CreateRealm(realmName)
 	}
 
+	value.size = entrySize(realmName, key, value)
+	if current != nil {
+		s.stopExpiration(current)
+		s.UsedMemory -= current.size
+	}
+
+	value.touch()
+	if _, ok := realm[key]; !ok {
+		s.KeyNames[realmName].add(key)
+	}
 	realm[key] = value
+	s.UsedMemory += value.size
+	s.indexValue(realmName, key, value)
 
-	expiresIn := (int)(value.ExpiresAt.Sub(time.Now().UTC()).Seconds())
+	expiresIn := value.ExpiresAt.Sub(time.Now().UTC())
 
 	expireFunc := func() {
-		delete(realm, key)
-		s.CleanEmptyRealm(realmName)
-		log.Printf("Deleted key %v after %v seconds\n", key, expiresIn)
+		atomic.AddInt64(&s.PendingTimers, -1)
+		s.expire(realmName, key, value)
 	}
 
-	time.AfterFunc(time.Duration(expiresIn)*time.Second, expireFunc)
-	log.Printf("Set key %v. It will Expire in %v seconds\n", key, expiresIn)
+	atomic.AddInt64(&s.PendingTimers, 1)
+	value.timer = time.AfterFunc(expiresIn, expireFunc)
+	log.Printf("Set key %v. It will Expire in %v seconds\n", key, (int)(expiresIn.Seconds()))
+
+	s.notify(EventTypeSet, realmName, key, value)
+}
+
+//stopExpiration stops the expiration timer of a Value, which is replaced or
+//deleted, and counts it as no longer pending.
+func (s *Storage) stopExpiration(value *Value) {
+	if value.StopExpiration() {
+		atomic.AddInt64(&s.PendingTimers, -1)
+	}
+}
+
+//expire deletes the given Value after it expired, but only if it was not
+//replaced or deleted in the meantime.
+func (s *Storage) expire(realmName string, key string, value *Value) {
+	s.MutexLock.Lock()
+	defer s.MutexLock.Unlock()
+
+	ok, current := s.get(realmName, key)
+	if !ok || current != value {
+		return
+	}
+
+	s.delete(realmName, key)
+	s.Stats.Expirations++
+	log.Printf("Deleted key %v after it expired\n", key)
+
+	s.notify(EventTypeExpire, realmName, key, nil)
 }
 
 //Delete deletes a Value, identified by given realm and key.
 //It returns false, if the was no value matching these identifiers.
 func (s *Storage) Delete(realmName string, key string) bool {
+	s.MutexLock.Lock()
+	defer s.MutexLock.Unlock()
+
+	if !s.delete(realmName, key) {
+		return false
+	}
+
+	s.notify(EventTypeDelete, realmName, key, nil)
+
+	return true
+}
+
+//delete deletes a Value without locking the storage.
+func (s *Storage) delete(realmName string, key string) bool {
 	ok, realm := s.GetRealm(realmName)
 	if !ok {
 		return false
 	}
 
-	if _, ok := realm[key]; ok {
+	if value, ok := realm[key]; ok {
+		s.stopExpiration(value)
+		s.UsedMemory -= value.size
 		delete(realm, key)
+		s.KeyNames[realmName].remove(key)
+		s.unindexValue(realmName, key)
 		s.CleanEmptyRealm(realmName)
 		return true
 	}
@@ -145,6 +387,9 @@ func (s *Storage) Delete(realmName string, key string) bool {
 //Keys returns all keys in a realm. It returns an empty list
 //if the realm does not exist.
 func (s *Storage) Keys(realmName string) []string {
+	s.MutexLock.RLock()
+	defer s.MutexLock.RUnlock()
+
 	ok, realm := s.GetRealm(realmName)
 	if !ok {
 		return make([]string, 0)
@@ -160,6 +405,9 @@ func (s *Storage) Keys(realmName string) []string {
 
 //Realms returns all realm names.
 func (s *Storage) Realms() []string {
+	s.MutexLock.RLock()
+	defer s.MutexLock.RUnlock()
+
 	keys := make([]string, 0, len(s.Data))
 	for k := range s.Data {
 		keys = append(keys, k)
@@ -167,3 +415,75 @@ func (s *Storage) Realms() []string {
 
 	return keys
 }
+
+//Memory returns the approximate memory usage, the memory limit and the
+//eviction counters of the storage.
+func (s *Storage) Memory() MemoryMessageType {
+	s.MutexLock.RLock()
+	defer s.MutexLock.RUnlock()
+
+	return MemoryMessageType{
+		UsedMemory:     s.UsedMemory,
+		MaxMemory:      s.Config.MaxMemory,
+		EvictionPolicy: s.Config.EvictionPolicy,
+		Evictions:      s.Stats.Evictions,
+		RejectedWrites: s.Stats.RejectedWrites,
+	}
+}
+
+//Subscribe creates a new subscription for keyspace notifications of all
+//keys matching given "realm/key" pattern.
+func (s *Storage) Subscribe(pattern string) *Subscription {
+	return s.Events.Subscribe(pattern)
+}
+
+//Unsubscribe removes a subscription for keyspace notifications.
+func (s *Storage) Unsubscribe(subscription *Subscription) {
+	s.Events.Unsubscribe(subscription)
+}
+
+//Disconnect ends all subscriptions, so streams of keyspace notifications and
+//the replication stream end on shutdown.
+func (s *Storage) Disconnect() {
+	s.Events.Close()
+}
+
+//Close stops the expiration timers of all values and locks and the sampling
+//of stats on shutdown. The values and locks are kept, so they can still be
+//saved in a snapshot.
+func (s *Storage) Close() {
+	s.MutexLock.Lock()
+	defer s.MutexLock.Unlock()
+
+	s.stopOnce.Do(func() {
+		close(s.stop)
+	})
+
+	for _, realm := range s.Data {
+		for _, value := range realm {
+			s.stopExpiration(value)
+		}
+	}
+	s.stopLocks()
+}
+
+//notify publishes a keyspace notification for given realm and key.
+func (s *Storage) notify(eventType EventType, realmName string, key string, value *Value) {
+	s.Events.Publish(&Event{
+		Type:  eventType,
+		Topic: realmName + "/" + key,
+		Realm: realmName,
+		Key:   key,
+		Value: value,
+	})
+}
+
+//notifyRealm publishes a notification about the configuration of a realm.
+func (s *Storage) notifyRealm(eventType EventType, realmName string, config *RealmConfig) {
+	s.Events.Publish(&Event{
+		Type:   eventType,
+		Topic:  realmName + "/",
+		Realm:  realmName,
+		Config: config,
+	})
+}
@@ -0,0 +1,57 @@
+/*
+storage_test.go
+Helpers shared by the tests of the in-memory-db storage.
+
+###################################################################################
+
+MIT License
+
+Copyright (c) 2020 Bruno Hautzenberger
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+package main
+
+import (
+	"testing"
+)
+
+//newTestStorage creates an initialized Storage, which is closed once the
+//test finished.
+func newTestStorage(t *testing.T, config StorageConfig) *Storage {
+	t.Helper()
+
+	s := &Storage{}
+	s.Initialize(config)
+	t.Cleanup(s.Close)
+
+	return s
+}
+
+//mustSet stores a value, which expires in a minute, or fails the test.
+func mustSet(t *testing.T, s *Storage, realmName string, key string, value string) *Value {
+	t.Helper()
+
+	v := NewValue(value, 60)
+	if err := s.Set(realmName, key, v); err != nil {
+		t.Fatalf("Set(%v, %v) failed: %v", realmName, key, err)
+	}
+
+	return v
+}
@@ -0,0 +1,211 @@
+/*
+transaction.go
+Implements transactions, which apply a list of reads and writes atomically.
+All writes of a transaction are buffered and only applied, if the whole
+transaction succeeded, so either all or none of them are stored.
+
+###################################################################################
+
+MIT License
+
+Copyright (c) 2020 Bruno Hautzenberger
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+package main
+
+//transactionWrite is a single buffered write of a transaction. A nil Value
+//means, that the value is deleted. Overwritten holds the values, which were
+//set by earlier writes of the transaction and replaced by this write.
+type transactionWrite struct {
+	Realm       string
+	Key         string
+	Value       *Value
+	Overwritten []*Value
+}
+
+//Transaction buffers reads and writes, which are applied atomically, while
+//the storage is locked.
+type Transaction struct {
+	storage *Storage
+	writes  map[string]*transactionWrite
+	order   []string
+}
+
+//Transaction locks the storage and runs given function with a new
+//Transaction. All writes of the transaction are applied, if the function
+//returns without an error. Otherwise nothing is changed and the error is
+//returned.
+func (s *Storage) Transaction(fn func(tx *Transaction) error) error {
+	s.MutexLock.Lock()
+	defer s.MutexLock.Unlock()
+
+	tx := s.newTransaction()
+	if err := fn(tx); err != nil {
+		return err
+	}
+
+	return tx.commit()
+}
+
+//newTransaction creates a Transaction, that has to be committed while the
+//storage is locked.
+func (s *Storage) newTransaction() *Transaction {
+	return &Transaction{
+		storage: s,
+		writes:  make(map[string]*transactionWrite),
+		order:   make([]string, 0),
+	}
+}
+
+//Get loads a single Value identified by realm and key, including all
+//writes of this transaction so far.
+func (t *Transaction) Get(realmName string, key string) (bool, *Value) {
+	if write, ok := t.writes[realmName+"/"+key]; ok {
+		return write.Value != nil, write.Value
+	}
+
+	ok, value := t.storage.get(realmName, key)
+	if ok {
+		value.touch()
+	}
+
+	return ok, value
+}
+
+//Set creates or replaces a Value, when the transaction is committed. It
+//returns an error, if the value violates the limits of its realm.
+func (t *Transaction) Set(realmName string, key string, value *Value) error {
+	if err := t.storage.prepareValue(realmName, value); err != nil {
+		return err
+	}
+
+	t.write(realmName, key, value)
+
+	return nil
+}
+
+//Delete deletes a Value, when the transaction is committed.
+//It returns false, if the was no value matching these identifiers.
+func (t *Transaction) Delete(realmName string, key string) bool {
+	ok, _ := t.Get(realmName, key)
+	if ok {
+		t.write(realmName, key, nil)
+	}
+
+	return ok
+}
+
+//write buffers a write, replacing earlier writes of the same value.
+func (t *Transaction) write(realmName string, key string, value *Value) {
+	id := realmName + "/" + key
+	previous, ok := t.writes[id]
+	if !ok {
+		t.order = append(t.order, id)
+		previous = &transactionWrite{}
+	}
+
+	overwritten := previous.Overwritten
+	if previous.Value != nil {
+		overwritten = append(overwritten, previous.Value)
+	}
+
+	t.writes[id] = &transactionWrite{
+		Realm:       realmName,
+		Key:         key,
+		Value:       value,
+		Overwritten: overwritten,
+	}
+}
+
+//isWritten checks if the value identified by realm and key is written by
+//this transaction.
+func (t *Transaction) isWritten(realmName string, key string) bool {
+	_, ok := t.writes[realmName+"/"+key]
+	return ok
+}
+
+//commit applies all buffered writes. The keys and memory needed by all
+//writes are reserved up front, so the writes can't fail halfway through and
+//nothing is evicted, if the reservation fails. Once the reservation
+//succeeded, the writes are stored without reserving again.
+//Deletes are applied first to free their keys and memory. Values overwritten
+//within the transaction get the version of the final write of their key.
+func (t *Transaction) commit() error {
+	s := t.storage
+
+	size := int64(0)
+	keys := make(map[string]int)
+	created := make(map[string]bool)
+	for _, write := range t.writes {
+		_, current := s.get(write.Realm, write.Key)
+		if current != nil {
+			size -= current.size
+			keys[write.Realm]--
+		}
+
+		if write.Value != nil {
+			size += entrySize(write.Realm, write.Key, write.Value)
+			keys[write.Realm]++
+			created[write.Realm] = created[write.Realm] || current == nil
+		}
+	}
+
+	// only realms, in which keys are created, are checked, like single writes.
+	// Values are only evicted, once all keys and memory could be reserved.
+	plan := s.newEvictionPlan(t.isWritten)
+	for realmName, count := range keys {
+		if !created[realmName] {
+			continue
+		}
+
+		if err := plan.reserveRealmKeys(realmName, count); err != nil {
+			return err
+		}
+	}
+
+	if err := plan.reserveMemory(size); err != nil {
+		return err
+	}
+	plan.evict()
+
+	for _, id := range t.order {
+		if write := t.writes[id]; write.Value == nil && s.delete(write.Realm, write.Key) {
+			s.notify(EventTypeDelete, write.Realm, write.Key, nil)
+		}
+	}
+
+	for _, id := range t.order {
+		write := t.writes[id]
+		if write.Value == nil {
+			continue
+		}
+
+		_, current := s.get(write.Realm, write.Key)
+		s.LastVersion++
+		write.Value.Version = s.LastVersion
+		s.store(write.Realm, write.Key, write.Value, current)
+
+		for _, value := range write.Overwritten {
+			value.Version = write.Value.Version
+		}
+	}
+
+	return nil
+}
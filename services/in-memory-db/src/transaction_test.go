@@ -0,0 +1,220 @@
+/*
+transaction_test.go
+Tests of atomic transactions.
+
+###################################################################################
+
+MIT License
+
+Copyright (c) 2020 Bruno Hautzenberger
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+//expectValue fails the test, if the stored value of a key differs from the
+//expected value. An empty expected value means, that the key must not exist.
+func expectValue(t *testing.T, s *Storage, realmName string, key string, expected string) {
+	t.Helper()
+
+	ok, value := s.Get(realmName, key)
+	switch {
+	case len(expected) == 0 && ok:
+		t.Errorf("%v/%v is %q, expected it to be deleted", realmName, key, value.Value)
+	case len(expected) > 0 && !ok:
+		t.Errorf("%v/%v is missing, expected %q", realmName, key, expected)
+	case ok && value.Value != expected:
+		t.Errorf("%v/%v is %q, expected %q", realmName, key, value.Value, expected)
+	}
+}
+
+func TestTransactionCommit(t *testing.T) {
+	s := newTestStorage(t, StorageConfig{})
+	mustSet(t, s, "r", "a", "1")
+	mustSet(t, s, "r", "b", "2")
+
+	err := s.Transaction(func(tx *Transaction) error {
+		if !tx.Delete("r", "a") {
+			t.Error("Delete of existing key returned false")
+		}
+		if tx.Delete("r", "missing") {
+			t.Error("Delete of missing key returned true")
+		}
+		if err := tx.Set("r", "c", NewValue("3", 60)); err != nil {
+			return err
+		}
+		if ok, value := tx.Get("r", "c"); !ok || value.Value != "3" {
+			t.Error("Get does not see the writes of the transaction")
+		}
+		if ok, _ := tx.Get("r", "a"); ok {
+			t.Error("Get does not see the deletes of the transaction")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectValue(t, s, "r", "a", "")
+	expectValue(t, s, "r", "b", "2")
+	expectValue(t, s, "r", "c", "3")
+}
+
+func TestTransactionRollback(t *testing.T) {
+	s := newTestStorage(t, StorageConfig{})
+	mustSet(t, s, "r", "a", "1")
+	failed := errors.New("failed")
+
+	err := s.Transaction(func(tx *Transaction) error {
+		tx.Delete("r", "a")
+		tx.Set("r", "b", NewValue("2", 60))
+		return failed
+	})
+	if err != failed {
+		t.Errorf("Transaction returned %v, expected %v", err, failed)
+	}
+
+	expectValue(t, s, "r", "a", "1")
+	expectValue(t, s, "r", "b", "")
+}
+
+func TestTransactionGrowAndShrinkWithoutEviction(t *testing.T) {
+	s := newTestStorage(t, StorageConfig{EvictionPolicy: EvictionPolicyNoEviction})
+	mustSet(t, s, "r", "x", strings.Repeat("x", 100))
+	mustSet(t, s, "r", "y", strings.Repeat("y", 10))
+	mustSet(t, s, "r", "z", "z")
+	s.Config.MaxMemory = s.UsedMemory
+
+	// y grows before x shrinks by the same size, so only the whole
+	// transaction fits into memory
+	err := s.Transaction(func(tx *Transaction) error {
+		tx.Set("r", "y", NewValue(strings.Repeat("y", 100), 60))
+		tx.Set("r", "x", NewValue(strings.Repeat("x", 10), 60))
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Transaction failed: %v", err)
+	}
+
+	expectValue(t, s, "r", "x", strings.Repeat("x", 10))
+	expectValue(t, s, "r", "y", strings.Repeat("y", 100))
+	if s.UsedMemory != s.Config.MaxMemory {
+		t.Errorf("Used memory is %v, expected %v", s.UsedMemory, s.Config.MaxMemory)
+	}
+}
+
+func TestTransactionOutOfMemoryIsAllOrNothing(t *testing.T) {
+	s := newTestStorage(t, StorageConfig{EvictionPolicy: EvictionPolicyNoEviction})
+	mustSet(t, s, "r", "x", "x")
+	mustSet(t, s, "r", "y", "y")
+	s.Config.MaxMemory = s.UsedMemory
+
+	err := s.Transaction(func(tx *Transaction) error {
+		tx.Delete("r", "x")
+		tx.Set("r", "z", NewValue("z", 60))
+		tx.Set("r", "y", NewValue(strings.Repeat("y", 100), 60))
+		return nil
+	})
+	if err != ErrOutOfMemory {
+		t.Errorf("Transaction returned %v, expected %v", err, ErrOutOfMemory)
+	}
+
+	expectValue(t, s, "r", "x", "x")
+	expectValue(t, s, "r", "y", "y")
+	expectValue(t, s, "r", "z", "")
+}
+
+func TestTransactionRealmKeyLimit(t *testing.T) {
+	s := newTestStorage(t, StorageConfig{})
+	if err := s.SetRealmConfig("r", RealmConfig{MaxKeys: 2}); err != nil {
+		t.Fatal(err)
+	}
+	mustSet(t, s, "r", "a", "1")
+	mustSet(t, s, "r", "b", "2")
+
+	err := s.Transaction(func(tx *Transaction) error {
+		tx.Delete("r", "a")
+		return tx.Set("r", "c", NewValue("3", 60))
+	})
+	if err != nil {
+		t.Fatalf("Replacing a key failed: %v", err)
+	}
+
+	err = s.Transaction(func(tx *Transaction) error {
+		tx.Set("r", "d", NewValue("4", 60))
+		return tx.Set("r", "b", NewValue("5", 60))
+	})
+	if err != ErrRealmKeyLimit {
+		t.Errorf("Transaction returned %v, expected %v", err, ErrRealmKeyLimit)
+	}
+
+	expectValue(t, s, "r", "a", "")
+	expectValue(t, s, "r", "b", "2")
+	expectValue(t, s, "r", "c", "3")
+	expectValue(t, s, "r", "d", "")
+}
+
+func TestTransactionVersions(t *testing.T) {
+	s := newTestStorage(t, StorageConfig{})
+	mustSet(t, s, "r", "a", "1")
+
+	first := NewValue("first", 60)
+	second := NewValue("second", 60)
+	other := NewValue("other", 60)
+	err := s.Transaction(func(tx *Transaction) error {
+		tx.Set("r", "a", first)
+		tx.Set("r", "b", other)
+		tx.Set("r", "a", second)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, stored := s.Get("r", "a")
+	if stored != second || second.Version == 0 {
+		t.Errorf("Stored %q with version %v, expected %q with a version", stored.Value, stored.Version, second.Value)
+	}
+	if first.Version != second.Version {
+		t.Errorf("Overwritten value has version %v, expected version %v of the final write", first.Version, second.Version)
+	}
+	if other.Version == 0 || other.Version == second.Version {
+		t.Errorf("Other value has version %v, expected its own version", other.Version)
+	}
+}
+
+func TestMGetCountsReads(t *testing.T) {
+	s := newTestStorage(t, StorageConfig{})
+	mustSet(t, s, "r", "a", "1")
+
+	values := s.MGet([]BatchEntryMessageType{{Realm: "r", Key: "a"}, {Realm: "r", Key: "missing"}, {Realm: "missing", Key: "a"}})
+	if len(values) != 3 || values[0] == nil || values[0].Value != "1" || values[1] != nil || values[2] != nil {
+		t.Errorf("MGet returned %v, expected the value of a and two misses", values)
+	}
+
+	if s.Stats.Hits != 1 || s.Stats.Misses != 2 {
+		t.Errorf("Counted %v hits and %v misses, expected 1 hit and 2 misses", s.Stats.Hits, s.Stats.Misses)
+	}
+}
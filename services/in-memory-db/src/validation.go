@@ -0,0 +1,140 @@
+/*
+validation.go
+Implements the validation of names and fields of requests. Invalid fields are
+collected, so all of them can be reported at once.
+
+###################################################################################
+
+MIT License
+
+Copyright (c) 2020 Bruno Hautzenberger
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	//MaxRealmLength is the maximum length of realm names in bytes.
+	MaxRealmLength = 64
+
+	//MaxKeyLength is the maximum length of keys in bytes.
+	MaxKeyLength = 512
+
+	//MaxExpiresIn is the maximum expiration of values in seconds (10 years).
+	MaxExpiresIn = 10 * 365 * 24 * 60 * 60
+)
+
+//FieldError describes why a single field of a request is invalid.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+//ValidationError is returned, if fields of a request are invalid. It holds
+//a FieldError for every invalid field.
+type ValidationError struct {
+	ErrorMessage
+	Fields []FieldError `json:"fields"`
+}
+
+//Validator collects the errors of all invalid fields of a request.
+type Validator struct {
+	Fields []FieldError
+}
+
+//Fail adds an error for given field.
+func (v *Validator) Fail(field string, format string, args ...interface{}) {
+	v.Fields = append(v.Fields, FieldError{field, fmt.Sprintf(format, args...)})
+}
+
+//Realm checks that a realm name is 1 to MaxRealmLength characters long, only
+//contains letters, digits and "-", "_", ".", ":" and "@" and is not "v1",
+//which is the prefix of the versioned api.
+func (v *Validator) Realm(field string, realm string) {
+	switch {
+	case len(realm) == 0:
+		v.Fail(field, "Realm is missing")
+	case len(realm) > MaxRealmLength:
+		v.Fail(field, "Realm exceeds %v bytes", MaxRealmLength)
+	case realm == "." || realm == "..":
+		v.Fail(field, "Realm can't be %q", realm)
+	case realm == "v1":
+		v.Fail(field, "Realm can't be %q, because it is reserved for the versioned api", realm)
+	default:
+		for _, c := range realm {
+			if c > unicode.MaxASCII || !(unicode.IsLetter(c) || unicode.IsDigit(c) || strings.ContainsRune("-_.:@", c)) {
+				v.Fail(field, "Realm contains invalid character %q", c)
+				return
+			}
+		}
+	}
+}
+
+//Key checks that a key is 1 to MaxKeyLength bytes of UTF-8 and contains
+//neither "/" nor control characters.
+func (v *Validator) Key(field string, key string) {
+	switch {
+	case len(key) == 0:
+		v.Fail(field, "Key is missing")
+	case len(key) > MaxKeyLength:
+		v.Fail(field, "Key exceeds %v bytes", MaxKeyLength)
+	case !utf8.ValidString(key):
+		v.Fail(field, "Key is no valid UTF-8")
+	default:
+		for _, c := range key {
+			if c == '/' || unicode.IsControl(c) {
+				v.Fail(field, "Key contains invalid character %q", c)
+				return
+			}
+		}
+	}
+}
+
+//ExpiresIn checks that an expiration is between 0 and MaxExpiresIn seconds.
+func (v *Validator) ExpiresIn(field string, expiresIn int) {
+	if expiresIn < 0 || expiresIn > MaxExpiresIn {
+		v.Fail(field, "Expiration must be between 0 and %v seconds", MaxExpiresIn)
+	}
+}
+
+//Err returns a ValidationError with all invalid fields, or nil, if all fields
+//are valid.
+func (v *Validator) Err() error {
+	if len(v.Fields) == 0 {
+		return nil
+	}
+
+	message := fmt.Sprintf("Invalid %v: %v", v.Fields[0].Field, v.Fields[0].Message)
+	if len(v.Fields) > 1 {
+		message = fmt.Sprintf("%v fields are invalid", len(v.Fields))
+	}
+
+	return ValidationError{
+		ErrorMessage{message, http.StatusBadRequest, ErrorCodeValidationFailed},
+		v.Fields,
+	}
+}
@@ -31,30 +31,107 @@ SOFTWARE.
 package main
 
 import (
+	"encoding/base64"
 	"encoding/json"
+	"fmt"
 	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"time"
+	"unicode/utf8"
 )
 
+//EncodingBase64 is the encoding of values in JSON messages, that contain
+//binary data.
+const EncodingBase64 = "base64"
+
 // Values implements a single key/value instance, which is saved to the key/value
-// storage.
+// storage. Value may contain arbitrary bytes. ContentType is only set for
+// values, which were stored as raw request body.
 type Value struct {
-	Value     string
-	ExpiresAt time.Time
+	Value       string
+	ContentType string
+	ExpiresAt   time.Time
+	Version     uint64
+	timer       *time.Timer
+
+	// approximate memory usage and access statistics used for evictions
+	size        int64
+	lastAccess  int64
+	accessCount uint64
+}
+
+//MediaType returns the content type of this Value without parameters. Values
+//without content type are application/octet-stream like HTTP bodies without
+//content type.
+func (v *Value) MediaType() string {
+	if len(v.ContentType) == 0 {
+		return "application/octet-stream"
+	}
+
+	mediaType, _, err := mime.ParseMediaType(v.ContentType)
+	if err != nil {
+		return strings.ToLower(strings.TrimSpace(strings.Split(v.ContentType, ";")[0]))
+	}
+
+	return mediaType
+}
+
+//touch records an access of this Value. It is safe to be called while the
+//storage is only locked for reading.
+func (v *Value) touch() {
+	atomic.StoreInt64(&v.lastAccess, time.Now().UnixNano())
+	atomic.AddUint64(&v.accessCount, 1)
+}
+
+//LastAccess returns the time this Value was last read or written.
+func (v *Value) LastAccess() time.Time {
+	return time.Unix(0, atomic.LoadInt64(&v.lastAccess))
+}
+
+//AccessCount returns how often this Value was read or written.
+func (v *Value) AccessCount() uint64 {
+	return atomic.LoadUint64(&v.accessCount)
+}
+
+//ETag returns the entity tag of this Value, which is its quoted version.
+func (v *Value) ETag() string {
+	return fmt.Sprintf("\"%v\"", v.Version)
+}
+
+//StopExpiration stops the timer that deletes this Value after it expired.
+//This is used whenever a Value is replaced or deleted before it expired.
+//It returns true, if the timer was stopped before it fired.
+func (v *Value) StopExpiration() bool {
+	if v.timer != nil {
+		return v.timer.Stop()
+	}
+
+	return false
 }
 
 //ToValueMessageType transforms a Value instance to a ValueMessageType that can
 //be converted to json and served via the api. It also takes care of setting the
 //right remaining expire time in seconds.
 func (v *Value) ToValueMessageType() ValueMessageType {
+	value, encoding := encodeValue(v.Value)
+
 	return ValueMessageType{
-		Value:     v.Value,
-		ExpiresIn: (int)(v.ExpiresAt.Sub(time.Now().UTC()).Seconds()),
+		Value:       value,
+		ExpiresIn:   (int)(v.ExpiresAt.Sub(time.Now().UTC()).Seconds()),
+		Version:     v.Version,
+		ContentType: v.ContentType,
+		Encoding:    encoding,
 	}
 }
 
 //ValueFromValueMessageType creates a Value from the JSON message in the
-//request body and converts the given seconds into a time instance.
+//request body and converts the given seconds into a time instance. It returns
+//a ValidationError, if the expiration is invalid.
 func ValueFromValueMessageType(body io.ReadCloser) (*Value, error) {
 	msg := ValueMessageType{}
 
@@ -63,10 +140,92 @@ func ValueFromValueMessageType(body io.ReadCloser) (*Value, error) {
 		return nil, err
 	}
 
-	value := &Value{
-		Value:     msg.Value,
-		ExpiresAt: time.Now().UTC().Add(time.Duration(msg.ExpiresIn) * time.Second),
+	v := Validator{}
+	v.ExpiresIn("expires-in", msg.ExpiresIn)
+	if err := v.Err(); err != nil {
+		return nil, err
 	}
 
+	decoded, err := decodeValue(msg.Value, msg.Encoding)
+	if err != nil {
+		return nil, err
+	}
+
+	value := NewValue(decoded, msg.ExpiresIn)
+	value.ContentType = msg.ContentType
+
 	return value, nil
 }
+
+//ValueFromRawBody creates a Value from the raw request body. The content type
+//of the request is stored with the value and expires-in is read from the
+//query parameter of the same name. Without expires-in given default TTL of
+//the realm is used. It returns a ValidationError, if the expiration is
+//invalid or missing, because the realm has no default TTL.
+func ValueFromRawBody(r *http.Request, defaultTTL int) (*Value, error) {
+	v := Validator{}
+	expiresIn := 0
+	if param := r.URL.Query().Get("expires-in"); len(param) > 0 {
+		var err error
+		expiresIn, err = strconv.Atoi(param)
+		if err != nil {
+			v.Fail("expires-in", "Expiration is no number")
+		}
+	} else if defaultTTL == 0 {
+		v.Fail("expires-in", "Expiration is required, because the realm has no default TTL")
+	} else {
+		expiresIn = defaultTTL
+	}
+
+	v.ExpiresIn("expires-in", expiresIn)
+	if err := v.Err(); err != nil {
+		return nil, err
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	value := NewValue(string(body), expiresIn)
+	value.ContentType = r.Header.Get("Content-Type")
+
+	return value, nil
+}
+
+//encodeValue returns given value as it can be sent in JSON messages and its
+//encoding. Values, that are no valid UTF-8, are base64 encoded.
+func encodeValue(value string) (string, string) {
+	if utf8.ValidString(value) {
+		return value, ""
+	}
+
+	return base64.StdEncoding.EncodeToString([]byte(value)), EncodingBase64
+}
+
+//decodeValue decodes a value of a JSON message with given encoding.
+func decodeValue(value string, encoding string) (string, error) {
+	switch encoding {
+	case "":
+		return value, nil
+	case EncodingBase64:
+		decoded, err := base64.StdEncoding.DecodeString(value)
+		return string(decoded), err
+	}
+
+	return "", fmt.Errorf("Unknown encoding %v", encoding)
+}
+
+//NewValue creates a Value, which expires in given number of seconds. If
+//expiresIn is 0, the default TTL of the realm is used, when it is stored.
+func NewValue(value string, expiresIn int) *Value {
+	v := &Value{
+		Value: value,
+	}
+
+	if expiresIn != 0 {
+		v.ExpiresAt = time.Now().UTC().Add(time.Duration(expiresIn) * time.Second)
+	}
+
+	return v
+}